@@ -1,538 +1,402 @@
 package main
 
 import (
+	"bufio"
+	"flag"
 	"fmt"
 	"image/color"
 	"log"
-	"math/rand"
+	"math"
 	"os"
+	"path/filepath"
 	"strings"
-	"time"
 
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/hajimehoshi/ebiten/v2/audio"
 	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
 	"github.com/hajimehoshi/ebiten/v2/inpututil"
 	"github.com/hajimehoshi/ebiten/v2/text"
+	"github.com/yukinarit/ebiten8/chip8"
+	"github.com/yukinarit/ebiten8/octo"
 	"golang.org/x/image/font"
 	"golang.org/x/image/font/opentype"
 )
 
 const (
-	V_PIXELS     = 32
-	H_PIXELS     = 64
 	SCALE        = 10
-	WIDTH        = H_PIXELS * SCALE
-	HEIGHT       = V_PIXELS * SCALE
+	WIDTH        = chip8.H_PIXELS * SCALE
+	HEIGHT       = chip8.V_PIXELS * SCALE
 	BUTTON_WIDTH = 80 // Button width of Game Select UI
 	BUTTON_HIGHT = 23 // Button height of Game Select UI
 	SELECT_HIGHT = 45 // Title height of Game Select UI
 )
 
-// A pixel in Chip8 console.
-type Pixel struct {
-	x      int
-	y      int
-	enable bool
-}
+// How the display is scaled to the window.
+type ScaleMode int
 
-func (p *Pixel) image() *ebiten.Image {
-	img := ebiten.NewImage(10, 10)
-	if p.enable {
-		img.Fill(color.White)
-	} else {
-		img.Fill(color.Black)
-	}
-	return img
-}
+const (
+	SCALE_FIT     ScaleMode = iota // Largest scale that fits, fractional.
+	SCALE_INTEGER                  // Largest whole multiple that fits.
+)
 
-func (p *Pixel) Draw(screen *ebiten.Image) {
-	opts := &ebiten.DrawImageOptions{}
-	opts.GeoM.Translate(float64(10*p.x), float64(10*p.y))
-	screen.DrawImage(p.image(), opts)
+// Geometry drawing a w*h image centered at the largest scale that fits in a
+// sw*sh screen, keeping its aspect ratio and letterboxing the rest.
+func fitGeoM(w, h, sw, sh int, mode ScaleMode) ebiten.GeoM {
+	scale := math.Min(float64(sw)/float64(w), float64(sh)/float64(h))
+	if mode == SCALE_INTEGER && scale >= 1 {
+		scale = math.Floor(scale)
+	}
+	var geo ebiten.GeoM
+	geo.Scale(scale, scale)
+	geo.Translate(math.Floor((float64(sw)-float64(w)*scale)/2), math.Floor((float64(sh)-float64(h)*scale)/2))
+	return geo
 }
 
 // Game main.
 type Chip8 struct {
-	cpu   *Cpu
-	mem   *Memory
-	vme   *VideoMemory
-	audio *audio.Player
-	kb    *Keyboard
-}
-
-func (c8 *Chip8) Update() {
-	c8.kb.Update()
-
-	if len(c8.kb.queue) > 0 {
-		keys := []string{}
-		for _, key := range c8.kb.queue {
-			keys = append(keys, fmt.Sprintf("%d", key))
+	sys      *chip8.System
+	machine  chip8.Machine // Runs the program: sys, or a VIP if vip is set.
+	vip      *vipImages
+	palette  chip8.Palette
+	rom      string
+	settings chip8.Settings
+	display  *chip8.Display
+	texture  *ebiten.Image // The framebuffer at one texel per pixel.
+	pixels   []byte        // RGBA staging buffer for texture.
+	scale    ScaleMode
+	recorder *chip8.Recorder // Non-nil while recording.
+	wav      *chip8.WavRecorder
+	halted   error            // Why the program stopped, if it did.
+	symbols  *chip8.SourceMap // Labels and source lines of the program, if known.
+	debug    bool             // Show the debug overlay.
+	memory   *chip8.MemoryView
+	panel    bool // Show the memory panel.
+	gallery  bool // Show the sprites drawn so far.
+	paused   bool
+}
+
+// NewChip8 makes the game scene. settings is nil if they could not be read,
+// and then palette and display choices are not remembered.
+func NewChip8(sys *chip8.System, settings chip8.Settings) *Chip8 {
+	c8 := &Chip8{sys: sys, machine: sys, palette: chip8.PALETTES[0], settings: settings}
+	c8.display = chip8.NewDisplay()
+	c8.memory = chip8.NewMemoryView(sys, PANEL_ROWS)
+	sys.Cpu.Sprites = chip8.NewSpriteLog()
+	return c8
+}
+
+// Load a ROM image, or an Octo cartridge if the file is a GIF.
+func (c8 *Chip8) Load(path string) error {
+	var err error
+	if strings.EqualFold(filepath.Ext(path), ".gif") {
+		err = c8.LoadCartridge(path)
+	} else if err = c8.sys.Mem.Load(path); err == nil {
+		// A symbol file next to the ROM names its addresses.
+		if _, statErr := os.Stat(chip8.SymbolsName(path)); statErr == nil {
+			if err := c8.LoadSymbols(chip8.SymbolsName(path)); err != nil {
+				log.Printf("Ignoring symbols: %v", err)
+			}
 		}
-		log.Printf("Unprocessed keys: %s", strings.Join(keys, " "))
 	}
-
-	err := c8.cpu.Tick(c8.mem, c8.vme, c8.audio, c8.kb)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-}
-
-func (c8 *Chip8) Draw(screen *ebiten.Image) {
-	for x := 0; x < H_PIXELS; x++ {
-		for y := 0; y < V_PIXELS; y++ {
-			xor := c8.vme.mem[x][y] ^ c8.vme.buf[x][y]
-			if xor == 1 {
-				pixel := Pixel{x, y, bytob(c8.vme.buf[x][y])}
-				pixel.Draw(screen)
-			}
+	if c8.vip != nil {
+		// The program is read or compiled as usual and copied into the VIP.
+		vip, err := c8.vip.load(c8.sys.RAM()[chip8.PROGRAM_START:])
+		if err != nil {
+			return err
 		}
+		c8.machine = vip
+		c8.memory.M = vip
 	}
-}
 
-func bytob(value byte) bool {
-	if value == 1 {
-		return true
-	} else {
-		return false
+	c8.rom = filepath.Base(path)
+	if p := c8.settings[c8.rom].Palette; p != nil {
+		c8.palette = *p
 	}
-}
-
-type Keyboard struct {
-	queue []uint16
-}
-
-func (kb *Keyboard) Update() {
-	// 0~9: 43~52
-	for _, key := range inpututil.PressedKeys() {
-		if (key >= 43 && key <= 52) || (key >= 0 && key <= 5) {
-			kb.queue = append(kb.queue, uint16(keytohex(key)))
-			// log.Printf("keyPressed=%d \n", key)
+	if ds := c8.settings[c8.rom].Display; ds != nil {
+		if err := ds.Apply(c8.display); err != nil {
+			log.Printf("Ignoring display settings: %v", err)
 		}
 	}
+	return nil
 }
 
-func NewKeyboard() *Keyboard {
-	kb := new(Keyboard)
-	kb.queue = []uint16{}
-	return kb
+// Load the symbol file of the program, for the debug overlay and the trace.
+func (c8 *Chip8) LoadSymbols(path string) error {
+	symbols, err := chip8.LoadSourceMap(path)
+	if err != nil {
+		return err
+	}
+	c8.setSymbols(symbols)
+	log.Printf("Symbols loaded from \"%s\".", path)
+	return nil
 }
 
-func (kb *Keyboard) Pop() *uint16 {
-	len := len(kb.queue)
-	if len > 0 {
-		key := kb.queue[0]
-		kb.queue = kb.queue[1:]
-		return &key
-	} else {
-		return nil
+func (c8 *Chip8) setSymbols(symbols *chip8.SourceMap) {
+	c8.symbols = symbols
+	if c8.sys.Cpu.Trace != nil {
+		c8.sys.Cpu.Trace.Symbols = symbols
 	}
 }
 
-func (kb *Keyboard) Clear() {
-	kb.queue = []uint16{}
+// Switch to a palette and remember it for the current ROM.
+func (c8 *Chip8) SetPalette(p chip8.Palette) {
+	c8.palette = p
+	if c8.settings == nil {
+		return
+	}
+	c8.settings.SetPalette(c8.rom, p)
+	if err := saveSettings(c8.settings); err != nil {
+		log.Printf("Failed to save settings: %v", err)
+	}
 }
 
-func keytohex(key ebiten.Key) uint16 {
-	if key >= 43 && key <= 52 {
-		return uint16(key) - 43
-	} else {
-		return uint16(key) + 0x10
+// Switch the display post-processing mode and remember it for the current ROM.
+func (c8 *Chip8) SetDisplayMode(mode chip8.DisplayMode) {
+	c8.display.Mode = mode
+	if c8.settings == nil {
+		return
+	}
+	c8.settings.SetDisplay(c8.rom, c8.display)
+	if err := saveSettings(c8.settings); err != nil {
+		log.Printf("Failed to save settings: %v", err)
 	}
 }
 
-type Cpu struct {
-	v     [64]uint8
-	i     uint16
-	stack [16]uint16
-	sp    uint16
-	pc    uint16
-	dt    uint16
-	st    uint16
-	rnd   *rand.Rand
-	lastd time.Time
-	lasts time.Time
-}
-
-func NewCpu() *Cpu {
-	cpu := new(Cpu)
-	cpu.pc = 0x200
-	cpu.rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
-	cpu.lastd = time.Now()
-	cpu.lasts = time.Now()
-	return cpu
-}
-
-func (cpu *Cpu) rand() uint8 {
-	return uint8(cpu.rnd.Intn(256))
-}
-
-func (cpu *Cpu) Tick(mem *Memory, vme *VideoMemory, audio *audio.Player, kb *Keyboard) error {
-	o1 := mem.buf[cpu.pc] >> 4
-	o2 := mem.buf[cpu.pc] & 0x0F
-	o3 := mem.buf[cpu.pc+1] >> 4
-	o4 := mem.buf[cpu.pc+1] & 0x0F
-	opcode := fmt.Sprintf("%02X%02X%02X%02X", o1, o2, o3, o4)
-	log.Printf("Tick sp=%d pc=%d dt=%d st=%d opcode=%s", cpu.sp, cpu.pc, cpu.dt, cpu.st, opcode)
-
-	nnn := (uint16(o2) << 8) + (uint16(o3) << 4) + uint16(o4)
-	kk := (uint8(o3) << 4) + uint8(o4)
-	x := o2
-	y := o3
-	vx := uint16(cpu.v[o2])
-	vy := uint16(cpu.v[o3])
-	xy := vx + vy
-
-	var cmd Command
-	switch o1 {
-	case 0x0:
-		switch o2 {
-		case 0x0:
-			switch o3 {
-			case 0xE:
-				switch o4 {
-				case 0x0:
-					log.Println("CLS")
-					vme.clear()
-					cmd = Next{}
-				case 0xE:
-					log.Println("00EE RET")
-					pc := cpu.stack[cpu.sp-1]
-					cpu.sp -= 1
-					cmd = Jump{pc + 2}
-				}
-			}
-		default:
-			log.Println("SYS addr")
-			cmd = Jump{nnn}
-		}
-	case 0x1:
-		log.Println("1nnn JP")
-		cmd = Jump{nnn}
-	case 0x2:
-		log.Println("2nnn CALL")
-		cpu.stack[cpu.sp] = cpu.pc
-		cpu.sp += 1
-		cmd = Jump{nnn}
-	case 0x3:
-		log.Println("3xkk SE")
-		if vx == uint16(kk) {
-			cmd = Skip{}
-		} else {
-			cmd = Next{}
-		}
-	case 0x4:
-		log.Println("4xkk SNE")
-		if vx != uint16(kk) {
-			cmd = Skip{}
-		} else {
-			cmd = Next{}
-		}
-	case 0x5:
-		log.Println("5xy0 - SE")
-		if vx == vy {
-			cmd = Skip{}
-		} else {
-			cmd = Next{}
-		}
-	case 0x6:
-		log.Println("6xkk - LD")
-		cpu.v[x] = kk
-		cmd = Next{}
-	case 0x7:
-		log.Println("7xkk - ADD")
-		cpu.v[x] += kk
-		cmd = Next{}
-	case 0x8:
-		switch o4 {
-		case 0x0:
-			log.Println("8xk0 - LD Vx, Vy")
-			cpu.v[x] = cpu.v[y]
-		case 0x1:
-			log.Println("8xk1 - OR Vx, Vy")
-			cpu.v[x] |= cpu.v[y]
-		case 0x2:
-			log.Println("8xk2 - AND Vx, Vy")
-			cpu.v[x] &= cpu.v[y]
-		case 0x3:
-			log.Println("8xk3 - XOR Vx, Vy")
-			cpu.v[x] ^= cpu.v[y]
-		case 0x4:
-			log.Println("8xk4 - ADD Vx, Vy")
-			if xy > 0xFF {
-				cpu.v[0xF] = 1
-			} else {
-				cpu.v[0xF] = 0
-			}
-			cpu.v[x] = uint8(xy & 0xFF)
-		case 0x5:
-			log.Println("8xk5 - SUB Vx, Vy")
-			if vx > vy {
-				cpu.v[0xF] = 1
-			} else {
-				cpu.v[0xF] = 0
-			}
-			cpu.v[x] = uint8(vx - vy)
-		case 0x6:
-			log.Println("8xk6 - SHR Vx, Vy")
-			cpu.v[0xF] = uint8(vx & 0x1)
-			cpu.v[x] /= 2
-		case 0x7:
-			log.Println("8xk7 - SUBN Vx, Vy")
-			if vy > vx {
-				cpu.v[0xF] = 1
-			} else {
-				cpu.v[0xF] = 0
-			}
-			cpu.v[x] = uint8(vy - vx)
-		case 0xE:
-			log.Println("8xkE - SHL Vx, Vy")
-			cpu.v[0xF] = cpu.v[x] >> 7
-			cpu.v[x] *= 2
-		}
-		cmd = Next{}
-	case 0x9:
-		log.Println("9xy0 - SNE")
-		if vx != vy {
-			cmd = Skip{}
-		} else {
-			cmd = Next{}
-		}
-	case 0xA:
-		log.Println("Annn - LD I")
-		cpu.i = nnn
-		cmd = Next{}
-	case 0xB:
-		log.Println("Bnnn - JP")
-		cmd = Jump{nnn + uint16(cpu.v[0])}
-	case 0xC:
-		log.Println("Cxkk - RND")
-		cpu.v[x] = cpu.rand() & kk
-		cmd = Next{}
-	case 0xD:
-		log.Println("DRW - Vx, Vy, nibble")
-		n := o4
-		bytes := mem.buf[cpu.i : cpu.i+uint16(n)]
-		cpu.v[0xF] = vme.draw(vx, vy, bytes)
-		cmd = Next{}
-	case 0xE:
-		switch o3 {
-		case 0x9:
-			log.Println("Ex9E - SKP")
-			pressed := false
-			for true {
-				key := kb.Pop()
-				if key == nil {
-					break
-				}
-				if vx == *key {
-					pressed = true
-				}
-			}
-			if pressed {
-				cmd = Skip{}
-			} else {
-				cmd = Next{}
-			}
-		case 0xA:
-			log.Println("ExA1 - SKNP")
-			pressed := false
-			for true {
-				key := kb.Pop()
-				if key == nil {
-					break
-				}
-				if vx == *key {
-					pressed = true
-				}
-			}
-			if !pressed {
-				cmd = Skip{}
-			} else {
-				cmd = Next{}
-			}
-		}
-	case 0xF:
-		switch o3 {
-		case 0x0:
-			switch o4 {
-			case 0x7:
-				log.Println("Fx07 - LD Vx, DT")
-				cpu.v[x] = uint8(cpu.dt)
-				cmd = Next{}
-			case 0xA:
-				log.Println("Fx0A - LD Vx, K")
-				key := kb.Pop()
-				if key != nil {
-					cpu.v[x] = uint8(*key)
-					cmd = Next{}
-				} else {
-					// Do nothing.
-				}
-			}
-		case 0x1:
-			switch o4 {
-			case 0x5:
-				log.Println("Fx15 - LD DT")
-				cpu.dt = vx
-				cpu.lastd = time.Now()
-				cmd = Next{}
-			case 0x8:
-				log.Println("Fx18 - LD ST")
-				cpu.st = vx
-				cpu.lasts = time.Now()
-				cmd = Next{}
-			case 0xE:
-				log.Println("Fx1E - ADD I Vx")
-				cpu.i += vx
-				cmd = Next{}
-			}
-		case 0x2:
-			log.Println("Fx29 - LD F")
-			cpu.i = vx * 5
-			cmd = Next{}
-		case 0x3:
-			log.Println("Fx33 - LD B")
-			mem.buf[cpu.i] = (uint8(vx) / 100) % 10
-			mem.buf[cpu.i+1] = (uint8(vx) / 10) % 10
-			mem.buf[cpu.i+2] = uint8(vx) % 10
-			cmd = Next{}
-		case 0x5:
-			log.Println("Fx55 - LD [I]")
-			for n := 0; n <= int(x); n++ {
-				mem.buf[cpu.i+uint16(n)] = cpu.v[n]
-			}
-			cmd = Next{}
-		case 0x6:
-			log.Println("Fx65 - LD")
-			for n := 0; n <= int(x); n++ {
-				cpu.v[n] = mem.buf[cpu.i+uint16(n)]
-			}
-			cmd = Next{}
-		}
+// Load the program embedded in an Octo cartridge and apply its options.
+func (c8 *Chip8) LoadCartridge(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
 	}
+	defer f.Close()
 
-	if cmd != nil {
-		cmd.exec(cpu)
+	cart, err := octo.DecodeCartridge(f)
+	if err != nil {
+		return err
 	}
+	opts := cart.Options
 
-	now := time.Now()
-	elapsed := now.Sub(cpu.lastd)
-	if elapsed.Seconds() > 1.0/60 && cpu.dt > 0 {
-		cpu.dt -= 1
-		cpu.lastd = now
+	size := c8.sys.Mem.ProgramSize()
+	if opts.MaxSize > 0 && opts.MaxSize < size {
+		size = opts.MaxSize
 	}
-
-	elapsed = now.Sub(cpu.lasts)
-	if elapsed.Seconds() > 1.0/60 && cpu.st > 0 {
-		audio.Play()
-		audio.Rewind()
-		cpu.st -= 1
-		cpu.lasts = now
+	prog, err := octo.Compile(cart.Program, size)
+	if err != nil {
+		return err
 	}
-
+	if err := c8.sys.Mem.LoadBytes(prog.Rom); err != nil {
+		return err
+	}
+	log.Printf("%d bytes compiled from \"%s\".", len(prog.Rom), path)
+	c8.setSymbols(chip8.NewSourceMap(path, cart.Program, prog.Labels, prog.Lines))
+
+	c8.sys.Cpu.Quirks = chip8.Quirks{
+		Shift:     opts.ShiftQuirks,
+		LoadStore: opts.LoadStoreQuirks,
+		Jump:      opts.JumpQuirks,
+		Logic:     opts.LogicQuirks,
+		Clip:      opts.ClipQuirks,
+		VBlank:    opts.VBlankQuirks,
+	}
+	if opts.Tickrate > 0 {
+		c8.sys.Tickrate = opts.Tickrate
+	}
+	c8.palette = chip8.OctoPalette(opts)
 	return nil
 }
 
-type Command interface {
-	exec(cpu *Cpu)
-}
+func (c8 *Chip8) Update() {
+	if inpututil.IsKeyJustPressed(ebiten.KeyP) {
+		c8.SetPalette(c8.palette.Next())
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyM) {
+		c8.SetDisplayMode(c8.display.Mode.Next())
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyS) {
+		c8.scale = (c8.scale + 1) % 2
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyF12) {
+		c8.Screenshot()
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyR) {
+		c8.ToggleRecording()
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyF1) {
+		c8.debug = !c8.debug
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyF2) {
+		c8.panel = !c8.panel
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyF3) {
+		c8.gallery = !c8.gallery
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyF5) {
+		c8.paused = !c8.paused
+	}
+	if c8.panel {
+		updateMemoryPanel(c8.memory, c8.paused)
+	}
 
-type Next struct{}
+	// The keypad keys edit memory while paused.
+	if !c8.paused {
+		pollKeyboard(c8.machine.Keypad())
+	}
 
-func (c Next) exec(cpu *Cpu) {
-	cpu.pc += 2
-}
+	if pending := c8.machine.Keypad().Pending(); len(pending) > 0 {
+		keys := []string{}
+		for _, key := range pending {
+			keys = append(keys, fmt.Sprintf("%d", key))
+		}
+		log.Printf("Unprocessed keys: %s", strings.Join(keys, " "))
+	}
 
-type Jump struct {
-	addr uint16
+	if c8.halted != nil {
+		return
+	}
+	if c8.paused {
+		if inpututil.IsKeyJustPressed(ebiten.KeyF6) {
+			c8.halt(c8.machine.Step())
+			c8.memory.Frame()
+		}
+		return
+	}
+	if c8.halt(c8.machine.RunFrame()) {
+		return
+	}
+	if c8.machine != c8.sys && c8.machine.Buzzing() && c8.sys.Audio != nil {
+		// The VIP sounds its own buzzer, which System leaves to the timers.
+		c8.sys.Audio.Play()
+		c8.sys.Audio.Rewind()
+	}
+	c8.memory.Frame()
+	c8.display.VBlank(c8.machine.Screen())
+	if c8.recorder != nil {
+		c8.recorder.Capture(c8.machine.Screen())
+		c8.wav.Capture(c8.machine)
+	}
 }
 
-func (c Jump) exec(cpu *Cpu) {
-	cpu.pc = c.addr
+// Frames run so far by the machine.
+func (c8 *Chip8) frame() int {
+	if vip, ok := c8.machine.(*chip8.VIP); ok {
+		return vip.Frame
+	}
+	return c8.sys.Frame
 }
 
-type Skip struct{}
-
-func (c Skip) exec(cpu *Cpu) {
-	cpu.pc += 4
+// Stop the program if it failed, keeping the last frame on screen so that
+// it can still be inspected.
+func (c8 *Chip8) halt(err error) bool {
+	if err == nil {
+		return false
+	}
+	log.Printf("Program halted: %v", err)
+	c8.halted = err
+	return true
+}
+
+// Start recording, or stop and save the recording as an animated GIF with
+// the buzzer in a WAV file next to it.
+func (c8 *Chip8) ToggleRecording() {
+	if c8.recorder == nil {
+		c8.recorder = chip8.NewRecorder(SCALE/2, c8.palette.Colors)
+		c8.wav = chip8.NewWavRecorder()
+		log.Println("Recording started.")
+		return
+	}
+	path := chip8.RecordingName(c8.rom, c8.frame())
+	if err := c8.recorder.Save(path); err != nil {
+		log.Printf("Failed to save recording: %v", err)
+	} else {
+		log.Printf("Recording of %d frames saved to \"%s\".", c8.recorder.Frames(), path)
+	}
+	wavPath := strings.TrimSuffix(path, filepath.Ext(path)) + ".wav"
+	if err := c8.wav.Save(wavPath); err != nil {
+		log.Printf("Failed to save sound: %v", err)
+	}
+	c8.recorder, c8.wav = nil, nil
 }
 
-type Memory struct {
-	buf [0xFFF]byte // Chip-8 has 0xFFFF (4096) bytes of RAM.
+// Save the display as a PNG named after the ROM and frame number.
+func (c8 *Chip8) Screenshot() {
+	path := chip8.ScreenshotName(c8.rom, c8.frame())
+	if err := c8.machine.Screen().SavePNG(path, SCALE, c8.palette.Colors); err != nil {
+		log.Printf("Failed to save screenshot: %v", err)
+		return
+	}
+	log.Printf("Screenshot saved to \"%s\".", path)
 }
 
-func (m *Memory) Load(path string) error {
-	f, err := os.Open(path)
-	if err != nil {
-		return err
+// Draw uploads the whole framebuffer as one texture and scales it up.
+func (c8 *Chip8) Draw(screen *ebiten.Image) {
+	w, h := c8.display.Size()
+	if c8.texture == nil || c8.texture.Bounds().Dx() != w || c8.texture.Bounds().Dy() != h {
+		c8.texture = ebiten.NewImage(w, h)
+		c8.pixels = make([]byte, 4*w*h)
 	}
+	c8.display.Render(c8.pixels, c8.palette.Colors)
+	c8.texture.ReplacePixels(c8.pixels)
 
-	n, err := f.Read(m.buf[0x200:])
-	log.Printf("%d bytes read from \"%s\".", n, path)
-	return nil
+	sw, sh := screen.Size()
+	opts := &ebiten.DrawImageOptions{}
+	opts.GeoM = fitGeoM(w, h, sw, sh, c8.scale)
+	opts.Filter = ebiten.FilterNearest
+	screen.DrawImage(c8.texture, opts)
+	if c8.debug {
+		ebitenutil.DebugPrintAt(screen, c8.DebugText(), 0, 16)
+	}
+	if c8.gallery {
+		drawSpriteGallery(screen, c8.sys.Cpu.Sprites.Sprites(), c8.machine.RAM())
+	}
+	if c8.panel {
+		drawMemoryPanel(screen, c8.memory, c8.paused)
+	}
 }
 
-func NewMemory() *Memory {
-	m := new(Memory)
-
-	// Load fontsets.
-	m.buf = [0xFFF]byte{0xF0, 0x90, 0x90, 0x90, 0xF0, 0x20, 0x60, 0x20, 0x20, 0x70, 0xF0, 0x10, 0xF0, 0x80, 0xF0, 0xF0, 0x10, 0xF0, 0x10, 0xF0, 0x90, 0x90, 0xF0, 0x10, 0x10, 0xF0, 0x80, 0xF0, 0x10, 0xF0, 0xF0, 0x80, 0xF0, 0x90, 0xF0, 0xF0, 0x10, 0x20, 0x40, 0x40, 0xF0, 0x90, 0xF0, 0x90, 0xF0, 0xF0, 0x90, 0xF0, 0x10, 0xF0, 0xF0, 0x90, 0xF0, 0x90, 0x90, 0xE0, 0x90, 0xE0, 0x90, 0xE0, 0xF0, 0x80, 0x80, 0x80, 0xF0, 0xE0, 0x90, 0x90, 0x90, 0xE0, 0xF0, 0x80, 0xF0, 0x80, 0xF0, 0xF0, 0x80, 0xF0, 0x80, 0x80}
+// DEBUG_INSTRUCTIONS is how many instructions the debug overlay lists from
+// the program counter.
+const DEBUG_INSTRUCTIONS = 6
 
-	return m
-}
-
-// VideoMemory implements double buffer.
-type VideoMemory struct {
-	buf [H_PIXELS][V_PIXELS]byte
-	mem [H_PIXELS][V_PIXELS]byte
+// The registers and the instructions from the program counter, with their
+// labels and source lines if the program has symbols.
+func (c8 *Chip8) DebugText() string {
+	s := c8.machine.State()
+	var b strings.Builder
+	fmt.Fprintf(&b, "PC %03X I %03X SP %X DT %02X ST %02X", s.PC, s.I, len(s.Stack), s.DT, s.ST)
+	if symbol := c8.symbols.Symbol(s.PC); symbol != "" {
+		fmt.Fprintf(&b, "  %s", symbol)
+	}
+	fmt.Fprintf(&b, "\nV % X\n", s.V[:])
+	pc := int(s.PC)
+	chip8.WriteDisassembly(&b, c8.machine.RAM(), pc, pc+2*DEBUG_INSTRUCTIONS, c8.symbols)
+	return b.String()
 }
 
-func NewVideoMemory() *VideoMemory {
-	return new(VideoMemory)
+// The display is scaled by Draw, so the screen matches the window.
+func (c8 *Chip8) Layout(outsideWidth, outsideHeight int) (int, int) {
+	return outsideWidth, outsideHeight
 }
 
-func (vme *VideoMemory) clear() {
-	for x := 0; x < H_PIXELS; x++ {
-		for y := 0; y < V_PIXELS; y++ {
-			vme.buf[x][y] = 0
+// Queue the hex keys held down on the keyboard.
+func pollKeyboard(kb *chip8.Keyboard) {
+	// 0~9: 43~52
+	for _, key := range inpututil.PressedKeys() {
+		if (key >= 43 && key <= 52) || (key >= 0 && key <= 5) {
+			kb.Push(uint16(keytohex(key)))
+			// log.Printf("keyPressed=%d \n", key)
 		}
 	}
 }
 
-func (vme *VideoMemory) draw(x uint16, y uint16, buf []byte) uint8 {
-	vf := uint16(0)
-	for i, byte := range buf {
-		i := uint16(i)
-		vf += vme.draw_pixcel(x, y+i, (byte>>7)&0x1)
-		vf += vme.draw_pixcel(x+1, y+i, (byte>>6)&0x1)
-		vf += vme.draw_pixcel(x+2, y+i, (byte>>5)&0x1)
-		vf += vme.draw_pixcel(x+3, y+i, (byte>>4)&0x1)
-		vf += vme.draw_pixcel(x+4, y+i, (byte>>3)&0x1)
-		vf += vme.draw_pixcel(x+5, y+i, (byte>>2)&0x1)
-		vf += vme.draw_pixcel(x+6, y+i, (byte>>1)&0x1)
-		vf += vme.draw_pixcel(x+7, y+i, (byte>>0)&0x1)
-	}
-
-	if vf > 0 {
-		return 1
-	} else {
-		return 0
-	}
-}
-
-func (vme *VideoMemory) draw_pixcel(x uint16, y uint16, new byte) uint16 {
-	var vf uint16
-
-	// Check collision.
-	if vme.buf[x][y] == 1 && new == 1 {
-		vf = 1
+func keytohex(key ebiten.Key) uint16 {
+	if key >= 43 && key <= 52 {
+		return uint16(key) - 43
 	} else {
-		vf = 0
+		return uint16(key) + 0x10
 	}
-
-	vme.buf[x][y] ^= new
-	return vf
 }
 
 type Button struct {
@@ -580,6 +444,11 @@ func (ui *UI) Draw(screen *ebiten.Image) {
 	}
 }
 
+// The game select UI is laid out for a fixed size and scaled by ebiten.
+func (ui *UI) Layout(outsideWidth, outsideHeight int) (int, int) {
+	return WIDTH, HEIGHT
+}
+
 func (ui *UI) Update() {
 	clicked := ebiten.IsMouseButtonPressed(ebiten.MouseButtonLeft)
 	x, y := ebiten.CursorPosition()
@@ -736,6 +605,7 @@ func NewUI() *UI {
 type Scene interface {
 	Draw(screen *ebiten.Image)
 	Update()
+	Layout(outsideWidth, outsideHeight int) (int, int)
 }
 
 type Game struct {
@@ -743,7 +613,7 @@ type Game struct {
 }
 
 func (g *Game) Layout(outsideWidth, outsideHeight int) (screenWidth, screenHeight int) {
-	return 640, 320
+	return g.scene.Layout(outsideWidth, outsideHeight)
 }
 
 func (g *Game) Draw(screen *ebiten.Image) {
@@ -752,17 +622,137 @@ func (g *Game) Draw(screen *ebiten.Image) {
 }
 
 func (g *Game) Update() error {
+	if inpututil.IsKeyJustPressed(ebiten.KeyF11) {
+		ebiten.SetFullscreen(!ebiten.IsFullscreen())
+	}
 	g.scene.Update()
 	return nil
 }
 
+// Apply the -quirks and -timing flags. Empty names keep the defaults.
+func configureSystem(sys *chip8.System, quirks, timing string) error {
+	if quirks != "" {
+		q, ok := chip8.QUIRK_PROFILES[strings.ToLower(quirks)]
+		if !ok {
+			return fmt.Errorf("unknown quirk profile %q", quirks)
+		}
+		sys.Cpu.Quirks = q
+	}
+	if timing != "" {
+		t, err := chip8.ParseTiming(timing)
+		if err != nil {
+			return err
+		}
+		sys.Timing = t
+	}
+	return nil
+}
+
+// Flags which trace the execution of the CPU to a file.
+type traceFlags struct {
+	path, level, format, pc, ops *string
+}
+
+func addTraceFlags(fs *flag.FlagSet) *traceFlags {
+	return &traceFlags{
+		path:   fs.String("trace", "", "Trace the CPU to this file"),
+		level:  fs.String("trace-level", "opcodes", "Trace level: events, opcodes or instructions"),
+		format: fs.String("trace-format", "text", "Trace format: text, json or csv"),
+		pc:     fs.String("trace-pc", "", "Trace only instructions in this hex address range, such as 200-2FF"),
+		ops:    fs.String("trace-op", "", "Trace only opcodes matching these comma separated patterns, such as Dxyn,Fx0A"),
+	}
+}
+
+// Start tracing cpu if -trace is given. The returned function flushes and
+// closes the trace file; calling it again does nothing.
+func (f *traceFlags) open(cpu *chip8.Cpu) (func() error, error) {
+	if *f.path == "" {
+		return func() error { return nil }, nil
+	}
+	level, err := chip8.ParseTraceLevel(*f.level)
+	if err != nil {
+		return nil, err
+	}
+	format, err := chip8.ParseTraceFormat(*f.format)
+	if err != nil {
+		return nil, err
+	}
+	var filters []chip8.OpcodeFilter
+	if *f.ops != "" {
+		for _, pattern := range strings.Split(*f.ops, ",") {
+			filter, err := chip8.ParseOpcodeFilter(strings.TrimSpace(pattern))
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, filter)
+		}
+	}
+	file, err := os.Create(*f.path)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(file)
+	t := chip8.NewTracer(w, level, format)
+	t.Opcodes = filters
+	if *f.pc != "" {
+		if t.From, t.To, err = chip8.ParseAddressRange(*f.pc); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	cpu.Trace = t
+	return func() error {
+		if cpu.Trace != t {
+			return nil
+		}
+		cpu.Trace = nil
+		if err := t.Err(); err != nil {
+			file.Close()
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	}, nil
+}
+
 func main() {
-	ebiten.SetMaxTPS(800)
-	ebiten.SetWindowSize(640, 320)
+	if len(os.Args) > 1 {
+		if cmd, ok := COMMANDS[os.Args[1]]; ok {
+			if err := cmd(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
+	paletteName := flag.String("palette", "", "Display palette: Classic, Green Phosphor, Amber, LCD or Octo")
+	displayMode := flag.String("display", "", "Flicker reduction: none, blend, decay or vblank")
+	fullscreen := flag.Bool("fullscreen", false, "Start in fullscreen")
+	integer := flag.Bool("integer", false, "Scale the display by whole multiples only")
+	quirks := flag.String("quirks", "", "Quirk profile: chip8, schip, xochip or modern (Octo cartridges set their own)")
+	timing := flag.String("timing", "", "Instructions per frame: tickrate, or vip for COSMAC VIP cycle costs")
+	symbols := flag.String("symbols", "", "Symbol file of the program (default: the .sym file next to the ROM)")
+	interpreter := flag.String("interpreter", "", "Run the original interpreter from this image on an emulated COSMAC VIP")
+	monitor := flag.String("monitor", "", "Image of the VIP monitor ROM, for its interrupt routine")
+	trace := addTraceFlags(flag.CommandLine)
+	flag.Parse()
+
+	ebiten.SetMaxTPS(60)
+	ebiten.SetWindowSize(WIDTH, HEIGHT)
+	ebiten.SetWindowResizable(true)
+	ebiten.SetFullscreen(*fullscreen)
 	ebiten.SetWindowTitle("CHIP-8")
-	cpu := NewCpu()
-	mem := NewMemory()
-	vme := NewVideoMemory()
+	sys := chip8.NewSystem()
+	if err := configureSystem(sys, *quirks, *timing); err != nil {
+		log.Fatal(err)
+	}
+	closeTrace, err := trace.open(sys.Cpu)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	f, err := os.Open("audio.mp3")
 	if err != nil {
@@ -772,23 +762,65 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Printf("%+v", mem)
-
-	kb := NewKeyboard()
+	sys.Audio = audio
+	log.Printf("%+v", sys.Mem)
 
 	ui := NewUI()
 
-	c8 := Chip8{cpu, mem, vme, audio, kb}
+	settings, err := loadSettings()
+	if err != nil {
+		log.Printf("Failed to load settings, not saving any: %v", err)
+		settings = nil
+	}
+
+	c8 := NewChip8(sys, settings)
+	if *interpreter != "" {
+		if c8.vip, err = loadVIPImages(*interpreter, *monitor); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Running programs on a COSMAC VIP; -quirks, -timing and -trace do not apply.")
+	}
 
 	game := Game{ui}
 	ui.oncompleted = func(rom Rom) {
-		game.scene = &c8
-		err := c8.mem.Load(rom.path)
+		game.scene = c8
+		err := c8.Load(rom.path)
 		if err != nil {
 			panic(err)
 		}
 	}
-	if err := ebiten.RunGame(&game); err != nil {
+
+	// A ROM or cartridge given on the command line skips the game select UI.
+	if flag.NArg() > 0 {
+		ui.oncompleted(Rom{filepath.Base(flag.Arg(0)), flag.Arg(0)})
+	}
+	if *symbols != "" {
+		if err := c8.LoadSymbols(*symbols); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *paletteName != "" {
+		p, ok := chip8.FindPalette(*paletteName)
+		if !ok {
+			log.Fatalf("Unknown palette %q", *paletteName)
+		}
+		c8.palette = p
+	}
+	if *integer {
+		c8.scale = SCALE_INTEGER
+	}
+	if *displayMode != "" {
+		mode, err := chip8.ParseDisplayMode(*displayMode)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c8.display.Mode = mode
+	}
+	err = ebiten.RunGame(&game)
+	if err := closeTrace(); err != nil {
+		log.Printf("Failed to write trace: %v", err)
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
 
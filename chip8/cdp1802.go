@@ -0,0 +1,276 @@
+package chip8
+
+// Bus connects a CDP1802 to memory and I/O devices.
+type Bus interface {
+	Read(addr uint16) byte
+	Write(addr uint16, b byte)
+	Out(port int, b byte) // OUT 1-7.
+	In(port int) byte     // INP 1-7.
+	Flag(n int) bool      // EF1-EF4.
+}
+
+// CDP1802 is the RCA COSMAC CPU. Every instruction takes two machine cycles
+// of eight clocks, except long branches and skips which take three.
+type CDP1802 struct {
+	R    [16]uint16 // Scratchpad registers.
+	D    byte       // Accumulator.
+	DF   bool       // Carry.
+	P, X byte       // Designators of the program counter and the data pointer.
+	T    byte       // X and P saved by an interrupt.
+	IE   bool       // Interrupts enabled.
+	Q    bool       // Output flip-flop.
+	Idle bool       // Waiting for an interrupt or DMA after IDL.
+}
+
+// Reset clears the CPU as the CLEAR input does: execution starts at
+// address 0 with R0 as the program counter.
+func (c *CDP1802) Reset() {
+	*c = CDP1802{IE: true}
+}
+
+// Interrupt saves X and P in T and enters the interrupt routine at R1 with
+// R2 as the data pointer. It takes one machine cycle, or none if interrupts
+// are disabled.
+func (c *CDP1802) Interrupt() int {
+	if !c.IE {
+		return 0
+	}
+	c.T = c.X<<4 | c.P
+	c.X, c.P = 2, 1
+	c.IE = false
+	c.Idle = false
+	return 1
+}
+
+func (c *CDP1802) fetch(bus Bus) byte {
+	b := bus.Read(c.R[c.P])
+	c.R[c.P]++
+	return b
+}
+
+// shortBranch replaces the low byte of the program counter with the
+// immediate byte if cond holds, and skips it otherwise.
+func (c *CDP1802) shortBranch(bus Bus, cond bool) {
+	pc := c.R[c.P]
+	if cond {
+		c.R[c.P] = pc&0xFF00 | uint16(bus.Read(pc))
+	} else {
+		c.R[c.P] = pc + 1
+	}
+}
+
+// longBranch jumps to the immediate address if cond holds, and skips it
+// otherwise.
+func (c *CDP1802) longBranch(bus Bus, cond bool) {
+	pc := c.R[c.P]
+	if cond {
+		c.R[c.P] = uint16(bus.Read(pc))<<8 | uint16(bus.Read(pc+1))
+	} else {
+		c.R[c.P] = pc + 2
+	}
+}
+
+// longSkip skips the next two bytes if cond holds.
+func (c *CDP1802) longSkip(cond bool) {
+	if cond {
+		c.R[c.P] += 2
+	}
+}
+
+// add sets D to a+b+carry and DF to the carry out.
+func (c *CDP1802) add(a, b byte, carry bool) {
+	sum := uint16(a) + uint16(b)
+	if carry {
+		sum++
+	}
+	c.D = byte(sum)
+	c.DF = sum > 0xFF
+}
+
+// sub sets D to a-b, less one if borrow, and DF to 1 unless it borrowed.
+func (c *CDP1802) sub(a, b byte, borrow bool) {
+	c.add(a, ^b, !borrow)
+}
+
+// Step executes one instruction and returns the machine cycles it took.
+func (c *CDP1802) Step(bus Bus) int {
+	if c.Idle {
+		return 2
+	}
+	op := c.fetch(bus)
+	n := op & 0xF
+	switch op >> 4 {
+	case 0x0:
+		if n == 0 {
+			c.Idle = true // IDL
+		} else {
+			c.D = bus.Read(c.R[n]) // LDN
+		}
+	case 0x1:
+		c.R[n]++ // INC
+	case 0x2:
+		c.R[n]-- // DEC
+	case 0x3:
+		var cond bool
+		switch n & 0x7 {
+		case 0:
+			cond = true // BR, SKP
+		case 1:
+			cond = c.Q // BQ, BNQ
+		case 2:
+			cond = c.D == 0 // BZ, BNZ
+		case 3:
+			cond = c.DF // BDF, BNF
+		default:
+			cond = bus.Flag(int(n&0x7) - 3) // B1-B4, BN1-BN4
+		}
+		c.shortBranch(bus, cond != (n&0x8 != 0))
+	case 0x4:
+		c.D = bus.Read(c.R[n]) // LDA
+		c.R[n]++
+	case 0x5:
+		bus.Write(c.R[n], c.D) // STR
+	case 0x6:
+		switch {
+		case n == 0:
+			c.R[c.X]++ // IRX
+		case n < 8:
+			bus.Out(int(n), bus.Read(c.R[c.X])) // OUT
+			c.R[c.X]++
+		case n > 8:
+			c.D = bus.In(int(n - 8)) // INP
+			bus.Write(c.R[c.X], c.D)
+		}
+	case 0x7:
+		switch n {
+		case 0x0, 0x1: // RET, DIS
+			b := bus.Read(c.R[c.X])
+			c.R[c.X]++
+			c.X, c.P = b>>4, b&0xF
+			c.IE = n == 0
+		case 0x2: // LDXA
+			c.D = bus.Read(c.R[c.X])
+			c.R[c.X]++
+		case 0x3: // STXD
+			bus.Write(c.R[c.X], c.D)
+			c.R[c.X]--
+		case 0x4: // ADC
+			c.add(bus.Read(c.R[c.X]), c.D, c.DF)
+		case 0x5: // SDB
+			c.sub(bus.Read(c.R[c.X]), c.D, !c.DF)
+		case 0x6: // SHRC
+			carry := c.D&1 != 0
+			c.D >>= 1
+			if c.DF {
+				c.D |= 0x80
+			}
+			c.DF = carry
+		case 0x7: // SMB
+			c.sub(c.D, bus.Read(c.R[c.X]), !c.DF)
+		case 0x8: // SAV
+			bus.Write(c.R[c.X], c.T)
+		case 0x9: // MARK
+			c.T = c.X<<4 | c.P
+			bus.Write(c.R[2], c.T)
+			c.X = c.P
+			c.R[2]--
+		case 0xA: // REQ
+			c.Q = false
+		case 0xB: // SEQ
+			c.Q = true
+		case 0xC: // ADCI
+			c.add(c.fetch(bus), c.D, c.DF)
+		case 0xD: // SDBI
+			c.sub(c.fetch(bus), c.D, !c.DF)
+		case 0xE: // SHLC
+			carry := c.D&0x80 != 0
+			c.D <<= 1
+			if c.DF {
+				c.D |= 1
+			}
+			c.DF = carry
+		case 0xF: // SMBI
+			c.sub(c.D, c.fetch(bus), !c.DF)
+		}
+	case 0x8:
+		c.D = byte(c.R[n]) // GLO
+	case 0x9:
+		c.D = byte(c.R[n] >> 8) // GHI
+	case 0xA:
+		c.R[n] = c.R[n]&0xFF00 | uint16(c.D) // PLO
+	case 0xB:
+		c.R[n] = c.R[n]&0x00FF | uint16(c.D)<<8 // PHI
+	case 0xC:
+		switch n {
+		case 0x0:
+			c.longBranch(bus, true) // LBR
+		case 0x1:
+			c.longBranch(bus, c.Q) // LBQ
+		case 0x2:
+			c.longBranch(bus, c.D == 0) // LBZ
+		case 0x3:
+			c.longBranch(bus, c.DF) // LBDF
+		case 0x4:
+			// NOP
+		case 0x5:
+			c.longSkip(!c.Q) // LSNQ
+		case 0x6:
+			c.longSkip(c.D != 0) // LSNZ
+		case 0x7:
+			c.longSkip(!c.DF) // LSNF
+		case 0x8:
+			c.longSkip(true) // LSKP
+		case 0x9:
+			c.longBranch(bus, !c.Q) // LBNQ
+		case 0xA:
+			c.longBranch(bus, c.D != 0) // LBNZ
+		case 0xB:
+			c.longBranch(bus, !c.DF) // LBNF
+		case 0xC:
+			c.longSkip(c.IE) // LSIE
+		case 0xD:
+			c.longSkip(c.Q) // LSQ
+		case 0xE:
+			c.longSkip(c.D == 0) // LSZ
+		case 0xF:
+			c.longSkip(c.DF) // LSDF
+		}
+		return 3
+	case 0xD:
+		c.P = n // SEP
+	case 0xE:
+		c.X = n // SEX
+	case 0xF:
+		var m byte
+		if n < 8 {
+			m = bus.Read(c.R[c.X])
+		} else if n != 0x6 && n != 0xE {
+			m = c.fetch(bus)
+		}
+		switch n & 0x7 {
+		case 0x0:
+			c.D = m // LDX, LDI
+		case 0x1:
+			c.D |= m // OR, ORI
+		case 0x2:
+			c.D &= m // AND, ANI
+		case 0x3:
+			c.D ^= m // XOR, XRI
+		case 0x4:
+			c.add(m, c.D, false) // ADD, ADI
+		case 0x5:
+			c.sub(m, c.D, false) // SD, SDI
+		case 0x6:
+			if n == 0x6 { // SHR
+				c.DF = c.D&1 != 0
+				c.D >>= 1
+			} else { // SHL
+				c.DF = c.D&0x80 != 0
+				c.D <<= 1
+			}
+		case 0x7:
+			c.sub(c.D, m, false) // SM, SMI
+		}
+	}
+	return 2
+}
@@ -0,0 +1,127 @@
+package chip8
+
+import "testing"
+
+// ramBus is 64 KB of RAM with no devices but the flags.
+type ramBus struct {
+	mem   [0x10000]byte
+	flags [5]bool
+	out   []byte
+}
+
+func (b *ramBus) Read(addr uint16) byte     { return b.mem[addr] }
+func (b *ramBus) Write(addr uint16, v byte) { b.mem[addr] = v }
+func (b *ramBus) Out(port int, v byte)      { b.out = append(b.out, byte(port)<<4, v) }
+func (b *ramBus) In(port int) byte          { return 0xA0 | byte(port) }
+func (b *ramBus) Flag(n int) bool           { return b.flags[n] }
+
+func TestCDP1802(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		prog   []byte
+		steps  int
+		cycles int
+		setup  func(c *CDP1802, bus *ramBus)
+		check  func(c *CDP1802, bus *ramBus) bool
+	}{
+		{"LDI ADI carry", []byte{0xF8, 0xF0, 0xFC, 0x20}, 2, 4, nil,
+			func(c *CDP1802, bus *ramBus) bool { return c.D == 0x10 && c.DF }},
+		{"SMI borrow", []byte{0xF8, 0x10, 0xFF, 0x20}, 2, 4, nil,
+			func(c *CDP1802, bus *ramBus) bool { return c.D == 0xF0 && !c.DF }},
+		{"SDI no borrow", []byte{0xF8, 0x10, 0xFD, 0x30}, 2, 4, nil,
+			func(c *CDP1802, bus *ramBus) bool { return c.D == 0x20 && c.DF }},
+		{"ADCI with carry", []byte{0xF8, 0xFF, 0xFC, 0x01, 0xF8, 0x01, 0x7C, 0x01}, 4, 8, nil,
+			func(c *CDP1802, bus *ramBus) bool { return c.D == 0x03 && !c.DF }},
+		{"SMBI with borrow", []byte{0xF8, 0x00, 0xFF, 0x01, 0xF8, 0x05, 0x7F, 0x01}, 4, 8, nil,
+			func(c *CDP1802, bus *ramBus) bool { return c.D == 0x03 && c.DF }},
+		{"SHR SHRC", []byte{0xF8, 0x03, 0xF6, 0x76}, 3, 6, nil,
+			func(c *CDP1802, bus *ramBus) bool { return c.D == 0x80 && c.DF }},
+		{"SHL SHLC", []byte{0xF8, 0xC0, 0xFE, 0x7E}, 3, 6, nil,
+			func(c *CDP1802, bus *ramBus) bool { return c.D == 0x01 && c.DF }},
+		{"logic", []byte{0xF8, 0xF0, 0xF9, 0x0F, 0xFA, 0x3C, 0xFB, 0xFF}, 4, 8, nil,
+			func(c *CDP1802, bus *ramBus) bool { return c.D == 0xC3 }},
+		{"PHI PLO GHI GLO", []byte{0xF8, 0x12, 0xB7, 0xF8, 0x34, 0xA7, 0x97}, 5, 10, nil,
+			func(c *CDP1802, bus *ramBus) bool { return c.R[7] == 0x1234 && c.D == 0x12 }},
+		{"INC DEC", []byte{0x17, 0x17, 0x27}, 3, 6, nil,
+			func(c *CDP1802, bus *ramBus) bool { return c.R[7] == 1 }},
+		{"STR LDN LDA", []byte{0xF8, 0x55, 0x5F, 0xF8, 0x00, 0x0F, 0x4F}, 5, 10,
+			func(c *CDP1802, bus *ramBus) { c.R[0xF] = 0x100 },
+			func(c *CDP1802, bus *ramBus) bool {
+				return bus.mem[0x100] == 0x55 && c.D == 0x55 && c.R[0xF] == 0x101
+			}},
+		{"STXD IRX LDX", []byte{0xE2, 0xF8, 0x42, 0x73, 0x60, 0xF0}, 5, 10,
+			func(c *CDP1802, bus *ramBus) { c.R[2] = 0x100 },
+			func(c *CDP1802, bus *ramBus) bool { return c.R[2] == 0x100 && c.D == 0x42 }},
+		{"arithmetic with memory", []byte{0xE2, 0xF8, 0x05, 0xF5, 0xF7, 0xF4}, 5, 10,
+			func(c *CDP1802, bus *ramBus) { c.R[2] = 0x100; bus.mem[0x100] = 0x03 },
+			func(c *CDP1802, bus *ramBus) bool { return c.D == 0xFE && !c.DF }},
+		{"BZ taken", []byte{0xF8, 0x00, 0x32, 0x10}, 2, 4, nil,
+			func(c *CDP1802, bus *ramBus) bool { return c.R[0] == 0x10 }},
+		{"BNZ not taken", []byte{0xF8, 0x00, 0x3A, 0x10}, 2, 4, nil,
+			func(c *CDP1802, bus *ramBus) bool { return c.R[0] == 0x04 }},
+		{"B3 flag", []byte{0x36, 0x20}, 1, 2,
+			func(c *CDP1802, bus *ramBus) { bus.flags[3] = true },
+			func(c *CDP1802, bus *ramBus) bool { return c.R[0] == 0x20 }},
+		{"LBR", []byte{0xC0, 0x12, 0x34}, 1, 3, nil,
+			func(c *CDP1802, bus *ramBus) bool { return c.R[0] == 0x1234 }},
+		{"LSKP", []byte{0xC8, 0xF8, 0x01, 0xF8, 0x02}, 2, 5, nil,
+			func(c *CDP1802, bus *ramBus) bool { return c.D == 0x02 }},
+		{"LSZ not taken", []byte{0xF8, 0x01, 0xCE, 0xF8, 0x03}, 3, 7, nil,
+			func(c *CDP1802, bus *ramBus) bool { return c.D == 0x03 }},
+		{"SEQ REQ", []byte{0x7B}, 1, 2, nil,
+			func(c *CDP1802, bus *ramBus) bool { return c.Q }},
+		{"SEP SEX", []byte{0xF8, 0x10, 0xA3, 0xD3}, 3, 6, nil,
+			func(c *CDP1802, bus *ramBus) bool { return c.P == 3 && c.R[3] == 0x10 }},
+		{"OUT INP", []byte{0xE2, 0x64, 0x6C}, 3, 6,
+			func(c *CDP1802, bus *ramBus) { c.R[2] = 0x100; bus.mem[0x100] = 0x77 },
+			func(c *CDP1802, bus *ramBus) bool {
+				return string(bus.out) == "\x40\x77" && c.D == 0xA4 && bus.mem[0x101] == 0xA4
+			}},
+		{"MARK RET", []byte{0x79, 0xE2, 0x12, 0x70}, 4, 8,
+			func(c *CDP1802, bus *ramBus) { c.R[2] = 0x100; c.X = 5 },
+			func(c *CDP1802, bus *ramBus) bool {
+				return c.X == 5 && c.P == 0 && c.R[2] == 0x101 && c.IE
+			}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var c CDP1802
+			bus := new(ramBus)
+			c.Reset()
+			copy(bus.mem[:], tc.prog)
+			if tc.setup != nil {
+				tc.setup(&c, bus)
+			}
+			cycles := 0
+			for n := 0; n < tc.steps; n++ {
+				cycles += c.Step(bus)
+			}
+			if !tc.check(&c, bus) {
+				t.Errorf("got %+v", c)
+			}
+			if cycles != tc.cycles {
+				t.Errorf("took %d cycles, want %d", cycles, tc.cycles)
+			}
+		})
+	}
+}
+
+// TestCDP1802Interrupt enters the routine at R1 and returns with RET.
+func TestCDP1802Interrupt(t *testing.T) {
+	var c CDP1802
+	bus := new(ramBus)
+	c.Reset()
+	c.R[1], c.R[2], c.P, c.X = 0x300, 0x1FF, 3, 4
+	copy(bus.mem[0x300:], []byte{0x22, 0x78, 0x70}) // DEC 2, SAV, RET
+	if c.Interrupt() != 1 || c.P != 1 || c.X != 2 || c.IE {
+		t.Fatalf("after the interrupt %+v", c)
+	}
+	if c.Interrupt() != 0 {
+		t.Errorf("interrupt taken while disabled")
+	}
+	for n := 0; n < 3; n++ {
+		c.Step(bus)
+	}
+	if c.P != 3 || c.X != 4 || !c.IE || c.R[2] != 0x1FF {
+		t.Errorf("after RET %+v", c)
+	}
+}
@@ -0,0 +1,148 @@
+package chip8
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"text/tabwriter"
+	"time"
+)
+
+const CONFORMANCE_FRAMES = 300
+
+// CONFORMANCE_URL is a release of Timendus' CHIP-8 test suite. It is GPL-3.0
+// licensed, so it is downloaded when the test runs instead of being
+// distributed with this repository.
+const CONFORMANCE_URL = "https://github.com/Timendus/chip8-test-suite/raw/v4.1/bin/"
+
+// conformanceROMs are the ROMs of the suite the test runs. platforms gives
+// the value the quirks ROM reads from 0x1FF to test a platform without its
+// menu, for the profiles with a platform there.
+var conformanceROMs = []struct {
+	name      string
+	file      string
+	platforms map[string]byte
+}{
+	{"corax+", "3-corax+.ch8", nil},
+	{"flags", "4-flags.ch8", nil},
+	{"quirks", "5-quirks.ch8", map[string]byte{"chip8": 1, "schip": 2}},
+}
+
+// The glyphs read as a check which passed and one which failed, a check
+// mark and a cross 5 pixels wide. A screen without either is printed, so
+// that the glyphs can be compared with what the ROM drew.
+var (
+	glyphOK   = []byte{0x00, 0x08, 0x10, 0xA0, 0x40}
+	glyphFail = []byte{0x88, 0x50, 0x20, 0x50, 0x88}
+)
+
+// downloadConformance fetches a ROM of the suite, skipping the test if it
+// cannot be downloaded.
+func downloadConformance(t *testing.T, file string) []byte {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(CONFORMANCE_URL + file)
+	if err != nil {
+		t.Skipf("cannot download %s: %v", file, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Skipf("cannot download %s: %s", file, resp.Status)
+	}
+	rom, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Skipf("cannot download %s: %v", file, err)
+	}
+	return rom
+}
+
+// countGlyph counts the places the display shows glyph with a blank pixel
+// on every side, so that parts of the labels do not count.
+func countGlyph(vme *VideoMemory, glyph []byte) int {
+	w, h := vme.Size()
+	lit := func(x, y int) byte {
+		if x < 0 || y < 0 || x >= w || y >= h {
+			return 0
+		}
+		return vme.buf[x][y]
+	}
+	count := 0
+	for x0 := 0; x0+5 <= w; x0++ {
+	next:
+		for y0 := 0; y0+len(glyph) <= h; y0++ {
+			for y := -1; y <= len(glyph); y++ {
+				for x := -1; x <= 5; x++ {
+					want := byte(0)
+					if y >= 0 && y < len(glyph) && x >= 0 && x < 5 {
+						want = glyph[y] >> (7 - x) & 1
+					}
+					if lit(x0+x, y0+y) != want {
+						continue next
+					}
+				}
+			}
+			count++
+		}
+	}
+	return count
+}
+
+// TestConformance runs the corax+, flags and quirks ROMs of the test suite
+// under every quirk profile, and reads back the check marks and crosses
+// they draw. No check may fail; the quirks ROM only runs for profiles that
+// match one of its platforms. Run with -v to see the compatibility matrix.
+func TestConformance(t *testing.T) {
+	profiles := []string{}
+	for name := range QUIRK_PROFILES {
+		profiles = append(profiles, name)
+	}
+	sort.Strings(profiles)
+
+	var matrix strings.Builder
+	w := tabwriter.NewWriter(&matrix, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "\t%s\n", strings.Join(profiles, "\t"))
+
+	for _, suite := range conformanceROMs {
+		rom := downloadConformance(t, suite.file)
+		results := []string{}
+		for _, profile := range profiles {
+			platform, ok := suite.platforms[profile]
+			if suite.platforms != nil && !ok {
+				results = append(results, "-")
+				continue
+			}
+			sys := NewSystem()
+			sys.Cpu.Quirks = QUIRK_PROFILES[profile]
+			if err := sys.Mem.LoadBytes(rom); err != nil {
+				t.Fatal(err)
+			}
+			if platform != 0 {
+				sys.Mem.store(0x1FF, platform)
+			}
+			for sys.Frame < CONFORMANCE_FRAMES {
+				if err := sys.RunFrame(); err != nil {
+					t.Fatalf("%s under %s: %v", suite.name, profile, err)
+				}
+			}
+
+			passed, failed := countGlyph(sys.Vme, glyphOK), countGlyph(sys.Vme, glyphFail)
+			switch {
+			case passed+failed == 0:
+				_, height := sys.Vme.Size()
+				var screen strings.Builder
+				for y := 0; y < height; y++ {
+					fmt.Fprintln(&screen, sys.Vme.row(y))
+				}
+				t.Errorf("%s under %s shows no results:\n%s", suite.name, profile, screen.String())
+			case failed > 0:
+				t.Errorf("%s under %s: %d checks failed", suite.name, profile, failed)
+			}
+			results = append(results, fmt.Sprintf("%d/%d", passed, passed+failed))
+		}
+		fmt.Fprintf(w, "%s\t%s\n", suite.name, strings.Join(results, "\t"))
+	}
+	w.Flush()
+	t.Log("\n" + matrix.String())
+}
@@ -0,0 +1,155 @@
+package chip8
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Audio plays the buzzer. *audio.Player of ebiten satisfies it.
+type Audio interface {
+	Play()
+	Rewind() error
+}
+
+// Quirks selects between the behaviours of CHIP-8 interpreters that disagree
+// on some instructions.
+type Quirks struct {
+	Shift     bool // 8xy6/8xyE shift vx in place instead of vy.
+	LoadStore bool // Fx55/Fx65 leave i unchanged.
+	Jump      bool // Bnnn jumps to nnn+vx instead of nnn+v0.
+	Logic     bool // 8xy1/8xy2/8xy3 reset vf.
+	Clip      bool // Dxyn clips sprites at the screen edges instead of wrapping.
+	VBlank    bool // Dxyn waits for the start of the next frame.
+}
+
+// QUIRK_PROFILES are the quirks of well-known interpreters.
+var QUIRK_PROFILES = map[string]Quirks{
+	"chip8":  {Logic: true, Clip: true, VBlank: true},                // COSMAC VIP.
+	"schip":  {Shift: true, LoadStore: true, Jump: true, Clip: true}, // SUPER-CHIP 1.1.
+	"xochip": {},                                                     // Octo.
+	"modern": {Shift: true, LoadStore: true, Clip: true},             // Default of NewCpu.
+}
+
+type Cpu struct {
+	v       [64]uint8
+	i       uint16
+	stack   [16]uint16
+	sp      uint16
+	pc      uint16
+	dt      uint16
+	st      uint16
+	rnd     *rand.Rand
+	Quirks  Quirks
+	vblank  bool // No instruction has executed since the frame started.
+	waiting bool // Dxyn is stalled until the next frame.
+	Trace   *Tracer
+	Sprites *SpriteLog // If set, collects the sprites drawn.
+}
+
+func NewCpu() *Cpu {
+	cpu := new(Cpu)
+	cpu.pc = 0x200
+	cpu.Quirks = QUIRK_PROFILES["modern"]
+	cpu.rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+	return cpu
+}
+
+// Seed makes the random numbers of Cxkk repeatable.
+func (cpu *Cpu) Seed(seed int64) {
+	cpu.rnd = rand.New(rand.NewSource(seed))
+}
+
+func (cpu *Cpu) rand() uint8 {
+	return uint8(cpu.rnd.Intn(256))
+}
+
+// Tick executes one instruction. It returns a *CpuError if the instruction
+// cannot execute.
+//
+// Instructions are decoded once and cached by address in Memory, which
+// drops them when the program writes over them.
+func (cpu *Cpu) Tick(mem *Memory, vme *VideoMemory, kb *Keyboard) error {
+	if int(cpu.pc)+1 >= len(mem.buf) {
+		return &CpuError{PC: cpu.pc, Err: ErrMemory}
+	}
+	pc := cpu.pc
+	var op uint16
+	if cpu.Trace != nil {
+		op = mem.opcode(pc)
+		cpu.Trace.fetch(cpu, pc, op)
+	}
+	inst := mem.code[pc]
+	if inst == nil {
+		inst, mem.names[pc] = decode(mem.opcode(pc))
+		mem.code[pc] = inst
+		if cpu.Trace != nil {
+			cpu.Trace.event("decode", pc, op, mem.names[pc], nil)
+		}
+	}
+	// The instruction may write over itself, so take its name first.
+	name := mem.names[pc]
+	err := inst(cpu, mem, vme, kb)
+	if cpu.Trace != nil {
+		cpu.trace(pc, op, name, err)
+	}
+	switch err {
+	case nil:
+		cpu.vblank = false
+		return nil
+	case errWaiting:
+		return nil
+	default:
+		return err
+	}
+}
+
+func (cpu *Cpu) trace(pc, op uint16, name string, err error) {
+	switch err {
+	case nil:
+		cpu.Trace.instruction(pc, op, name)
+	case errWaiting:
+		cpu.Trace.event("wait", pc, op, name, nil)
+	default:
+		cpu.Trace.event("error", pc, op, name, err)
+	}
+}
+
+// fail reports that the instruction at pc cannot execute.
+func (cpu *Cpu) fail(op uint16, err error) error {
+	return &CpuError{PC: cpu.pc, Opcode: op, Err: err}
+}
+
+// VBlank starts a frame. Under the VBlank quirk Dxyn only executes as the
+// first instruction of a frame, as on the COSMAC VIP where it waits for the
+// vertical interrupt.
+func (cpu *Cpu) VBlank() {
+	cpu.vblank = true
+	cpu.waiting = false
+}
+
+// Waiting reports whether Dxyn is stalled until the next frame.
+func (cpu *Cpu) Waiting() bool {
+	return cpu.waiting
+}
+
+func vf(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// TickTimers counts the delay and sound timers down. It is called at 60 Hz,
+// once per frame.
+func (cpu *Cpu) TickTimers(audio Audio) {
+	if cpu.dt > 0 {
+		cpu.dt -= 1
+	}
+	if cpu.st > 0 {
+		if audio != nil {
+			audio.Play()
+			audio.Rewind()
+		}
+		cpu.st -= 1
+	}
+}
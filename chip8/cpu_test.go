@@ -0,0 +1,392 @@
+package chip8
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Memory.Load logs the programs it reads.
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+// cpuState is the part of the machine an opcode test sets up and checks.
+type cpuState struct {
+	v      [16]uint8
+	i      uint16
+	pc     uint16
+	sp     uint16
+	stack  [16]uint16
+	dt, st uint16
+	mem    map[uint16]byte
+}
+
+// opcodeTest runs one instruction. before defaults to pc 0x200; after
+// receives a copy of before with pc advanced by 2 and applies the expected
+// changes to it. only limits the test to some quirks.
+type opcodeTest struct {
+	name   string
+	op     uint16
+	keys   []uint16
+	only   func(q Quirks) bool
+	before cpuState
+	after  func(s *cpuState)
+	check  func(t *testing.T, vme *VideoMemory)
+}
+
+func (tc *opcodeTest) run(t *testing.T, quirks Quirks) {
+	cpu, mem, vme, kb := NewCpu(), NewMemory(), NewVideoMemory(), NewKeyboard()
+	cpu.Quirks = quirks
+	cpu.Seed(1)
+	cpu.VBlank()
+
+	before := tc.before
+	if before.pc == 0 {
+		before.pc = PROGRAM_START
+	}
+	copy(cpu.v[:], before.v[:])
+	cpu.i, cpu.pc, cpu.sp, cpu.stack, cpu.dt, cpu.st = before.i, before.pc, before.sp, before.stack, before.dt, before.st
+	for addr, b := range before.mem {
+		mem.buf[addr] = b
+	}
+	mem.buf[cpu.pc] = byte(tc.op >> 8)
+	mem.buf[cpu.pc+1] = byte(tc.op)
+	for _, key := range tc.keys {
+		kb.Push(key)
+	}
+
+	if err := cpu.Tick(mem, vme, kb); err != nil {
+		t.Fatal(err)
+	}
+
+	want := before
+	want.pc += 2
+	want.mem = map[uint16]byte{}
+	for addr, b := range before.mem {
+		want.mem[addr] = b
+	}
+	if tc.after != nil {
+		tc.after(&want)
+	}
+
+	for n := range want.v {
+		if cpu.v[n] != want.v[n] {
+			t.Errorf("v%X = %#02x, want %#02x", n, cpu.v[n], want.v[n])
+		}
+	}
+	if cpu.i != want.i {
+		t.Errorf("i = %#03x, want %#03x", cpu.i, want.i)
+	}
+	if cpu.pc != want.pc {
+		t.Errorf("pc = %#03x, want %#03x", cpu.pc, want.pc)
+	}
+	if cpu.sp != want.sp || cpu.stack != want.stack {
+		t.Errorf("stack = %v sp %d, want %v sp %d", cpu.stack, cpu.sp, want.stack, want.sp)
+	}
+	if cpu.dt != want.dt || cpu.st != want.st {
+		t.Errorf("dt, st = %d, %d, want %d, %d", cpu.dt, cpu.st, want.dt, want.st)
+	}
+	for addr, b := range want.mem {
+		if mem.buf[addr] != b {
+			t.Errorf("mem[%#03x] = %#02x, want %#02x", addr, mem.buf[addr], b)
+		}
+	}
+	if tc.check != nil {
+		tc.check(t, vme)
+	}
+}
+
+func regs(pairs ...uint8) (v [16]uint8) {
+	for n := 0; n+1 < len(pairs); n += 2 {
+		v[pairs[n]] = pairs[n+1]
+	}
+	return v
+}
+
+func shift(q Quirks) bool       { return q.Shift }
+func noShift(q Quirks) bool     { return !q.Shift }
+func loadStore(q Quirks) bool   { return q.LoadStore }
+func noLoadStore(q Quirks) bool { return !q.LoadStore }
+func jump(q Quirks) bool        { return q.Jump }
+func noJump(q Quirks) bool      { return !q.Jump }
+func logic(q Quirks) bool       { return q.Logic }
+func noLogic(q Quirks) bool     { return !q.Logic }
+
+var opcodeTests = []opcodeTest{
+	{name: "00E0 CLS", op: 0x00E0,
+		check: func(t *testing.T, vme *VideoMemory) {
+			if !vme.erased {
+				t.Error("display not marked erased")
+			}
+		}},
+	{name: "00EE RET", op: 0x00EE,
+		before: cpuState{pc: 0x300, sp: 1, stack: [16]uint16{0x234}},
+		after:  func(s *cpuState) { s.pc, s.sp = 0x236, 0 }},
+	{name: "00FE LOW", op: 0x00FE,
+		check: func(t *testing.T, vme *VideoMemory) {
+			if vme.hires || !vme.erased {
+				t.Errorf("hires = %v, erased = %v, want 64x32 and cleared", vme.hires, vme.erased)
+			}
+		}},
+	{name: "00FF HIGH", op: 0x00FF,
+		check: func(t *testing.T, vme *VideoMemory) {
+			if w, h := vme.Size(); !vme.hires || w != 128 || h != 64 || !vme.erased {
+				t.Errorf("size %dx%d, erased = %v, want 128x64 and cleared", w, h, vme.erased)
+			}
+		}},
+	{name: "0nnn SYS", op: 0x0345},
+	{name: "0000 SYS", op: 0x0000},
+	{name: "1nnn JP", op: 0x1ABC,
+		after: func(s *cpuState) { s.pc = 0xABC }},
+	{name: "2nnn CALL", op: 0x2ABC,
+		before: cpuState{pc: 0x208},
+		after:  func(s *cpuState) { s.pc, s.sp, s.stack[0] = 0xABC, 1, 0x208 }},
+	{name: "3xkk SE taken", op: 0x3312,
+		before: cpuState{v: regs(3, 0x12)},
+		after:  func(s *cpuState) { s.pc += 2 }},
+	{name: "3xkk SE not taken", op: 0x3313,
+		before: cpuState{v: regs(3, 0x12)}},
+	{name: "4xkk SNE taken", op: 0x4313,
+		before: cpuState{v: regs(3, 0x12)},
+		after:  func(s *cpuState) { s.pc += 2 }},
+	{name: "4xkk SNE not taken", op: 0x4312,
+		before: cpuState{v: regs(3, 0x12)}},
+	{name: "5xy0 SE taken", op: 0x5340,
+		before: cpuState{v: regs(3, 7, 4, 7)},
+		after:  func(s *cpuState) { s.pc += 2 }},
+	{name: "5xy0 SE not taken", op: 0x5340,
+		before: cpuState{v: regs(3, 7, 4, 8)}},
+	{name: "6xkk LD", op: 0x6A42,
+		after: func(s *cpuState) { s.v[0xA] = 0x42 }},
+	{name: "7xkk ADD wraps without flag", op: 0x7A02,
+		before: cpuState{v: regs(0xA, 0xFF, 0xF, 0x55)},
+		after:  func(s *cpuState) { s.v[0xA] = 0x01 }},
+	{name: "8xy0 LD", op: 0x8120,
+		before: cpuState{v: regs(1, 3, 2, 9)},
+		after:  func(s *cpuState) { s.v[1] = 9 }},
+	{name: "8xy1 OR", op: 0x8121, only: noLogic,
+		before: cpuState{v: regs(1, 0x0F, 2, 0xF0, 0xF, 0x55)},
+		after:  func(s *cpuState) { s.v[1] = 0xFF }},
+	{name: "8xy1 OR resets vF", op: 0x8121, only: logic,
+		before: cpuState{v: regs(1, 0x0F, 2, 0xF0, 0xF, 0x55)},
+		after:  func(s *cpuState) { s.v[1], s.v[0xF] = 0xFF, 0 }},
+	{name: "8xy2 AND", op: 0x8122, only: noLogic,
+		before: cpuState{v: regs(1, 0x3C, 2, 0x0F, 0xF, 0x55)},
+		after:  func(s *cpuState) { s.v[1] = 0x0C }},
+	{name: "8xy2 AND resets vF", op: 0x8122, only: logic,
+		before: cpuState{v: regs(1, 0x3C, 2, 0x0F, 0xF, 0x55)},
+		after:  func(s *cpuState) { s.v[1], s.v[0xF] = 0x0C, 0 }},
+	{name: "8xy3 XOR", op: 0x8123, only: noLogic,
+		before: cpuState{v: regs(1, 0x3C, 2, 0x0F, 0xF, 0x55)},
+		after:  func(s *cpuState) { s.v[1] = 0x33 }},
+	{name: "8xy3 XOR resets vF", op: 0x8123, only: logic,
+		before: cpuState{v: regs(1, 0x3C, 2, 0x0F, 0xF, 0x55)},
+		after:  func(s *cpuState) { s.v[1], s.v[0xF] = 0x33, 0 }},
+	{name: "8xy4 ADD", op: 0x8124,
+		before: cpuState{v: regs(1, 0x10, 2, 0x20, 0xF, 0x55)},
+		after:  func(s *cpuState) { s.v[1], s.v[0xF] = 0x30, 0 }},
+	{name: "8xy4 ADD carry", op: 0x8124,
+		before: cpuState{v: regs(1, 0xF0, 2, 0x20)},
+		after:  func(s *cpuState) { s.v[1], s.v[0xF] = 0x10, 1 }},
+	{name: "8Fy4 ADD flag wins", op: 0x8F24,
+		before: cpuState{v: regs(2, 0x20, 0xF, 0xF0)},
+		after:  func(s *cpuState) { s.v[0xF] = 1 }},
+	{name: "8xy5 SUB", op: 0x8125,
+		before: cpuState{v: regs(1, 0x30, 2, 0x10)},
+		after:  func(s *cpuState) { s.v[1], s.v[0xF] = 0x20, 1 }},
+	{name: "8xy5 SUB equal does not borrow", op: 0x8125,
+		before: cpuState{v: regs(1, 0x30, 2, 0x30)},
+		after:  func(s *cpuState) { s.v[1], s.v[0xF] = 0x00, 1 }},
+	{name: "8xy5 SUB borrow", op: 0x8125,
+		before: cpuState{v: regs(1, 0x10, 2, 0x30, 0xF, 1)},
+		after:  func(s *cpuState) { s.v[1], s.v[0xF] = 0xE0, 0 }},
+	{name: "8Fy5 SUB flag wins", op: 0x8F25,
+		before: cpuState{v: regs(2, 0x10, 0xF, 0x30)},
+		after:  func(s *cpuState) { s.v[0xF] = 1 }},
+	{name: "8xy6 SHR vx", op: 0x8126, only: shift,
+		before: cpuState{v: regs(1, 0x05, 2, 0x80)},
+		after:  func(s *cpuState) { s.v[1], s.v[0xF] = 0x02, 1 }},
+	{name: "8xy6 SHR vy", op: 0x8126, only: noShift,
+		before: cpuState{v: regs(1, 0x05, 2, 0x80)},
+		after:  func(s *cpuState) { s.v[1], s.v[0xF] = 0x40, 0 }},
+	{name: "8Fy6 SHR flag wins", op: 0x8FF6,
+		before: cpuState{v: regs(0xF, 0x03)},
+		after:  func(s *cpuState) { s.v[0xF] = 1 }},
+	{name: "8xy7 SUBN", op: 0x8127,
+		before: cpuState{v: regs(1, 0x10, 2, 0x30)},
+		after:  func(s *cpuState) { s.v[1], s.v[0xF] = 0x20, 1 }},
+	{name: "8xy7 SUBN equal does not borrow", op: 0x8127,
+		before: cpuState{v: regs(1, 0x30, 2, 0x30)},
+		after:  func(s *cpuState) { s.v[1], s.v[0xF] = 0x00, 1 }},
+	{name: "8xy7 SUBN borrow", op: 0x8127,
+		before: cpuState{v: regs(1, 0x30, 2, 0x10, 0xF, 1)},
+		after:  func(s *cpuState) { s.v[1], s.v[0xF] = 0xE0, 0 }},
+	{name: "8Fy7 SUBN flag wins", op: 0x8F27,
+		before: cpuState{v: regs(2, 0x10, 0xF, 0x30)},
+		after:  func(s *cpuState) { s.v[0xF] = 0 }},
+	{name: "8xyE SHL vx", op: 0x812E, only: shift,
+		before: cpuState{v: regs(1, 0x81, 2, 0x40)},
+		after:  func(s *cpuState) { s.v[1], s.v[0xF] = 0x02, 1 }},
+	{name: "8xyE SHL vy", op: 0x812E, only: noShift,
+		before: cpuState{v: regs(1, 0x81, 2, 0x40)},
+		after:  func(s *cpuState) { s.v[1], s.v[0xF] = 0x80, 0 }},
+	{name: "8FyE SHL flag wins", op: 0x8FFE,
+		before: cpuState{v: regs(0xF, 0x80)},
+		after:  func(s *cpuState) { s.v[0xF] = 1 }},
+	{name: "9xy0 SNE taken", op: 0x9340,
+		before: cpuState{v: regs(3, 7, 4, 8)},
+		after:  func(s *cpuState) { s.pc += 2 }},
+	{name: "9xy0 SNE not taken", op: 0x9340,
+		before: cpuState{v: regs(3, 7, 4, 7)}},
+	{name: "Annn LD I", op: 0xA123,
+		after: func(s *cpuState) { s.i = 0x123 }},
+	{name: "Bnnn JP v0", op: 0xB300, only: noJump,
+		before: cpuState{v: regs(0, 0x10, 3, 0x20)},
+		after:  func(s *cpuState) { s.pc = 0x310 }},
+	{name: "Bxnn JP vx", op: 0xB300, only: jump,
+		before: cpuState{v: regs(0, 0x10, 3, 0x20)},
+		after:  func(s *cpuState) { s.pc = 0x320 }},
+	{name: "Cxkk RND masks", op: 0xC100,
+		before: cpuState{v: regs(1, 0x55)},
+		after:  func(s *cpuState) { s.v[1] = 0 }},
+	{name: "Dxyn DRW", op: 0xD125,
+		before: cpuState{v: regs(1, 8, 2, 4), i: 0},
+		after:  func(s *cpuState) { s.v[0xF] = 0 },
+		check: func(t *testing.T, vme *VideoMemory) {
+			// The font glyph for 0 is F0 90 90 90 F0.
+			if vme.buf[8][4] != 1 || vme.buf[9][5] != 0 || vme.buf[11][8] != 1 {
+				t.Error("glyph not drawn")
+			}
+		}},
+	{name: "Ex9E SKP pressed", op: 0xE19E, keys: []uint16{5},
+		before: cpuState{v: regs(1, 5)},
+		after:  func(s *cpuState) { s.pc += 2 }},
+	{name: "Ex9E SKP not pressed", op: 0xE19E, keys: []uint16{4},
+		before: cpuState{v: regs(1, 5)}},
+	{name: "ExA1 SKNP pressed", op: 0xE1A1, keys: []uint16{5},
+		before: cpuState{v: regs(1, 5)}},
+	{name: "ExA1 SKNP not pressed", op: 0xE1A1,
+		before: cpuState{v: regs(1, 5)},
+		after:  func(s *cpuState) { s.pc += 2 }},
+	{name: "Fx07 LD Vx, DT", op: 0xF107,
+		before: cpuState{dt: 0x33},
+		after:  func(s *cpuState) { s.v[1] = 0x33 }},
+	{name: "Fx0A LD Vx, K waits", op: 0xF10A,
+		after: func(s *cpuState) { s.pc -= 2 }},
+	{name: "Fx0A LD Vx, K", op: 0xF10A, keys: []uint16{0xB},
+		after: func(s *cpuState) { s.v[1] = 0xB }},
+	{name: "Fx15 LD DT", op: 0xF115,
+		before: cpuState{v: regs(1, 0x20)},
+		after:  func(s *cpuState) { s.dt = 0x20 }},
+	{name: "Fx18 LD ST", op: 0xF118,
+		before: cpuState{v: regs(1, 0x20)},
+		after:  func(s *cpuState) { s.st = 0x20 }},
+	{name: "Fx1E ADD I", op: 0xF11E,
+		before: cpuState{v: regs(1, 0x20), i: 0x300},
+		after:  func(s *cpuState) { s.i = 0x320 }},
+	{name: "Fx29 LD F", op: 0xF129,
+		before: cpuState{v: regs(1, 0x0A)},
+		after:  func(s *cpuState) { s.i = 50 }},
+	{name: "Fx29 LD F uses the low nibble", op: 0xF129,
+		before: cpuState{v: regs(1, 0xFA)},
+		after:  func(s *cpuState) { s.i = 50 }},
+	{name: "Fx33 LD B", op: 0xF133,
+		before: cpuState{v: regs(1, 234), i: 0x300},
+		after: func(s *cpuState) {
+			s.mem[0x300], s.mem[0x301], s.mem[0x302] = 2, 3, 4
+		}},
+	{name: "Fx55 LD [I]", op: 0xF255, only: loadStore,
+		before: cpuState{v: regs(0, 1, 1, 2, 2, 3, 3, 4), i: 0x300},
+		after: func(s *cpuState) {
+			s.mem[0x300], s.mem[0x301], s.mem[0x302], s.mem[0x303] = 1, 2, 3, 0
+		}},
+	{name: "Fx55 LD [I] increments I", op: 0xF255, only: noLoadStore,
+		before: cpuState{v: regs(0, 1, 1, 2, 2, 3, 3, 4), i: 0x300},
+		after: func(s *cpuState) {
+			s.mem[0x300], s.mem[0x301], s.mem[0x302], s.mem[0x303] = 1, 2, 3, 0
+			s.i = 0x303
+		}},
+	{name: "Fx65 LD Vx, [I]", op: 0xF265, only: loadStore,
+		before: cpuState{i: 0x300, mem: map[uint16]byte{0x300: 7, 0x301: 8, 0x302: 9, 0x303: 10}},
+		after:  func(s *cpuState) { s.v[0], s.v[1], s.v[2] = 7, 8, 9 }},
+	{name: "Fx65 LD Vx, [I] increments I", op: 0xF265, only: noLoadStore,
+		before: cpuState{i: 0x300, mem: map[uint16]byte{0x300: 7, 0x301: 8, 0x302: 9, 0x303: 10}},
+		after:  func(s *cpuState) { s.v[0], s.v[1], s.v[2], s.i = 7, 8, 9, 0x303 }},
+}
+
+// TestOpcodes runs every opcode test under every quirk profile it applies to.
+func TestOpcodes(t *testing.T) {
+	profiles := []string{}
+	for name := range QUIRK_PROFILES {
+		profiles = append(profiles, name)
+	}
+	sort.Strings(profiles)
+
+	for _, profile := range profiles {
+		quirks := QUIRK_PROFILES[profile]
+		t.Run(profile, func(t *testing.T) {
+			for _, tc := range opcodeTests {
+				tc := tc
+				if tc.only != nil && !tc.only(quirks) {
+					continue
+				}
+				t.Run(tc.name, func(t *testing.T) {
+					tc.run(t, quirks)
+				})
+			}
+		})
+	}
+}
+
+func TestTickErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		op     uint16
+		before cpuState
+		want   error
+	}{
+		{"00EE with an empty stack", 0x00EE, cpuState{}, ErrStackUnderflow},
+		{"2nnn with a full stack", 0x2400, cpuState{sp: 16}, ErrStackOverflow},
+		{"Dxyn past the end of memory", 0xD005, cpuState{i: 0xFFC}, ErrMemory},
+		{"Fx33 past the end of memory", 0xF033, cpuState{i: 0xFFE}, ErrMemory},
+		{"Fx55 past the end of memory", 0xFF55, cpuState{i: 0xFF8}, ErrMemory},
+		{"Fx65 past the end of memory", 0xFF65, cpuState{i: 0xFF8}, ErrMemory},
+		{"fetch past the end of memory", 0x0000, cpuState{pc: 0xFFF}, ErrMemory},
+		{"8xy8", 0x8128, cpuState{}, ErrOpcode},
+		{"Fx99", 0xF199, cpuState{}, ErrOpcode},
+		{"5xy1", 0x5121, cpuState{}, ErrOpcode},
+		{"9xy1", 0x9121, cpuState{}, ErrOpcode},
+		{"E19F", 0xE19F, cpuState{}, ErrOpcode},
+		{"ExA2", 0xE1A2, cpuState{}, ErrOpcode},
+		{"F12A", 0xF12A, cpuState{}, ErrOpcode},
+		{"Fx30", 0xF130, cpuState{}, ErrOpcode},
+		{"Fx0B", 0xF10B, cpuState{}, ErrOpcode},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cpu, mem := NewCpu(), NewMemory()
+			if tc.before.pc != 0 {
+				cpu.pc = tc.before.pc
+			}
+			cpu.i, cpu.sp = tc.before.i, tc.before.sp
+			if int(cpu.pc)+1 < len(mem.buf) {
+				mem.buf[cpu.pc] = byte(tc.op >> 8)
+				mem.buf[cpu.pc+1] = byte(tc.op)
+			}
+			pc := cpu.pc
+
+			err := cpu.Tick(mem, NewVideoMemory(), NewKeyboard())
+			var cpuErr *CpuError
+			if !errors.As(err, &cpuErr) || !errors.Is(err, tc.want) {
+				t.Fatalf("error %v, want a *CpuError for %v", err, tc.want)
+			}
+			if cpuErr.PC != pc || cpu.pc != pc {
+				t.Errorf("error at %#03x, pc %#03x, want both at %#03x", cpuErr.PC, cpu.pc, pc)
+			}
+		})
+	}
+}
@@ -0,0 +1,555 @@
+package chip8
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// DAP_CHECK_STEPS is how many instructions run between looking for requests
+// while the program runs.
+const DAP_CHECK_STEPS = 1000
+
+// Variable references of the scopes.
+const (
+	DAP_REGISTERS = 1
+	DAP_STACK     = 2
+)
+
+// DAP_THREAD is the only thread.
+const DAP_THREAD = 1
+
+// DAPServer lets editors debug a Machine over the Debug Adapter Protocol.
+// Launch loads the program named by the arguments of the launch request,
+// with the source map of its assembler source if it has one, so
+// breakpoints can be set on source lines.
+type DAPServer struct {
+	Launch func(args json.RawMessage) (Machine, *SourceMap, error)
+}
+
+func NewDAPServer(launch func(args json.RawMessage) (Machine, *SourceMap, error)) *DAPServer {
+	return &DAPServer{Launch: launch}
+}
+
+// Serve serves the editors connecting to l, one at a time.
+func (d *DAPServer) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		err = d.ServeConn(conn)
+		conn.Close()
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+}
+
+// dapRequest is a request from the editor.
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// dapMessage is a response or an event to the editor.
+type dapMessage struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq,omitempty"`
+	Success    *bool       `json:"success,omitempty"`
+	Command    string      `json:"command,omitempty"`
+	Message    string      `json:"message,omitempty"`
+	Event      string      `json:"event,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+// dapSession is a connection to an editor.
+type dapSession struct {
+	d           *DAPServer
+	w           io.Writer
+	seq         int
+	m           Machine
+	source      *SourceMap
+	lines       map[string][]int // Breakpoint lines by source path.
+	breakpoints map[uint16]bool
+	launched    bool
+	configured  bool
+	stopOnEntry bool
+	running     bool
+	done        func(s State) bool // Ends the step in progress.
+}
+
+// ServeConn runs a session with an editor until it disconnects or the
+// connection closes.
+func (d *DAPServer) ServeConn(conn io.ReadWriter) error {
+	in := make(chan dapRequest)
+	failed := make(chan error, 1)
+	quit := make(chan struct{})
+	defer close(quit)
+	go func() {
+		r := textproto.NewReader(bufio.NewReader(conn))
+		for {
+			req, err := readDAP(r)
+			if err != nil {
+				failed <- err
+				close(in)
+				return
+			}
+			select {
+			case in <- req:
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	s := &dapSession{d: d, w: conn, lines: map[string][]int{}, breakpoints: map[uint16]bool{}}
+	for {
+		var req dapRequest
+		var ok bool
+		if s.running {
+			select {
+			case req, ok = <-in:
+			default:
+				if err := s.run(DAP_CHECK_STEPS); err != nil {
+					return err
+				}
+				continue
+			}
+		} else {
+			req, ok = <-in
+		}
+		if !ok {
+			return <-failed
+		}
+		if req.Type != "request" {
+			continue
+		}
+		if end, err := s.handle(req); end || err != nil {
+			return err
+		}
+	}
+}
+
+// readDAP reads a message framed by a Content-Length header.
+func readDAP(r *textproto.Reader) (dapRequest, error) {
+	var req dapRequest
+	header, err := r.ReadMIMEHeader()
+	if err != nil {
+		return req, err
+	}
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return req, fmt.Errorf("dap: bad Content-Length %q", header.Get("Content-Length"))
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r.R, body); err != nil {
+		return req, err
+	}
+	return req, json.Unmarshal(body, &req)
+}
+
+func (s *dapSession) send(m dapMessage) error {
+	s.seq++
+	m.Seq = s.seq
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	return err
+}
+
+func (s *dapSession) respond(req dapRequest, body interface{}) error {
+	success := true
+	return s.send(dapMessage{Type: "response", RequestSeq: req.Seq, Success: &success, Command: req.Command, Body: body})
+}
+
+func (s *dapSession) fail(req dapRequest, format string, args ...interface{}) error {
+	success := false
+	return s.send(dapMessage{Type: "response", RequestSeq: req.Seq, Success: &success, Command: req.Command, Message: fmt.Sprintf(format, args...)})
+}
+
+func (s *dapSession) event(name string, body interface{}) error {
+	return s.send(dapMessage{Type: "event", Event: name, Body: body})
+}
+
+// stop stops the program and tells the editor why.
+func (s *dapSession) stop(reason, text string) error {
+	s.running = false
+	s.done = nil
+	body := map[string]interface{}{"reason": reason, "threadId": DAP_THREAD, "allThreadsStopped": true}
+	if text != "" {
+		body["text"] = text
+		body["description"] = text
+	}
+	return s.event("stopped", body)
+}
+
+// run executes up to n instructions, stopping at breakpoints, errors and
+// the end of a step.
+func (s *dapSession) run(n int) error {
+	for ; n > 0; n-- {
+		if err := s.m.Step(); err != nil {
+			s.event("output", map[string]interface{}{"category": "stderr", "output": err.Error() + "\n"})
+			return s.stop("exception", err.Error())
+		}
+		state := s.m.State()
+		if s.done != nil && s.done(state) {
+			return s.stop("step", "")
+		}
+		if s.breakpoints[state.PC] {
+			return s.stop("breakpoint", "")
+		}
+	}
+	return nil
+}
+
+// start runs the program once it is launched and configured.
+func (s *dapSession) start() error {
+	if !s.launched || !s.configured {
+		return nil
+	}
+	if s.stopOnEntry {
+		return s.stop("entry", "")
+	}
+	s.running = true
+	return nil
+}
+
+// step runs until done reports that the step is over.
+func (s *dapSession) step(done func(start, state State) bool) {
+	start := s.m.State()
+	s.done = func(state State) bool { return done(start, state) }
+	s.running = true
+}
+
+// leftLine reports whether the program counter moved on from the line the
+// step started on, or has no line to stay on.
+func (s *dapSession) leftLine(start, state State) bool {
+	line := s.source.Line(start.PC)
+	return line == 0 || s.source.Line(state.PC) != line
+}
+
+// handle answers a request, and reports whether the session is over.
+func (s *dapSession) handle(req dapRequest) (bool, error) {
+	if s.m == nil {
+		switch req.Command {
+		case "initialize", "launch", "setBreakpoints", "configurationDone", "threads", "disconnect", "terminate":
+		default:
+			return false, s.fail(req, "no program is launched")
+		}
+	}
+	switch req.Command {
+	case "initialize":
+		if err := s.respond(req, map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsSetVariable":              true,
+			"supportsReadMemoryRequest":        true,
+			"supportsWriteMemoryRequest":       true,
+		}); err != nil {
+			return false, err
+		}
+		return false, s.event("initialized", nil)
+	case "launch":
+		var args struct {
+			StopOnEntry bool `json:"stopOnEntry"`
+		}
+		json.Unmarshal(req.Arguments, &args)
+		m, source, err := s.d.Launch(req.Arguments)
+		if err != nil {
+			return false, s.fail(req, "%v", err)
+		}
+		s.m, s.source, s.stopOnEntry, s.launched = m, source, args.StopOnEntry, true
+		s.resolveBreakpoints()
+		if err := s.respond(req, nil); err != nil {
+			return false, err
+		}
+		return false, s.start()
+	case "setBreakpoints":
+		var args struct {
+			Source struct {
+				Path string `json:"path"`
+			} `json:"source"`
+			Breakpoints []struct {
+				Line int `json:"line"`
+			} `json:"breakpoints"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return false, s.fail(req, "%v", err)
+		}
+		var lines []int
+		for _, b := range args.Breakpoints {
+			lines = append(lines, b.Line)
+		}
+		s.lines[args.Source.Path] = lines
+		return false, s.respond(req, map[string]interface{}{"breakpoints": s.resolveBreakpoints()[args.Source.Path]})
+	case "configurationDone":
+		s.configured = true
+		if err := s.respond(req, nil); err != nil {
+			return false, err
+		}
+		return false, s.start()
+	case "threads":
+		return false, s.respond(req, map[string]interface{}{
+			"threads": []map[string]interface{}{{"id": DAP_THREAD, "name": "CHIP-8"}},
+		})
+	case "stackTrace":
+		return false, s.respond(req, s.stackTrace())
+	case "scopes":
+		return false, s.respond(req, map[string]interface{}{"scopes": []map[string]interface{}{
+			{"name": "Registers", "variablesReference": DAP_REGISTERS, "expensive": false},
+			{"name": "Stack", "variablesReference": DAP_STACK, "expensive": false},
+		}})
+	case "variables":
+		var args struct {
+			VariablesReference int `json:"variablesReference"`
+		}
+		json.Unmarshal(req.Arguments, &args)
+		return false, s.respond(req, map[string]interface{}{"variables": s.variables(args.VariablesReference)})
+	case "setVariable":
+		var args struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		}
+		json.Unmarshal(req.Arguments, &args)
+		value, err := s.setRegister(args.Name, args.Value)
+		if err != nil {
+			return false, s.fail(req, "%v", err)
+		}
+		return false, s.respond(req, map[string]interface{}{"value": value})
+	case "evaluate":
+		var args struct {
+			Expression string `json:"expression"`
+		}
+		json.Unmarshal(req.Arguments, &args)
+		for _, v := range s.variables(DAP_REGISTERS) {
+			if strings.EqualFold(v["name"].(string), strings.TrimSpace(args.Expression)) {
+				return false, s.respond(req, map[string]interface{}{"result": v["value"], "variablesReference": 0})
+			}
+		}
+		return false, s.fail(req, "only registers can be evaluated")
+	case "readMemory":
+		var args struct {
+			MemoryReference string `json:"memoryReference"`
+			Offset          int    `json:"offset"`
+			Count           int    `json:"count"`
+		}
+		json.Unmarshal(req.Arguments, &args)
+		addr, err := strconv.ParseInt(args.MemoryReference, 0, 32)
+		if err != nil {
+			return false, s.fail(req, "bad memory reference %q", args.MemoryReference)
+		}
+		if args.Count < 0 {
+			args.Count = 0
+		}
+		ram := s.m.RAM()
+		start := int(addr) + args.Offset
+		end := start + args.Count
+		if start < 0 || start > len(ram) {
+			start, end = len(ram), len(ram)
+		}
+		if end > len(ram) {
+			end = len(ram)
+		}
+		if end < start {
+			end = start
+		}
+		return false, s.respond(req, map[string]interface{}{
+			"address":         fmt.Sprintf("0x%03X", start),
+			"data":            base64.StdEncoding.EncodeToString(ram[start:end]),
+			"unreadableBytes": args.Count - (end - start),
+		})
+	case "writeMemory":
+		var args struct {
+			MemoryReference string `json:"memoryReference"`
+			Offset          int    `json:"offset"`
+			Data            string `json:"data"`
+		}
+		json.Unmarshal(req.Arguments, &args)
+		addr, err := strconv.ParseInt(args.MemoryReference, 0, 32)
+		if err != nil {
+			return false, s.fail(req, "bad memory reference %q", args.MemoryReference)
+		}
+		data, err := base64.StdEncoding.DecodeString(args.Data)
+		if err != nil {
+			return false, s.fail(req, "%v", err)
+		}
+		if err := s.m.Poke(int(addr)+args.Offset, data); err != nil {
+			return false, s.fail(req, "%v", err)
+		}
+		return false, s.respond(req, map[string]interface{}{"bytesWritten": len(data)})
+	case "continue":
+		s.done = nil
+		s.running = true
+		return false, s.respond(req, map[string]interface{}{"allThreadsContinued": true})
+	case "next":
+		s.step(func(start, state State) bool {
+			return len(state.Stack) < len(start.Stack) ||
+				len(state.Stack) == len(start.Stack) && s.leftLine(start, state)
+		})
+		return false, s.respond(req, nil)
+	case "stepIn":
+		s.step(func(start, state State) bool {
+			return len(state.Stack) != len(start.Stack) || s.leftLine(start, state)
+		})
+		return false, s.respond(req, nil)
+	case "stepOut":
+		s.step(func(start, state State) bool {
+			return len(state.Stack) < len(start.Stack) || len(start.Stack) == 0
+		})
+		return false, s.respond(req, nil)
+	case "pause":
+		if err := s.respond(req, nil); err != nil {
+			return false, err
+		}
+		if !s.running {
+			return false, nil
+		}
+		return false, s.stop("pause", "")
+	case "disconnect", "terminate":
+		if err := s.respond(req, nil); err != nil {
+			return false, err
+		}
+		if req.Command == "terminate" {
+			s.event("terminated", nil)
+		}
+		return true, nil
+	}
+	return false, s.fail(req, "unsupported request %q", req.Command)
+}
+
+// resolveBreakpoints moves the breakpoints to the addresses of the lines
+// asked for, and returns the breakpoints of every source for the editor.
+func (s *dapSession) resolveBreakpoints() map[string][]map[string]interface{} {
+	s.breakpoints = map[uint16]bool{}
+	resolved := map[string][]map[string]interface{}{}
+	for path, lines := range s.lines {
+		resolved[path] = []map[string]interface{}{}
+		for _, line := range lines {
+			b := map[string]interface{}{"verified": false, "line": line}
+			if s.source != nil && sameSource(path, s.source.Path) {
+				if addr, at, ok := s.source.Address(line); ok {
+					s.breakpoints[addr] = true
+					b["verified"], b["line"] = true, at
+					b["instructionReference"] = fmt.Sprintf("0x%03X", addr)
+				}
+			}
+			resolved[path] = append(resolved[path], b)
+		}
+	}
+	return resolved
+}
+
+// sameSource compares paths the way editors on case-insensitive systems
+// may send them.
+func sameSource(a, b string) bool {
+	return strings.EqualFold(strings.ReplaceAll(a, "\\", "/"), strings.ReplaceAll(b, "\\", "/"))
+}
+
+func (s *dapSession) stackTrace() map[string]interface{} {
+	state := s.m.State()
+	// The current instruction, then the call of every return address.
+	pcs := []uint16{state.PC}
+	for n := len(state.Stack) - 1; n >= 0; n-- {
+		pcs = append(pcs, state.Stack[n]-2)
+	}
+	ram := s.m.RAM()
+	frames := []map[string]interface{}{}
+	for n, pc := range pcs {
+		var op uint16
+		if int(pc)+1 < len(ram) {
+			op = uint16(ram[pc])<<8 | uint16(ram[pc+1])
+		}
+		name := fmt.Sprintf("%03X: %04X %s", pc, op, Disassemble(op, s.source))
+		if symbol := s.source.Symbol(pc); symbol != "" {
+			name = symbol + " " + name
+		}
+		frame := map[string]interface{}{
+			"id":                          n,
+			"name":                        name,
+			"line":                        0,
+			"column":                      0,
+			"instructionPointerReference": fmt.Sprintf("0x%03X", pc),
+		}
+		if line := s.source.Line(pc); line != 0 {
+			frame["line"], frame["column"] = line, 1
+			frame["source"] = map[string]interface{}{"path": s.source.Path}
+		}
+		frames = append(frames, frame)
+	}
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}
+}
+
+func (s *dapSession) variables(ref int) []map[string]interface{} {
+	state := s.m.State()
+	vars := []map[string]interface{}{}
+	add := func(name, value string, addr int) {
+		v := map[string]interface{}{"name": name, "value": value, "variablesReference": 0}
+		if addr >= 0 {
+			v["memoryReference"] = fmt.Sprintf("0x%03X", addr)
+		}
+		vars = append(vars, v)
+	}
+	switch ref {
+	case DAP_REGISTERS:
+		for n, v := range state.V {
+			add(fmt.Sprintf("v%X", n), fmt.Sprintf("0x%02X", v), -1)
+		}
+		add("i", fmt.Sprintf("0x%03X", state.I), int(state.I))
+		add("pc", fmt.Sprintf("0x%03X", state.PC), int(state.PC))
+		add("dt", fmt.Sprintf("0x%02X", state.DT), -1)
+		add("st", fmt.Sprintf("0x%02X", state.ST), -1)
+	case DAP_STACK:
+		for n := len(state.Stack) - 1; n >= 0; n-- {
+			add(strconv.Itoa(n), fmt.Sprintf("0x%03X", state.Stack[n]), int(state.Stack[n]))
+		}
+	}
+	return vars
+}
+
+// setRegister changes a register to a value in hex with 0x, or decimal.
+func (s *dapSession) setRegister(name, value string) (string, error) {
+	n, err := strconv.ParseUint(strings.TrimSpace(value), 0, 16)
+	if err != nil {
+		return "", fmt.Errorf("bad value %q", value)
+	}
+	state := s.m.State()
+	name = strings.ToLower(name)
+	switch {
+	case name == "i":
+		state.I = uint16(n)
+	case name == "pc":
+		state.PC = uint16(n)
+	case name == "dt" && n <= 0xFF:
+		state.DT = uint8(n)
+	case name == "st" && n <= 0xFF:
+		state.ST = uint8(n)
+	case len(name) == 2 && name[0] == 'v' && n <= 0xFF:
+		r, err := strconv.ParseUint(name[1:], 16, 4)
+		if err != nil {
+			return "", fmt.Errorf("no register %q", name)
+		}
+		state.V[r] = uint8(n)
+	default:
+		return "", fmt.Errorf("cannot set %s to %s", name, value)
+	}
+	if err := s.m.SetState(state); err != nil {
+		return "", err
+	}
+	for _, v := range s.variables(DAP_REGISTERS) {
+		if strings.EqualFold(v["name"].(string), name) {
+			return v["value"].(string), nil
+		}
+	}
+	return value, nil
+}
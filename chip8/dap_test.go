@@ -0,0 +1,240 @@
+package chip8
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/yukinarit/ebiten8/octo"
+)
+
+const dapSource = `: main
+	v0 := 0
+	loop
+		v0 += 1
+		count
+	again
+
+: count
+	v1 += 2
+	return
+`
+
+// dapClient talks to a DAPServer the way an editor does.
+type dapClient struct {
+	t      *testing.T
+	conn   net.Conn
+	r      *textproto.Reader
+	seq    int
+	events []map[string]interface{}
+}
+
+func dialDAP(t *testing.T) *dapClient {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	launch := func(args json.RawMessage) (Machine, *SourceMap, error) {
+		var a struct{ Program string }
+		json.Unmarshal(args, &a)
+		prog, err := octo.Compile(dapSource, 0xE00)
+		if err != nil {
+			return nil, nil, err
+		}
+		sys := NewSystem()
+		return sys, NewSourceMap(a.Program, dapSource, prog.Labels, prog.Lines), sys.LoadBytes(prog.Rom)
+	}
+	go NewDAPServer(launch).Serve(l)
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &dapClient{t: t, conn: conn, r: textproto.NewReader(bufio.NewReader(conn))}
+}
+
+func (c *dapClient) read() map[string]interface{} {
+	c.t.Helper()
+	header, err := c.r.ReadMIMEHeader()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	n, _ := strconv.Atoi(header.Get("Content-Length"))
+	body := make([]byte, n)
+	if _, err := io.ReadFull(c.r.R, body); err != nil {
+		c.t.Fatal(err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(body, &m); err != nil {
+		c.t.Fatalf("%v: %s", err, body)
+	}
+	return m
+}
+
+// request sends a request and returns the body of its response, keeping
+// the events that came first.
+func (c *dapClient) request(command string, args interface{}) map[string]interface{} {
+	c.t.Helper()
+	c.seq++
+	data, _ := json.Marshal(map[string]interface{}{"seq": c.seq, "type": "request", "command": command, "arguments": args})
+	fmt.Fprintf(c.conn, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	for {
+		m := c.read()
+		if m["type"] == "event" {
+			c.events = append(c.events, m)
+			continue
+		}
+		if m["request_seq"] != float64(c.seq) || m["success"] != true {
+			c.t.Fatalf("%s: %v", command, m)
+		}
+		body, _ := m["body"].(map[string]interface{})
+		return body
+	}
+}
+
+// event waits for an event and returns its body.
+func (c *dapClient) event(name string) map[string]interface{} {
+	c.t.Helper()
+	for {
+		var m map[string]interface{}
+		if len(c.events) > 0 {
+			m, c.events = c.events[0], c.events[1:]
+		} else {
+			m = c.read()
+		}
+		if m["event"] == name {
+			body, _ := m["body"].(map[string]interface{})
+			return body
+		}
+	}
+}
+
+// top returns the line and name of the innermost stack frame.
+func (c *dapClient) top() (int, string) {
+	c.t.Helper()
+	frames := c.request("stackTrace", map[string]interface{}{"threadId": DAP_THREAD})["stackFrames"].([]interface{})
+	frame := frames[0].(map[string]interface{})
+	return int(frame["line"].(float64)), frame["name"].(string)
+}
+
+func (c *dapClient) register(name string) string {
+	c.t.Helper()
+	for _, v := range c.request("variables", map[string]interface{}{"variablesReference": DAP_REGISTERS})["variables"].([]interface{}) {
+		if v := v.(map[string]interface{}); v["name"] == name {
+			return v["value"].(string)
+		}
+	}
+	c.t.Fatalf("no register %s", name)
+	return ""
+}
+
+func TestDAPSession(t *testing.T) {
+	c := dialDAP(t)
+	c.request("initialize", map[string]interface{}{"adapterID": "ebiten8"})
+	c.event("initialized")
+	c.request("launch", map[string]interface{}{"program": "/src/test.8o"})
+
+	// Line 3 has no code, so the breakpoint moves to line 4.
+	bps := c.request("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"path": "/src/test.8o"},
+		"breakpoints": []map[string]interface{}{{"line": 3}},
+	})["breakpoints"].([]interface{})
+	if bp := bps[0].(map[string]interface{}); bp["verified"] != true || bp["line"] != float64(4) {
+		t.Errorf("breakpoint %v", bp)
+	}
+	c.request("configurationDone", nil)
+	if reason := c.event("stopped")["reason"]; reason != "breakpoint" {
+		t.Errorf("stopped for %v", reason)
+	}
+	if line, name := c.top(); line != 4 || name != "main+2 202: 7001 ADD V0, 0x01" {
+		t.Errorf("stopped at line %d %s", line, name)
+	}
+
+	c.request("stepIn", map[string]interface{}{"threadId": DAP_THREAD})
+	c.event("stopped")
+	c.request("stepIn", map[string]interface{}{"threadId": DAP_THREAD})
+	c.event("stopped")
+	if line, _ := c.top(); line != 9 {
+		t.Errorf("stepped into line %d, want 9", line)
+	}
+	frames := c.request("stackTrace", map[string]interface{}{"threadId": DAP_THREAD})["stackFrames"].([]interface{})
+	if len(frames) != 2 || frames[1].(map[string]interface{})["line"] != float64(5) {
+		t.Errorf("frames %v", frames)
+	}
+	c.request("stepOut", map[string]interface{}{"threadId": DAP_THREAD})
+	c.event("stopped")
+	if line, _ := c.top(); line != 6 {
+		t.Errorf("stepped out to line %d, want 6", line)
+	}
+	if v1 := c.register("v1"); v1 != "0x02" {
+		t.Errorf("v1 %s", v1)
+	}
+
+	if value := c.request("setVariable", map[string]interface{}{"variablesReference": DAP_REGISTERS, "name": "v0", "value": "0x40"})["value"]; value != "0x40" {
+		t.Errorf("set v0 to %v", value)
+	}
+	c.request("continue", map[string]interface{}{"threadId": DAP_THREAD})
+	c.event("stopped")
+	c.request("next", map[string]interface{}{"threadId": DAP_THREAD})
+	c.event("stopped")
+	if line, _ := c.top(); line != 5 {
+		t.Errorf("next stopped at line %d, want 5", line)
+	}
+	if v0 := c.register("v0"); v0 != "0x41" {
+		t.Errorf("v0 %s", v0)
+	}
+	c.request("next", map[string]interface{}{"threadId": DAP_THREAD})
+	c.event("stopped")
+	if line, _ := c.top(); line != 6 {
+		t.Errorf("next over the call stopped at line %d, want 6", line)
+	}
+
+	memory := c.request("readMemory", map[string]interface{}{"memoryReference": "0x200", "count": 4})
+	if data, _ := base64.StdEncoding.DecodeString(memory["data"].(string)); fmt.Sprintf("%X", data) != "60007001" {
+		t.Errorf("memory %v", memory)
+	}
+	for _, tc := range []struct {
+		ref               string
+		count, unreadable int
+		data              string
+	}{
+		{"0xFFE", 4, 2, "0000"},
+		{"0x200", -4, 0, ""},
+		{"-0x10", 4, 4, ""},
+	} {
+		memory := c.request("readMemory", map[string]interface{}{"memoryReference": tc.ref, "count": tc.count})
+		data, _ := base64.StdEncoding.DecodeString(memory["data"].(string))
+		if fmt.Sprintf("%X", data) != tc.data || memory["unreadableBytes"] != float64(tc.unreadable) {
+			t.Errorf("reading %d bytes at %s: %v", tc.count, tc.ref, memory)
+		}
+	}
+	c.request("writeMemory", map[string]interface{}{"memoryReference": "0x200", "offset": 3, "data": base64.StdEncoding.EncodeToString([]byte{0x10})})
+
+	c.request("setBreakpoints", map[string]interface{}{"source": map[string]interface{}{"path": "/src/test.8o"}})
+	c.request("continue", map[string]interface{}{"threadId": DAP_THREAD})
+	c.request("pause", map[string]interface{}{"threadId": DAP_THREAD})
+	if reason := c.event("stopped")["reason"]; reason != "pause" {
+		t.Errorf("stopped for %v", reason)
+	}
+	// The pause may come before the patched ADD ran, so run up to the call
+	// after it.
+	c.request("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"path": "/src/test.8o"},
+		"breakpoints": []map[string]interface{}{{"line": 5}},
+	})
+	c.request("continue", map[string]interface{}{"threadId": DAP_THREAD})
+	c.event("stopped")
+	if v0 := c.register("v0"); v0 == "0x41" {
+		t.Errorf("v0 still 0x41 after running with ADD v0, 0x10")
+	}
+	c.request("disconnect", nil)
+}
@@ -0,0 +1,308 @@
+package chip8
+
+import "errors"
+
+// instruction executes a decoded instruction. It returns a *CpuError if the
+// instruction cannot execute, leaving the machine unchanged.
+type instruction func(cpu *Cpu, mem *Memory, vme *VideoMemory, kb *Keyboard) error
+
+// errWaiting is returned by Dxyn when it waits for the next frame.
+var errWaiting = errors.New("waiting for the next frame")
+
+// next makes an instruction which runs f and goes on with the following one.
+func next(f func(cpu *Cpu)) instruction {
+	return func(cpu *Cpu, mem *Memory, vme *VideoMemory, kb *Keyboard) error {
+		f(cpu)
+		cpu.pc += 2
+		return nil
+	}
+}
+
+// skipIf makes an instruction which skips the following one if cond holds.
+func skipIf(cond func(cpu *Cpu) bool) instruction {
+	return func(cpu *Cpu, mem *Memory, vme *VideoMemory, kb *Keyboard) error {
+		if cond(cpu) {
+			cpu.pc += 4
+		} else {
+			cpu.pc += 2
+		}
+		return nil
+	}
+}
+
+// pressed takes every queued key and reports whether key was among them.
+func pressed(kb *Keyboard, key uint16) bool {
+	found := false
+	for k := kb.Pop(); k != nil; k = kb.Pop() {
+		if *k == key {
+			found = true
+		}
+	}
+	return found
+}
+
+// decode turns an opcode into an instruction and its name. Only CHIP-8
+// opcodes decode, matched on every digit that is not an operand; any other,
+// such as the XO-CHIP and most SCHIP extensions, decodes to an instruction
+// which fails with ErrOpcode. Of the SCHIP, only the 00FE and 00FF switch to
+// the 128x64 mode and the 16x16 sprites of Dxy0 in it are supported. 0nnn,
+// including 0000, does nothing.
+func decode(op uint16) (instruction, string) {
+	x := op >> 8 & 0xF
+	y := op >> 4 & 0xF
+	n := op & 0xF
+	kk := uint8(op)
+	nnn := op & 0xFFF
+	invalid := func(cpu *Cpu, mem *Memory, vme *VideoMemory, kb *Keyboard) error {
+		return cpu.fail(op, ErrOpcode)
+	}
+	jump := func(addr func(cpu *Cpu) uint16) instruction {
+		return func(cpu *Cpu, mem *Memory, vme *VideoMemory, kb *Keyboard) error {
+			cpu.pc = addr(cpu)
+			return nil
+		}
+	}
+
+	switch op >> 12 {
+	case 0x0:
+		switch {
+		case op == 0x00E0:
+			return func(cpu *Cpu, mem *Memory, vme *VideoMemory, kb *Keyboard) error {
+				vme.clear()
+				cpu.pc += 2
+				return nil
+			}, "00E0 CLS"
+		case op == 0x00EE:
+			return func(cpu *Cpu, mem *Memory, vme *VideoMemory, kb *Keyboard) error {
+				if cpu.sp == 0 {
+					return cpu.fail(op, ErrStackUnderflow)
+				}
+				cpu.sp -= 1
+				cpu.pc = cpu.stack[cpu.sp] + 2
+				return nil
+			}, "00EE RET"
+		case op == 0x00FE:
+			return func(cpu *Cpu, mem *Memory, vme *VideoMemory, kb *Keyboard) error {
+				vme.setHires(false)
+				cpu.pc += 2
+				return nil
+			}, "00FE LOW"
+		case op == 0x00FF:
+			return func(cpu *Cpu, mem *Memory, vme *VideoMemory, kb *Keyboard) error {
+				vme.setHires(true)
+				cpu.pc += 2
+				return nil
+			}, "00FF HIGH"
+		default:
+			// Modern interpreters ignore calls to machine code.
+			return next(func(cpu *Cpu) {}), "0nnn SYS"
+		}
+	case 0x1:
+		return jump(func(cpu *Cpu) uint16 { return nnn }), "1nnn JP"
+	case 0x2:
+		return func(cpu *Cpu, mem *Memory, vme *VideoMemory, kb *Keyboard) error {
+			if int(cpu.sp) >= len(cpu.stack) {
+				return cpu.fail(op, ErrStackOverflow)
+			}
+			cpu.stack[cpu.sp] = cpu.pc
+			cpu.sp += 1
+			cpu.pc = nnn
+			return nil
+		}, "2nnn CALL"
+	case 0x3:
+		return skipIf(func(cpu *Cpu) bool { return cpu.v[x] == kk }), "3xkk SE"
+	case 0x4:
+		return skipIf(func(cpu *Cpu) bool { return cpu.v[x] != kk }), "4xkk SNE"
+	case 0x5:
+		if n == 0 {
+			return skipIf(func(cpu *Cpu) bool { return cpu.v[x] == cpu.v[y] }), "5xy0 SE"
+		}
+	case 0x6:
+		return next(func(cpu *Cpu) { cpu.v[x] = kk }), "6xkk LD"
+	case 0x7:
+		return next(func(cpu *Cpu) { cpu.v[x] += kk }), "7xkk ADD"
+	case 0x8:
+		// The flag is written after vx, so that it wins when x is F.
+		switch n {
+		case 0x0:
+			return next(func(cpu *Cpu) { cpu.v[x] = cpu.v[y] }), "8xy0 LD"
+		case 0x1:
+			return next(func(cpu *Cpu) {
+				cpu.v[x] |= cpu.v[y]
+				if cpu.Quirks.Logic {
+					cpu.v[0xF] = 0
+				}
+			}), "8xy1 OR"
+		case 0x2:
+			return next(func(cpu *Cpu) {
+				cpu.v[x] &= cpu.v[y]
+				if cpu.Quirks.Logic {
+					cpu.v[0xF] = 0
+				}
+			}), "8xy2 AND"
+		case 0x3:
+			return next(func(cpu *Cpu) {
+				cpu.v[x] ^= cpu.v[y]
+				if cpu.Quirks.Logic {
+					cpu.v[0xF] = 0
+				}
+			}), "8xy3 XOR"
+		case 0x4:
+			return next(func(cpu *Cpu) {
+				sum := uint16(cpu.v[x]) + uint16(cpu.v[y])
+				cpu.v[x] = uint8(sum)
+				cpu.v[0xF] = vf(sum > 0xFF)
+			}), "8xy4 ADD"
+		case 0x5:
+			return next(func(cpu *Cpu) {
+				vx, vy := cpu.v[x], cpu.v[y]
+				cpu.v[x] = vx - vy
+				cpu.v[0xF] = vf(vx >= vy)
+			}), "8xy5 SUB"
+		case 0x6:
+			return next(func(cpu *Cpu) {
+				src := cpu.v[x]
+				if !cpu.Quirks.Shift {
+					src = cpu.v[y]
+				}
+				cpu.v[x] = src >> 1
+				cpu.v[0xF] = src & 0x1
+			}), "8xy6 SHR"
+		case 0x7:
+			return next(func(cpu *Cpu) {
+				vx, vy := cpu.v[x], cpu.v[y]
+				cpu.v[x] = vy - vx
+				cpu.v[0xF] = vf(vy >= vx)
+			}), "8xy7 SUBN"
+		case 0xE:
+			return next(func(cpu *Cpu) {
+				src := cpu.v[x]
+				if !cpu.Quirks.Shift {
+					src = cpu.v[y]
+				}
+				cpu.v[x] = src << 1
+				cpu.v[0xF] = src >> 7
+			}), "8xyE SHL"
+		}
+	case 0x9:
+		if n == 0 {
+			return skipIf(func(cpu *Cpu) bool { return cpu.v[x] != cpu.v[y] }), "9xy0 SNE"
+		}
+	case 0xA:
+		return next(func(cpu *Cpu) { cpu.i = nnn }), "Annn LD I"
+	case 0xB:
+		return jump(func(cpu *Cpu) uint16 {
+			if cpu.Quirks.Jump {
+				return nnn + uint16(cpu.v[x])
+			}
+			return nnn + uint16(cpu.v[0])
+		}), "Bnnn JP V0"
+	case 0xC:
+		return next(func(cpu *Cpu) { cpu.v[x] = cpu.rand() & kk }), "Cxkk RND"
+	case 0xD:
+		return func(cpu *Cpu, mem *Memory, vme *VideoMemory, kb *Keyboard) error {
+			if cpu.Quirks.VBlank && !cpu.vblank {
+				cpu.waiting = true
+				return errWaiting
+			}
+			// In hires mode Dxy0 draws a 16x16 sprite, as on the SCHIP.
+			size, width := int(n), 8
+			if n == 0 && vme.hires {
+				size, width = 32, 16
+			}
+			if !mem.inRange(cpu.i, size) {
+				return cpu.fail(op, ErrMemory)
+			}
+			// The log only holds sprites 8 pixels wide.
+			if cpu.Sprites != nil && width == 8 {
+				cpu.Sprites.draw(cpu.i, size)
+			}
+			sprite := mem.buf[int(cpu.i) : int(cpu.i)+size]
+			cpu.v[0xF] = vme.draw(uint16(cpu.v[x]), uint16(cpu.v[y]), sprite, width, cpu.Quirks.Clip)
+			cpu.pc += 2
+			return nil
+		}, "Dxyn DRW"
+	case 0xE:
+		switch kk {
+		case 0x9E:
+			return func(cpu *Cpu, mem *Memory, vme *VideoMemory, kb *Keyboard) error {
+				if pressed(kb, uint16(cpu.v[x])) {
+					cpu.pc += 4
+				} else {
+					cpu.pc += 2
+				}
+				return nil
+			}, "Ex9E SKP"
+		case 0xA1:
+			return func(cpu *Cpu, mem *Memory, vme *VideoMemory, kb *Keyboard) error {
+				if pressed(kb, uint16(cpu.v[x])) {
+					cpu.pc += 2
+				} else {
+					cpu.pc += 4
+				}
+				return nil
+			}, "ExA1 SKNP"
+		}
+	case 0xF:
+		switch kk {
+		case 0x07:
+			return next(func(cpu *Cpu) { cpu.v[x] = uint8(cpu.dt) }), "Fx07 LD Vx, DT"
+		case 0x0A:
+			return func(cpu *Cpu, mem *Memory, vme *VideoMemory, kb *Keyboard) error {
+				// Without a key the instruction executes again.
+				if key := kb.Pop(); key != nil {
+					cpu.v[x] = uint8(*key)
+					cpu.pc += 2
+				}
+				return nil
+			}, "Fx0A LD Vx, K"
+		case 0x15:
+			return next(func(cpu *Cpu) { cpu.dt = uint16(cpu.v[x]) }), "Fx15 LD DT"
+		case 0x18:
+			return next(func(cpu *Cpu) { cpu.st = uint16(cpu.v[x]) }), "Fx18 LD ST"
+		case 0x1E:
+			return next(func(cpu *Cpu) { cpu.i += uint16(cpu.v[x]) }), "Fx1E ADD I"
+		case 0x29:
+			return next(func(cpu *Cpu) { cpu.i = uint16(cpu.v[x]&0xF) * 5 }), "Fx29 LD F"
+		case 0x33:
+			return func(cpu *Cpu, mem *Memory, vme *VideoMemory, kb *Keyboard) error {
+				if !mem.inRange(cpu.i, 3) {
+					return cpu.fail(op, ErrMemory)
+				}
+				vx := cpu.v[x]
+				mem.store(cpu.i, vx/100%10)
+				mem.store(cpu.i+1, vx/10%10)
+				mem.store(cpu.i+2, vx%10)
+				cpu.pc += 2
+				return nil
+			}, "Fx33 LD B"
+		case 0x55:
+			return func(cpu *Cpu, mem *Memory, vme *VideoMemory, kb *Keyboard) error {
+				if !mem.inRange(cpu.i, int(x)+1) {
+					return cpu.fail(op, ErrMemory)
+				}
+				for r := uint16(0); r <= x; r++ {
+					mem.store(cpu.i+r, cpu.v[r])
+				}
+				if !cpu.Quirks.LoadStore {
+					cpu.i += x + 1
+				}
+				cpu.pc += 2
+				return nil
+			}, "Fx55 LD [I]"
+		case 0x65:
+			return func(cpu *Cpu, mem *Memory, vme *VideoMemory, kb *Keyboard) error {
+				if !mem.inRange(cpu.i, int(x)+1) {
+					return cpu.fail(op, ErrMemory)
+				}
+				copy(cpu.v[:x+1], mem.buf[cpu.i:])
+				if !cpu.Quirks.LoadStore {
+					cpu.i += x + 1
+				}
+				cpu.pc += 2
+				return nil
+			}, "Fx65 LD Vx, [I]"
+		}
+	}
+	return invalid, "invalid"
+}
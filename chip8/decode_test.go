@@ -0,0 +1,122 @@
+package chip8
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// selfModifying patches the instruction at 0x200 from 7301 to 7305 with
+// Fx55 after executing it once, and loops until v3 is no longer 1.
+var selfModifying = []byte{
+	0x73, 0x01, // 200 ADD v3, 1
+	0x43, 0x01, // 202 SNE v3, 1
+	0x12, 0x0A, // 204 JP 20A
+	0x12, 0x06, // 206 JP 206
+	0x00, 0x00, // 208
+	0x60, 0x73, // 20A LD v0, 73
+	0x61, 0x05, // 20C LD v1, 05
+	0xA2, 0x00, // 20E LD I, 200
+	0xF1, 0x55, // 210 LD [I], v1
+	0x12, 0x00, // 212 JP 200
+}
+
+// TestSelfModifyingCode executes an instruction again after the program
+// wrote over it.
+func TestSelfModifyingCode(t *testing.T) {
+	sys := NewSystem()
+	sys.LoadBytes(selfModifying)
+	for n := 0; n < 20; n++ {
+		if err := sys.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := sys.Cpu.v[3]; got != 6 {
+		t.Errorf("v3 is %d, want 6 from 7301 and then 7305", got)
+	}
+	if sys.Cpu.pc != 0x206 {
+		t.Errorf("pc is %03X, want 206", sys.Cpu.pc)
+	}
+}
+
+// TestDecodeCache drops only the instructions a write overlaps.
+func TestDecodeCache(t *testing.T) {
+	mem := NewMemory()
+	for addr := uint16(0x200); addr < 0x208; addr++ {
+		mem.code[addr], _ = decode(0x6000)
+	}
+	mem.store(0x204, 0)
+	for addr := uint16(0x200); addr < 0x208; addr++ {
+		if dropped := mem.code[addr] == nil; dropped != (addr == 0x203 || addr == 0x204) {
+			t.Errorf("instruction at %03X dropped: %v", addr, dropped)
+		}
+	}
+	mem.LoadBytes(nil)
+	if mem.code[0x200] != nil {
+		t.Errorf("loading a program kept the decoded instructions")
+	}
+}
+
+// reportSpeed adds instructions per second to a benchmark.
+func reportSpeed(b *testing.B, start time.Time, instructions int) {
+	b.ReportMetric(float64(instructions)/time.Since(start).Seconds(), "instr/s")
+}
+
+// BenchmarkTick runs a loop of arithmetic and skips.
+func BenchmarkTick(b *testing.B) {
+	sys := NewSystem()
+	sys.LoadBytes([]byte{
+		0x70, 0x01, // 200 ADD v0, 1
+		0x81, 0x04, // 202 ADD v1, v0
+		0x30, 0x00, // 204 SE v0, 0
+		0x12, 0x00, // 206 JP 200
+		0x72, 0x01, // 208 ADD v2, 1
+		0x12, 0x00, // 20A JP 200
+	})
+	b.ResetTimer()
+	start := time.Now()
+	for n := 0; n < b.N; n++ {
+		sys.Cpu.Tick(sys.Mem, sys.Vme, sys.Kb)
+	}
+	reportSpeed(b, start, b.N)
+}
+
+// BenchmarkSelfModifying rewrites and decodes an instruction every 4.
+func BenchmarkSelfModifying(b *testing.B) {
+	sys := NewSystem()
+	sys.LoadBytes([]byte{
+		0x73, 0x01, // 200 ADD v3, 1
+		0xA2, 0x00, // 202 LD I, 200
+		0xF1, 0x55, // 204 LD [I], v1
+		0x12, 0x00, // 206 JP 200
+	})
+	sys.Cpu.v[0], sys.Cpu.v[1] = 0x73, 0x01
+	b.ResetTimer()
+	start := time.Now()
+	for n := 0; n < b.N; n++ {
+		sys.Cpu.Tick(sys.Mem, sys.Vme, sys.Kb)
+	}
+	reportSpeed(b, start, b.N)
+}
+
+// BenchmarkRunFrame runs a game headless, a frame of 1000 instructions at a
+// time.
+func BenchmarkRunFrame(b *testing.B) {
+	rom, err := ioutil.ReadFile(filepath.Join("..", "roms", "Tetris [Fran Dachille, 1991].ch8"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	sys := NewSystem()
+	sys.Cpu.Seed(1)
+	sys.Tickrate = 1000
+	sys.LoadBytes(rom)
+	b.ResetTimer()
+	start := time.Now()
+	for n := 0; n < b.N; n++ {
+		if err := sys.RunFrame(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	reportSpeed(b, start, b.N*sys.Tickrate)
+}
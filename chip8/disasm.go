@@ -0,0 +1,115 @@
+package chip8
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// disasmFormats spells out the operands of each instruction, by the pattern
+// decode names it with.
+var disasmFormats = map[string]string{
+	"00E0": "CLS",
+	"00EE": "RET",
+	"00FE": "LOW",
+	"00FF": "HIGH",
+	"0nnn": "SYS nnn",
+	"1nnn": "JP nnn",
+	"2nnn": "CALL nnn",
+	"3xkk": "SE Vx, kk",
+	"4xkk": "SNE Vx, kk",
+	"5xy0": "SE Vx, Vy",
+	"6xkk": "LD Vx, kk",
+	"7xkk": "ADD Vx, kk",
+	"8xy0": "LD Vx, Vy",
+	"8xy1": "OR Vx, Vy",
+	"8xy2": "AND Vx, Vy",
+	"8xy3": "XOR Vx, Vy",
+	"8xy4": "ADD Vx, Vy",
+	"8xy5": "SUB Vx, Vy",
+	"8xy6": "SHR Vx, Vy",
+	"8xy7": "SUBN Vx, Vy",
+	"8xyE": "SHL Vx, Vy",
+	"9xy0": "SNE Vx, Vy",
+	"Annn": "LD I, nnn",
+	"Bnnn": "JP V0, nnn",
+	"Cxkk": "RND Vx, kk",
+	"Dxyn": "DRW Vx, Vy, n",
+	"Ex9E": "SKP Vx",
+	"ExA1": "SKNP Vx",
+	"Fx07": "LD Vx, DT",
+	"Fx0A": "LD Vx, K",
+	"Fx15": "LD DT, Vx",
+	"Fx18": "LD ST, Vx",
+	"Fx1E": "ADD I, Vx",
+	"Fx29": "LD F, Vx",
+	"Fx33": "LD B, Vx",
+	"Fx55": "LD [I], Vx",
+	"Fx65": "LD Vx, [I]",
+}
+
+// Disassemble spells out an instruction with its operands, such as
+// "LD V0, 0x05". Addresses with a label in symbols, which may be nil, are
+// named after it. Opcodes decode fails on are shown as data words.
+func Disassemble(op uint16, symbols *SourceMap) string {
+	_, name := decode(op)
+	format, ok := disasmFormats[strings.SplitN(name, " ", 2)[0]]
+	if !ok {
+		return fmt.Sprintf("DW 0x%04X", op)
+	}
+	nnn := op & 0x0FFF
+	operand := func(s string) string {
+		switch s {
+		case "Vx":
+			return fmt.Sprintf("V%X", op>>8&0xF)
+		case "Vy":
+			return fmt.Sprintf("V%X", op>>4&0xF)
+		case "kk":
+			return fmt.Sprintf("0x%02X", op&0xFF)
+		case "n":
+			return fmt.Sprint(op & 0xF)
+		case "nnn":
+			if label := symbols.Label(nnn); label != "" {
+				return label
+			}
+			return fmt.Sprintf("0x%03X", nnn)
+		}
+		return s
+	}
+	parts := strings.SplitN(format, " ", 2)
+	if len(parts) == 1 {
+		return format
+	}
+	operands := strings.Split(parts[1], ", ")
+	for n, s := range operands {
+		operands[n] = operand(s)
+	}
+	return parts[0] + " " + strings.Join(operands, ", ")
+}
+
+// WriteDisassembly lists the instructions of ram from start up to end, two
+// bytes at a time. With symbols, which may be nil, labels head the
+// instructions they point to and source lines follow them.
+func WriteDisassembly(w io.Writer, ram []byte, start, end int, symbols *SourceMap) error {
+	b := bufio.NewWriter(w)
+	if end > len(ram) {
+		end = len(ram)
+	}
+	for addr := start; addr+1 < end; addr += 2 {
+		pc := uint16(addr)
+		if label := symbols.Label(pc); label != "" {
+			fmt.Fprintf(b, "%s:\n", label)
+		}
+		op := uint16(ram[addr])<<8 | uint16(ram[addr+1])
+		line := fmt.Sprintf("%03X: %04X  %s", addr, op, Disassemble(op, symbols))
+		if n, text := symbols.Source(pc); n != 0 {
+			line = fmt.Sprintf("%-32s; %d: %s", line, n, text)
+			if comment := symbols.Comment(pc); comment != "" {
+				line += " # " + comment
+			}
+		}
+		fmt.Fprintln(b, strings.TrimRight(line, " "))
+	}
+	return b.Flush()
+}
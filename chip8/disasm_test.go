@@ -0,0 +1,57 @@
+package chip8
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	tests := []struct {
+		op   uint16
+		want string
+	}{
+		{0x00E0, "CLS"},
+		{0x00EE, "RET"},
+		{0x1208, "JP 0x208"},
+		{0x3A7F, "SE VA, 0x7F"},
+		{0x8126, "SHR V1, V2"},
+		{0xB300, "JP V0, 0x300"},
+		{0xD015, "DRW V0, V1, 5"},
+		{0xF30A, "LD V3, K"},
+		{0xF455, "LD [I], V4"},
+		{0xF765, "LD V7, [I]"},
+		{0xFFFF, "DW 0xFFFF"},
+		{0xF130, "DW 0xF130"},
+		{0xE19F, "DW 0xE19F"},
+		{0x5121, "DW 0x5121"},
+		{0x0000, "SYS 0x000"},
+	}
+	for _, test := range tests {
+		if got := Disassemble(test.op, nil); got != test.want {
+			t.Errorf("%04X: got %q, want %q", test.op, got, test.want)
+		}
+	}
+}
+
+func TestWriteDisassembly(t *testing.T) {
+	m := symbolMap(t)
+	ram := []byte{0x60, 0x00, 0x70, 0x01, 0x22, 0x08, 0x12, 0x02, 0x71, 0x02, 0x00, 0xEE}
+	var buf bytes.Buffer
+	if err := WriteDisassembly(&buf, append(make([]byte, 0x200), ram...), 0x200, 0x20C, m); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"main:",
+		"200: 6000  LD V0, 0x00          ; 2: v0 := 0 # Count from zero.",
+		"202: 7001  ADD V0, 0x01         ; 4: v0 += 1",
+		"204: 2208  CALL count           ; 5: count",
+		"206: 1202  JP 0x202             ; 6: again",
+		"count:",
+		"208: 7102  ADD V1, 0x02         ; 9: v1 += 2",
+		"20A: 00EE  RET                  ; 10: return",
+	}
+	if got := strings.TrimSpace(buf.String()); got != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", got, strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,158 @@
+package chip8
+
+import (
+	"fmt"
+	"image/color"
+	"strings"
+)
+
+// DisplayMode selects how frames are post-processed to reduce flicker.
+type DisplayMode int
+
+const (
+	DISPLAY_NONE   DisplayMode = iota // Show every frame as is.
+	DISPLAY_BLEND                     // OR the last Frames frames together.
+	DISPLAY_DECAY                     // Fade pixels out like phosphor.
+	DISPLAY_VBLANK                    // Skip frames that only erased pixels.
+)
+
+var displayModeNames = []string{"none", "blend", "decay", "vblank"}
+
+func (m DisplayMode) String() string {
+	if int(m) < len(displayModeNames) {
+		return displayModeNames[m]
+	}
+	return fmt.Sprintf("DisplayMode(%d)", int(m))
+}
+
+func ParseDisplayMode(name string) (DisplayMode, error) {
+	for n, s := range displayModeNames {
+		if strings.EqualFold(s, name) {
+			return DisplayMode(n), nil
+		}
+	}
+	return DISPLAY_NONE, fmt.Errorf("unknown display mode %q", name)
+}
+
+// The mode following m, wrapping around.
+func (m DisplayMode) Next() DisplayMode {
+	return DisplayMode((int(m) + 1) % len(displayModeNames))
+}
+
+// Display latches the framebuffer at every vertical blank and post-processes
+// it for presentation.
+//
+// CHIP-8 programs move sprites by erasing and redrawing them, so a frame can
+// end with a sprite missing. BLEND keeps pixels lit for a few frames, DECAY
+// fades them out exponentially, and VBLANK holds the previous image over a
+// frame whose sprite draws all collided, i.e. erased something without
+// redrawing it.
+type Display struct {
+	Mode   DisplayMode
+	Frames int     // Frames combined in BLEND mode.
+	Decay  float64 // Intensity kept per frame in DECAY mode, between 0 and 1.
+
+	out     screenBuf
+	hires   bool // The resolution of out.
+	history []screenBuf
+	next    int
+	level   [HIRES_H_PIXELS][HIRES_V_PIXELS]float64
+}
+
+func NewDisplay() *Display {
+	return &Display{Mode: DISPLAY_NONE, Frames: 3, Decay: 0.6}
+}
+
+// VBlank latches the framebuffer at the end of a frame.
+func (d *Display) VBlank(vme *VideoMemory) {
+	drawn, erased := vme.drawn, vme.erased
+	vme.drawn, vme.erased = false, false
+	if vme.hires != d.hires {
+		// Frames of the other resolution do not mix with this one.
+		d.hires = vme.hires
+		d.history = nil
+		d.level = [HIRES_H_PIXELS][HIRES_V_PIXELS]float64{}
+		d.out = vme.buf
+	}
+	w, h := vme.Size()
+
+	switch d.Mode {
+	case DISPLAY_BLEND:
+		frames := d.Frames
+		if frames < 1 {
+			frames = 1
+		}
+		if len(d.history) != frames {
+			d.history = make([]screenBuf, frames)
+			d.next = 0
+		}
+		d.history[d.next] = vme.buf
+		d.next = (d.next + 1) % frames
+		for x := 0; x < w; x++ {
+			for y := 0; y < h; y++ {
+				v := byte(0)
+				for n := range d.history {
+					v |= d.history[n][x][y]
+				}
+				d.out[x][y] = v
+			}
+		}
+	case DISPLAY_DECAY:
+		d.out = vme.buf
+		for x := 0; x < w; x++ {
+			for y := 0; y < h; y++ {
+				if vme.buf[x][y] != 0 {
+					d.level[x][y] = 1
+				} else {
+					d.level[x][y] *= d.Decay
+				}
+			}
+		}
+	case DISPLAY_VBLANK:
+		if drawn || !erased {
+			d.out = vme.buf
+		}
+	default:
+		d.out = vme.buf
+	}
+}
+
+// Size of the latched image, which follows the display at every vblank.
+func (d *Display) Size() (int, int) {
+	if d.hires {
+		return HIRES_H_PIXELS, HIRES_V_PIXELS
+	}
+	return H_PIXELS, V_PIXELS
+}
+
+// Render converts the latched image into RGBA pixels like VideoMemory.Render,
+// for the Size of the Display.
+func (d *Display) Render(pix []byte, colors []color.RGBA) {
+	w, h := d.Size()
+	if d.Mode != DISPLAY_DECAY {
+		render(&d.out, w, h, pix, colors)
+		return
+	}
+
+	bg, fg := colors[0], colors[1]
+	for y := 0; y < h; y++ {
+		row := pix[4*y*w:]
+		for x := 0; x < w; x++ {
+			c := colors[d.out[x][y]]
+			if d.out[x][y] == 0 {
+				c = lerp(bg, fg, d.level[x][y])
+			}
+			row[4*x] = c.R
+			row[4*x+1] = c.G
+			row[4*x+2] = c.B
+			row[4*x+3] = c.A
+		}
+	}
+}
+
+func lerp(a, b color.RGBA, t float64) color.RGBA {
+	mix := func(a, b uint8) uint8 {
+		return uint8(float64(a) + (float64(b)-float64(a))*t)
+	}
+	return color.RGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), mix(a.A, b.A)}
+}
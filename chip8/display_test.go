@@ -0,0 +1,71 @@
+package chip8
+
+import (
+	"image/color"
+	"testing"
+)
+
+var (
+	testBlack = color.RGBA{0, 0, 0, 0xFF}
+	testWhite = color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+)
+
+// renderPixel renders the display and returns the color of one pixel.
+func renderPixel(d *Display, x, y int) color.RGBA {
+	pix := make([]byte, 4*H_PIXELS*V_PIXELS)
+	d.Render(pix, []color.RGBA{testBlack, testWhite})
+	p := pix[4*(y*H_PIXELS+x):]
+	return color.RGBA{p[0], p[1], p[2], p[3]}
+}
+
+// TestDisplayModes toggles a pixel at 2,3 and checks the color it is shown
+// in after each frame. A frame lists whether the pixel is XORed that frame.
+func TestDisplayModes(t *testing.T) {
+	gray := func(v uint8) color.RGBA { return color.RGBA{v, v, v, 0xFF} }
+	for _, tc := range []struct {
+		name   string
+		mode   DisplayMode
+		toggle []bool
+		want   []color.RGBA
+	}{
+		{"none", DISPLAY_NONE, []bool{true, true, false, true}, []color.RGBA{testWhite, testBlack, testBlack, testWhite}},
+		{"blend", DISPLAY_BLEND, []bool{true, true, false, false, false}, []color.RGBA{testWhite, testWhite, testWhite, testBlack, testBlack}},
+		{"decay", DISPLAY_DECAY, []bool{true, true, false, false, true}, []color.RGBA{testWhite, gray(0x7F), gray(0x3F), gray(0x1F), testWhite}},
+		{"vblank holds erased frames", DISPLAY_VBLANK, []bool{true, true, false, true, true}, []color.RGBA{testWhite, testWhite, testBlack, testWhite, testWhite}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			vme, d := NewVideoMemory(), NewDisplay()
+			d.Mode, d.Frames, d.Decay = tc.mode, 3, 0.5
+			for frame, toggle := range tc.toggle {
+				if toggle {
+					vme.draw(2, 3, []byte{0x80}, 8, true)
+				}
+				d.VBlank(vme)
+				if c := renderPixel(d, 2, 3); c != tc.want[frame] {
+					t.Errorf("frame %d: pixel = %v, want %v", frame, c, tc.want[frame])
+				}
+				if c := renderPixel(d, 3, 3); c != testBlack {
+					t.Errorf("frame %d: unlit pixel = %v", frame, c)
+				}
+			}
+		})
+	}
+}
+
+func TestDisplayModeNames(t *testing.T) {
+	for m := DISPLAY_NONE; m <= DISPLAY_VBLANK; m++ {
+		parsed, err := ParseDisplayMode(m.String())
+		if err != nil || parsed != m {
+			t.Errorf("%v parsed as %v, %v", m, parsed, err)
+		}
+	}
+	if m, err := ParseDisplayMode("Blend"); err != nil || m != DISPLAY_BLEND {
+		t.Errorf("Blend parsed as %v, %v", m, err)
+	}
+	if _, err := ParseDisplayMode("crt"); err == nil {
+		t.Error("parsed an unknown mode")
+	}
+	if DISPLAY_VBLANK.Next() != DISPLAY_NONE {
+		t.Errorf("%v follows vblank", DISPLAY_VBLANK.Next())
+	}
+}
@@ -0,0 +1,34 @@
+package chip8
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Reasons an instruction cannot execute.
+var (
+	ErrStackOverflow  = errors.New("stack overflow")
+	ErrStackUnderflow = errors.New("return with an empty stack")
+	ErrMemory         = errors.New("memory access out of range")
+	ErrOpcode         = errors.New("invalid opcode")
+)
+
+// ErrStackChange is returned by Machine.SetState for a stack the machine
+// cannot take.
+var ErrStackChange = errors.New("the stack can only be cut short")
+
+// CpuError is returned by Cpu.Tick when an instruction cannot execute. The
+// machine is left as it was before the instruction.
+type CpuError struct {
+	PC     uint16
+	Opcode uint16
+	Err    error
+}
+
+func (e *CpuError) Error() string {
+	return fmt.Sprintf("%03X: %04X: %v", e.PC, e.Opcode, e.Err)
+}
+
+func (e *CpuError) Unwrap() error {
+	return e.Err
+}
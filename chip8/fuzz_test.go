@@ -0,0 +1,87 @@
+package chip8
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+const FUZZ_TICKS = 1000
+
+// fuzzSystem builds a machine from a program and an initial CPU state. The
+// state is read as v0-vF, i, pc, sp, dt, st, the stack and the quirks; any
+// missing bytes are zero.
+func fuzzSystem(rom, state []byte) *System {
+	sys := NewSystem()
+	sys.Cpu.Seed(1)
+	sys.Mem.LoadBytes(rom)
+
+	s := make([]byte, 16+2+2+1+1+1+2*len(sys.Cpu.stack)+1)
+	copy(s, state)
+	cpu := sys.Cpu
+	copy(cpu.v[:16], s)
+	s = s[16:]
+	cpu.i = binary.BigEndian.Uint16(s)
+	if pc := binary.BigEndian.Uint16(s[2:]); pc != 0 {
+		cpu.pc = pc
+	}
+	cpu.sp = uint16(s[4]) % uint16(len(cpu.stack)+1)
+	cpu.dt, cpu.st = uint16(s[5]), uint16(s[6])
+	s = s[7:]
+	for n := range cpu.stack {
+		cpu.stack[n] = binary.BigEndian.Uint16(s[2*n:])
+	}
+	q := s[2*len(cpu.stack)]
+	cpu.Quirks = Quirks{Shift: q&1 != 0, LoadStore: q&2 != 0, Jump: q&4 != 0, Logic: q&8 != 0, Clip: q&16 != 0, VBlank: q&32 != 0}
+	return sys
+}
+
+// checkTicks runs a machine and fails if it panics or returns an error that
+// is not a *CpuError.
+func checkTicks(t *testing.T, sys *System) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("panic at pc %#03x: %v", sys.Cpu.pc, r)
+		}
+	}()
+	for n := 0; n < FUZZ_TICKS; n++ {
+		if n%TICKRATE == 0 {
+			sys.Kb.Push(uint16(n / TICKRATE % 16))
+			sys.Cpu.TickTimers(nil)
+		}
+		err := sys.Cpu.Tick(sys.Mem, sys.Vme, sys.Kb)
+		if err == nil {
+			continue
+		}
+		var cpuErr *CpuError
+		if !errors.As(err, &cpuErr) {
+			t.Fatalf("untyped error %T: %v", err, err)
+		}
+		return
+	}
+}
+
+// FuzzTick executes random programs from random initial states.
+func FuzzTick(f *testing.F) {
+	f.Add([]byte{0x00, 0xE0, 0xA2, 0x0A, 0x60, 0x0C, 0x61, 0x08, 0xD0, 0x15, 0x12, 0x08}, []byte{})
+	f.Add([]byte{0x22, 0x00}, []byte{})
+	f.Add([]byte{0x00, 0xEE}, []byte{})
+	f.Add([]byte{0xF0, 0x1E, 0xF5, 0x65, 0x12, 0x00}, []byte{0: 0xFF})
+	f.Add([]byte{0xDF, 0xFF}, []byte{0xF: 0xFF, 16: 0x0F, 17: 0xFA})
+	f.Fuzz(func(t *testing.T, rom, state []byte) {
+		checkTicks(t, fuzzSystem(rom, state))
+	})
+}
+
+// FuzzMemory executes from random memory contents, so that the program
+// counter, the stack and i can reach every address.
+func FuzzMemory(f *testing.F) {
+	f.Add([]byte{0x1F, 0xFE}, uint16(0xFFE))
+	f.Add([]byte{0xAF, 0xFF, 0xF0, 0x55}, uint16(0x200))
+	f.Fuzz(func(t *testing.T, data []byte, pc uint16) {
+		sys := fuzzSystem(nil, nil)
+		copy(sys.Mem.buf[:], data)
+		sys.Cpu.pc = pc
+		checkTicks(t, sys)
+	})
+}
@@ -0,0 +1,429 @@
+package chip8
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Registers as GDB numbers them: v0 to vf, then i, pc, sp, dt and st.
+const (
+	GDB_REG_I  = 16
+	GDB_REG_PC = 17
+	GDB_REG_SP = 18
+	GDB_REG_DT = 19
+	GDB_REG_ST = 20
+	GDB_REGS   = 21
+)
+
+// Signals of the stop replies.
+const (
+	GDB_SIGINT  = 2
+	GDB_SIGILL  = 4
+	GDB_SIGTRAP = 5
+	GDB_SIGSEGV = 11
+)
+
+// GDB_CHECK_STEPS is how many instructions continue runs between looking
+// for an interrupt from the debugger.
+const GDB_CHECK_STEPS = 1000
+
+// gdbTarget describes the registers to GDB, which has no CHIP-8
+// architecture of its own.
+var gdbTarget = func() string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0"?><!DOCTYPE target SYSTEM "gdb-target.dtd"><target version="1.0"><feature name="org.ebiten8.chip8">`)
+	for n := 0; n < 16; n++ {
+		fmt.Fprintf(&b, `<reg name="v%x" bitsize="8" type="uint8"/>`, n)
+	}
+	b.WriteString(`<reg name="i" bitsize="16" type="data_ptr"/><reg name="pc" bitsize="16" type="code_ptr"/>`)
+	b.WriteString(`<reg name="sp" bitsize="8" type="uint8"/><reg name="dt" bitsize="8" type="uint8"/><reg name="st" bitsize="8" type="uint8"/>`)
+	b.WriteString(`</feature></target>`)
+	return b.String()
+}()
+
+// GDBServer debugs a Machine over the GDB remote serial protocol. It
+// supports reading and writing registers and memory, stepping, continuing
+// until a breakpoint or an interrupt, and breakpoints. Registers are sent
+// little-endian.
+type GDBServer struct {
+	M           Machine
+	breakpoints map[uint16]bool
+}
+
+func NewGDBServer(m Machine) *GDBServer {
+	return &GDBServer{M: m, breakpoints: map[uint16]bool{}}
+}
+
+// ListenAndServe serves debuggers on a TCP address, one at a time.
+func (g *GDBServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	return g.Serve(l)
+}
+
+// Serve serves the debuggers connecting to l, one at a time.
+func (g *GDBServer) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		err = g.ServeConn(conn)
+		conn.Close()
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+}
+
+// errDetach ends a session at the request of the debugger.
+var errDetach = errors.New("detached")
+
+// ServeConn runs a session with a debugger until it detaches or the
+// connection closes.
+func (g *GDBServer) ServeConn(conn io.ReadWriter) error {
+	in := make(chan byte, 64)
+	done := make(chan error, 1)
+	quit := make(chan struct{})
+	defer close(quit)
+	go func() {
+		r := bufio.NewReader(conn)
+		for {
+			b, err := r.ReadByte()
+			if err != nil {
+				done <- err
+				close(in)
+				return
+			}
+			select {
+			case in <- b:
+			case <-quit:
+				return
+			}
+		}
+	}()
+	s := &gdbSession{in: in, w: conn}
+	for {
+		packet, ok := s.read()
+		if !ok {
+			return <-done
+		}
+		reply, err := g.handle(s, packet)
+		if err == errDetach {
+			// Kill has no reply.
+			if packet != "k" {
+				s.write(reply)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.write(reply); err != nil {
+			return err
+		}
+	}
+}
+
+// gdbSession is a connection to a debugger.
+type gdbSession struct {
+	in chan byte
+	w  io.Writer
+}
+
+// read returns the next packet, acknowledging it. Interrupts outside of
+// continue and acknowledgements are dropped.
+func (s *gdbSession) read() (string, bool) {
+	for {
+		b, ok := <-s.in
+		if !ok {
+			return "", false
+		}
+		if b != '$' {
+			continue
+		}
+		var data []byte
+		for b, ok = <-s.in; ok && b != '#'; b, ok = <-s.in {
+			data = append(data, b)
+		}
+		sum := make([]byte, 2)
+		for n := range sum {
+			if sum[n], ok = <-s.in; !ok {
+				return "", false
+			}
+		}
+		if want, err := strconv.ParseUint(string(sum), 16, 8); err != nil || uint8(want) != checksum(data) {
+			s.w.Write([]byte("-"))
+			continue
+		}
+		s.w.Write([]byte("+"))
+		return string(data), true
+	}
+}
+
+func (s *gdbSession) write(data string) error {
+	_, err := fmt.Fprintf(s.w, "$%s#%02x", data, checksum([]byte(data)))
+	return err
+}
+
+// interrupted reports whether the debugger sent ^C.
+func (s *gdbSession) interrupted() bool {
+	for {
+		select {
+		case b, ok := <-s.in:
+			if !ok || b == 0x03 {
+				return true
+			}
+		default:
+			return false
+		}
+	}
+}
+
+func checksum(data []byte) uint8 {
+	var sum uint8
+	for _, b := range data {
+		sum += b
+	}
+	return sum
+}
+
+// handle answers a packet. Packets it does not support get an empty reply,
+// as the protocol asks.
+func (g *GDBServer) handle(s *gdbSession, packet string) (string, error) {
+	if packet == "" {
+		return "", nil
+	}
+	args := packet[1:]
+	switch packet[0] {
+	case '?':
+		return stopReply(GDB_SIGTRAP), nil
+	case 'g':
+		return hex.EncodeToString(g.registers()), nil
+	case 'G':
+		data, err := hex.DecodeString(args)
+		if err != nil || len(data) != len(g.registers()) {
+			return "E01", nil
+		}
+		return g.setRegisters(data), nil
+	case 'p':
+		n, err := strconv.ParseUint(args, 16, 8)
+		if err != nil || n >= GDB_REGS {
+			return "E01", nil
+		}
+		return hex.EncodeToString(g.register(int(n))), nil
+	case 'P':
+		parts := strings.SplitN(args, "=", 2)
+		if len(parts) != 2 {
+			return "E01", nil
+		}
+		n, err := strconv.ParseUint(parts[0], 16, 8)
+		value, err2 := hex.DecodeString(parts[1])
+		if err != nil || err2 != nil || n >= GDB_REGS || len(value) != len(g.register(int(n))) {
+			return "E01", nil
+		}
+		data := g.registers()
+		copy(data[registerOffset(int(n)):], value)
+		return g.setRegisters(data), nil
+	case 'm':
+		addr, size, ok := parseRange(args)
+		ram := g.M.RAM()
+		if !ok || addr+size > len(ram) {
+			return "E01", nil
+		}
+		return hex.EncodeToString(ram[addr : addr+size]), nil
+	case 'M':
+		parts := strings.SplitN(args, ":", 2)
+		addr, size, ok := parseRange(parts[0])
+		if !ok || len(parts) != 2 {
+			return "E01", nil
+		}
+		data, err := hex.DecodeString(parts[1])
+		if err != nil || len(data) != size || g.M.Poke(addr, data) != nil {
+			return "E01", nil
+		}
+		return "OK", nil
+	case 's':
+		if err := g.jump(args); err != nil {
+			return "E01", nil
+		}
+		return stopReply(signalOf(g.M.Step())), nil
+	case 'c':
+		if err := g.jump(args); err != nil {
+			return "E01", nil
+		}
+		return stopReply(g.cont(s)), nil
+	case 'Z', 'z':
+		parts := strings.Split(args, ",")
+		if len(parts) != 3 || (parts[0] != "0" && parts[0] != "1") {
+			return "", nil
+		}
+		addr, err := strconv.ParseUint(parts[1], 16, 16)
+		if err != nil {
+			return "E01", nil
+		}
+		if packet[0] == 'Z' {
+			g.breakpoints[uint16(addr)] = true
+		} else {
+			delete(g.breakpoints, uint16(addr))
+		}
+		return "OK", nil
+	case 'H':
+		return "OK", nil
+	case 'k':
+		return "", errDetach
+	case 'D':
+		return "OK", errDetach
+	case 'q':
+		return g.query(args), nil
+	}
+	return "", nil
+}
+
+func (g *GDBServer) query(q string) string {
+	switch {
+	case strings.HasPrefix(q, "Supported"):
+		return "PacketSize=1000;qXfer:features:read+"
+	case q == "Attached":
+		return "1"
+	case q == "C":
+		return "QC1"
+	case q == "fThreadInfo":
+		return "m1"
+	case q == "sThreadInfo":
+		return "l"
+	case strings.HasPrefix(q, "Xfer:features:read:target.xml:"):
+		addr, size, ok := parseRange(strings.TrimPrefix(q, "Xfer:features:read:target.xml:"))
+		if !ok {
+			return "E01"
+		}
+		if addr >= len(gdbTarget) {
+			return "l"
+		}
+		if addr+size >= len(gdbTarget) {
+			return "l" + gdbTarget[addr:]
+		}
+		return "m" + gdbTarget[addr:addr+size]
+	}
+	return ""
+}
+
+// jump moves the program counter to the address s and c may be given.
+func (g *GDBServer) jump(addr string) error {
+	if addr == "" {
+		return nil
+	}
+	pc, err := strconv.ParseUint(addr, 16, 16)
+	if err != nil {
+		return err
+	}
+	state := g.M.State()
+	state.PC = uint16(pc)
+	return g.M.SetState(state)
+}
+
+// cont runs the machine until it reaches a breakpoint, fails or the
+// debugger interrupts it, and returns the signal to report.
+func (g *GDBServer) cont(s *gdbSession) int {
+	for n := 1; ; n++ {
+		if err := g.M.Step(); err != nil {
+			return signalOf(err)
+		}
+		if g.breakpoints[g.M.State().PC] {
+			return GDB_SIGTRAP
+		}
+		if n%GDB_CHECK_STEPS == 0 && s.interrupted() {
+			return GDB_SIGINT
+		}
+	}
+}
+
+// signalOf is the signal reported for the result of a step.
+func signalOf(err error) int {
+	switch {
+	case err == nil:
+		return GDB_SIGTRAP
+	case errors.Is(err, ErrOpcode):
+		return GDB_SIGILL
+	default:
+		return GDB_SIGSEGV
+	}
+}
+
+func stopReply(signal int) string {
+	return fmt.Sprintf("S%02x", signal)
+}
+
+// registerOffset is where register n starts in the g packet.
+func registerOffset(n int) int {
+	switch {
+	case n <= GDB_REG_I:
+		return n
+	case n == GDB_REG_PC:
+		return 18
+	default:
+		return n + 2
+	}
+}
+
+func (g *GDBServer) registers() []byte {
+	s := g.M.State()
+	data := append([]byte{}, s.V[:]...)
+	data = append(data, byte(s.I), byte(s.I>>8), byte(s.PC), byte(s.PC>>8))
+	return append(data, byte(len(s.Stack)), s.DT, s.ST)
+}
+
+func (g *GDBServer) register(n int) []byte {
+	data := g.registers()
+	size := 1
+	if n == GDB_REG_I || n == GDB_REG_PC {
+		size = 2
+	}
+	offset := registerOffset(n)
+	return data[offset : offset+size]
+}
+
+// setRegisters writes the registers of a g packet. The stack pointer can
+// only drop return addresses.
+func (g *GDBServer) setRegisters(data []byte) string {
+	s := g.M.State()
+	copy(s.V[:], data)
+	s.I = uint16(data[16]) | uint16(data[17])<<8
+	s.PC = uint16(data[18]) | uint16(data[19])<<8
+	if sp := int(data[20]); sp < len(s.Stack) {
+		s.Stack = s.Stack[:sp]
+	} else if sp > len(s.Stack) {
+		return "E01"
+	}
+	s.DT, s.ST = data[21], data[22]
+	if err := g.M.SetState(s); err != nil {
+		return "E01"
+	}
+	return "OK"
+}
+
+// parseRange reads the addr,length of m and M packets.
+func parseRange(s string) (int, int, bool) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	addr, err := strconv.ParseUint(parts[0], 16, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	size, err := strconv.ParseUint(parts[1], 16, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	return int(addr), int(size), true
+}
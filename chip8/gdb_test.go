@@ -0,0 +1,168 @@
+package chip8
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gdbClient talks to a GDBServer the way GDB does.
+type gdbClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// dialGDB serves the machine on a loopback port and connects to it.
+func dialGDB(t *testing.T, m Machine) *gdbClient {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go NewGDBServer(m).Serve(l)
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &gdbClient{t, conn, bufio.NewReader(conn)}
+}
+
+// send sends a packet and returns the reply.
+func (c *gdbClient) send(packet string) string {
+	c.t.Helper()
+	c.post(packet)
+	return c.reply()
+}
+
+// post sends a packet without waiting for the reply.
+func (c *gdbClient) post(packet string) {
+	c.t.Helper()
+	fmt.Fprintf(c.conn, "$%s#%02x", packet, checksum([]byte(packet)))
+	if ack, err := c.r.ReadByte(); err != nil || ack != '+' {
+		c.t.Fatalf("%s: ack %q %v", packet, ack, err)
+	}
+}
+
+func (c *gdbClient) reply() string {
+	c.t.Helper()
+	if _, err := c.r.ReadString('$'); err != nil {
+		c.t.Fatal(err)
+	}
+	data, err := c.r.ReadString('#')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	sum := make([]byte, 2)
+	if _, err := c.r.Read(sum); err != nil {
+		c.t.Fatal(err)
+	}
+	data = strings.TrimSuffix(data, "#")
+	if want := fmt.Sprintf("%02x", checksum([]byte(data))); string(sum) != want {
+		c.t.Fatalf("checksum %s of %q, want %s", sum, data, want)
+	}
+	return data
+}
+
+func (c *gdbClient) expect(packet, want string) {
+	c.t.Helper()
+	if got := c.send(packet); got != want {
+		c.t.Errorf("%s: got %q, want %q", packet, got, want)
+	}
+}
+
+// gdbProgram counts v0 up in a loop that calls a subroutine.
+var gdbProgram = []byte{
+	0x60, 0x00, // 200 LD v0, 0
+	0x22, 0x08, // 202 CALL 208
+	0x12, 0x02, // 204 JP 202
+	0xFF, 0xFF, // 206 invalid
+	0x70, 0x01, // 208 ADD v0, 1
+	0x00, 0xEE, // 20A RET
+}
+
+func TestGDBRegisters(t *testing.T) {
+	c := dialGDB(t, lockstepSystem(t, gdbProgram, QUIRK_PROFILES["modern"]))
+	c.expect("?", "S05")
+	c.expect("s", "S05")
+	c.expect("s", "S05")
+	c.expect("s", "S05")
+	// v0 is 1, i is 0, pc is 20A, sp is 1.
+	c.expect("g", "01"+strings.Repeat("00", 15)+"0000"+"0a02"+"01"+"00"+"00")
+	c.expect("p11", "0a02")
+	c.expect("P3=2a", "OK")
+	c.expect("P10=0003", "OK")
+	c.expect("p3", "2a")
+	c.expect("p10", "0003")
+	c.expect("p15", "E01")
+	// Dropping the return address makes RET fail.
+	c.expect("P12=00", "OK")
+	c.expect("s", "S0b")
+	c.expect("P12=01", "E01")
+}
+
+func TestGDBMemory(t *testing.T) {
+	sys := lockstepSystem(t, gdbProgram, QUIRK_PROFILES["modern"])
+	c := dialGDB(t, sys)
+	c.expect("m200,4", "60002208")
+	c.expect("mfff,2", "E01")
+	// Patch ADD v0, 1 into ADD v0, 5 after it was decoded and executed.
+	c.expect("s", "S05")
+	c.expect("s", "S05")
+	c.expect("s", "S05")
+	c.expect("M209,1:05", "OK")
+	c.expect("Z0,20a,2", "OK")
+	c.expect("c", "S05")
+	c.expect("p0", "06")
+	c.expect("Mfff,2:0000", "E01")
+	c.expect("m0,2", "f090")
+}
+
+// TestGDBContinue stops at breakpoints, at an invalid opcode and when
+// interrupted.
+func TestGDBContinue(t *testing.T) {
+	sys := lockstepSystem(t, gdbProgram, QUIRK_PROFILES["modern"])
+	c := dialGDB(t, sys)
+	c.expect("Z0,208,2", "OK")
+	c.expect("c", "S05")
+	c.expect("p11", "0802")
+	c.expect("c", "S05")
+	c.expect("p0", "01")
+	c.expect("z0,208,2", "OK")
+	c.expect("Z2,208,2", "")
+
+	// Interrupt the endless loop.
+	c.post("c")
+	c.conn.Write([]byte{0x03})
+	if got := c.reply(); got != "S02" {
+		t.Errorf("interrupt: got %q, want S02", got)
+	}
+
+	c.expect("c206", "S04")
+	c.expect("p11", "0602")
+	c.send("D")
+}
+
+func TestGDBTarget(t *testing.T) {
+	c := dialGDB(t, lockstepSystem(t, gdbProgram, QUIRK_PROFILES["modern"]))
+	if got := c.send("qSupported:multiprocess+"); !strings.Contains(got, "qXfer:features:read+") {
+		t.Errorf("qSupported: %q", got)
+	}
+	var xml strings.Builder
+	for {
+		reply := c.send(fmt.Sprintf("qXfer:features:read:target.xml:%x,100", xml.Len()))
+		xml.WriteString(reply[1:])
+		if reply[0] == 'l' {
+			break
+		}
+	}
+	if xml.String() != gdbTarget || !strings.Contains(gdbTarget, `<reg name="pc" bitsize="16"`) {
+		t.Errorf("target.xml %q", xml.String())
+	}
+	c.expect("vMustReplyEmpty", "")
+}
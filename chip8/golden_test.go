@@ -0,0 +1,159 @@
+package chip8
+
+import (
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden images in testdata/golden")
+
+const (
+	GOLDEN_FRAMES = 300
+	GOLDEN_SEED   = 1
+)
+
+var goldenColors = []color.RGBA{{0, 0, 0, 0xFF}, {0xFF, 0xFF, 0xFF, 0xFF}}
+
+// runGolden runs a ROM headless with a fixed seed and the replay in
+// testdata/replays, if there is one. A panic is returned as an error.
+func runGolden(path string) (vme *VideoMemory, err error) {
+	sys := NewSystem()
+	sys.Cpu.Seed(GOLDEN_SEED)
+	rom, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := sys.Mem.LoadBytes(rom); err != nil {
+		return nil, err
+	}
+	replay := filepath.Join("testdata", "replays", baseName(path)+".txt")
+	if _, err := os.Stat(replay); err == nil {
+		if sys.Replay, err = LoadReplay(replay); err != nil {
+			return nil, err
+		}
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic on frame %d: %v", sys.Frame, r)
+		}
+	}()
+	for sys.Frame < GOLDEN_FRAMES {
+		if err := sys.RunFrame(); err != nil {
+			return nil, err
+		}
+	}
+	return sys.Vme, nil
+}
+
+func hashFrame(buf *screenBuf, hires bool) string {
+	h := sha256.New()
+	if hires {
+		h.Write([]byte{1})
+	}
+	for x := range buf {
+		h.Write(buf[x][:])
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)[:8])
+}
+
+// loadGolden decodes a golden PNG back into a framebuffer. A 128x64 image
+// is a frame of the hires mode.
+func loadGolden(path string) (*screenBuf, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, false, err
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	hires := w == HIRES_H_PIXELS && h == HIRES_V_PIXELS
+	if !hires && (w != H_PIXELS || h != V_PIXELS) {
+		return nil, false, fmt.Errorf("%s is %v, not %dx%d or %dx%d", path, img.Bounds().Size(), H_PIXELS, V_PIXELS, HIRES_H_PIXELS, HIRES_V_PIXELS)
+	}
+	buf := new(screenBuf)
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			if r, _, _, _ := img.At(img.Bounds().Min.X+x, img.Bounds().Min.Y+y).RGBA(); r > 0x7FFF {
+				buf[x][y] = 1
+			}
+		}
+	}
+	return buf, hires, nil
+}
+
+// TestGolden runs every bundled ROM for GOLDEN_FRAMES frames and compares
+// the display with testdata/golden. A ROM expected to fail has the error
+// in a .err file there instead of an image. Run with -update to accept
+// changes.
+func TestGolden(t *testing.T) {
+	roms, err := filepath.Glob(filepath.Join("..", "roms", "*.ch8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roms) == 0 {
+		t.Fatal("no ROMs found")
+	}
+	for _, rom := range roms {
+		rom := rom
+		name := baseName(rom)
+		t.Run(name, func(t *testing.T) {
+			golden := filepath.Join("testdata", "golden", name+".png")
+			goldenErr := filepath.Join("testdata", "golden", name+".err")
+			vme, err := runGolden(rom)
+
+			if *update {
+				if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+					t.Fatal(err)
+				}
+				os.Remove(golden)
+				os.Remove(goldenErr)
+				if err != nil {
+					err = ioutil.WriteFile(goldenErr, []byte(err.Error()+"\n"), 0644)
+				} else {
+					err = vme.SavePNG(golden, 1, goldenColors)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			wantErr, readErr := ioutil.ReadFile(goldenErr)
+			switch {
+			case readErr == nil && err == nil:
+				t.Fatalf("ran without the expected error: %s", strings.TrimSpace(string(wantErr)))
+			case readErr == nil:
+				if got, want := err.Error(), strings.TrimSpace(string(wantErr)); got != want {
+					t.Fatalf("error %q, expected %q", got, want)
+				}
+				return
+			case !os.IsNotExist(readErr):
+				t.Fatal(readErr)
+			case err != nil:
+				t.Fatal(err)
+			}
+
+			want, hires, err := loadGolden(golden)
+			if os.IsNotExist(err) {
+				t.Fatalf("no golden image, run go test -run TestGolden -update")
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := hashFrame(&vme.buf, vme.hires), hashFrame(want, hires); got != want {
+				t.Errorf("framebuffer hash %s, golden %s", got, want)
+			}
+		})
+	}
+}
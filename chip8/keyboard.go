@@ -0,0 +1,36 @@
+package chip8
+
+// Keyboard queues the keys pressed on the hex keypad.
+type Keyboard struct {
+	queue []uint16
+}
+
+func NewKeyboard() *Keyboard {
+	kb := new(Keyboard)
+	kb.queue = []uint16{}
+	return kb
+}
+
+func (kb *Keyboard) Pop() *uint16 {
+	len := len(kb.queue)
+	if len > 0 {
+		key := kb.queue[0]
+		kb.queue = kb.queue[1:]
+		return &key
+	} else {
+		return nil
+	}
+}
+
+func (kb *Keyboard) Clear() {
+	kb.queue = []uint16{}
+}
+
+func (kb *Keyboard) Push(key uint16) {
+	kb.queue = append(kb.queue, key)
+}
+
+// Keys pressed but not consumed by the program yet.
+func (kb *Keyboard) Pending() []uint16 {
+	return kb.queue
+}
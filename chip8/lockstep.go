@@ -0,0 +1,191 @@
+package chip8
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Lockstep runs two machines on the same program one instruction at a time
+// and compares them after every instruction.
+type Lockstep struct {
+	A, B     Machine
+	Timers   bool // Compare the timers, which only agree if both machines count frames alike.
+	Display  bool // Compare the display.
+	MemStart int  // Memory compared, from MemStart up to MemEnd.
+	MemEnd   int
+}
+
+// NewLockstep compares everything in the memory both machines leave to the
+// program.
+func NewLockstep(a, b Machine) *Lockstep {
+	end := programEnd(a)
+	if e := programEnd(b); e < end {
+		end = e
+	}
+	return &Lockstep{A: a, B: b, Timers: true, Display: true, MemStart: PROGRAM_START, MemEnd: end}
+}
+
+// programEnd is where the memory left to the program ends. Only the VIP
+// keeps the top of its memory to itself.
+func programEnd(m Machine) int {
+	if _, ok := m.(*VIP); ok {
+		return len(m.RAM()) - VIP_RESERVED
+	}
+	return len(m.RAM())
+}
+
+// Divergence is the first instruction after which two machines differ.
+type Divergence struct {
+	Step       int    // Instructions executed before it.
+	PC         uint16 // Address of the instruction.
+	Opcode     uint16
+	Before     State // State of both machines before the instruction.
+	A, B       State
+	ErrA, ErrB error
+	Memory     []MemoryDiff
+	ScreenA    VideoMemory
+	ScreenB    VideoMemory
+	timers     bool
+}
+
+// MemoryDiff is a byte that differs between two machines.
+type MemoryDiff struct {
+	Addr int
+	A, B byte
+}
+
+// Run steps both machines up to steps times. It returns the first
+// divergence, or nil if they agreed throughout. If both machines fail on
+// the same instruction with the same error, the run ends with that error.
+func (l *Lockstep) Run(steps int) (*Divergence, error) {
+	for n := 0; n < steps; n++ {
+		before := l.A.State()
+		pc := before.PC
+		var op uint16
+		if ram := l.A.RAM(); int(pc)+1 < len(ram) {
+			op = uint16(ram[pc])<<8 | uint16(ram[pc+1])
+		}
+		errA, errB := l.A.Step(), l.B.Step()
+		d := l.compare()
+		if d == nil && errA == nil && errB == nil {
+			continue
+		}
+		if d == nil && errA != nil && errB != nil && errA.Error() == errB.Error() {
+			return nil, errA
+		}
+		if d == nil {
+			d = &Divergence{A: l.A.State(), B: l.B.State(), timers: l.Timers}
+		}
+		d.Step, d.PC, d.Opcode, d.Before = n, pc, op, before
+		d.ErrA, d.ErrB = errA, errB
+		return d, nil
+	}
+	return nil, nil
+}
+
+// compare returns the differences between the machines, or nil if there
+// are none.
+func (l *Lockstep) compare() *Divergence {
+	d := &Divergence{A: l.A.State(), B: l.B.State(), timers: l.Timers}
+	same := d.A.V == d.B.V && d.A.I == d.B.I && d.A.PC == d.B.PC && equalStacks(d.A.Stack, d.B.Stack)
+	if l.Timers && (d.A.DT != d.B.DT || d.A.ST != d.B.ST) {
+		same = false
+	}
+	ramA, ramB := l.A.RAM(), l.B.RAM()
+	for addr := l.MemStart; addr < l.MemEnd; addr++ {
+		if ramA[addr] != ramB[addr] {
+			d.Memory = append(d.Memory, MemoryDiff{addr, ramA[addr], ramB[addr]})
+		}
+	}
+	if len(d.Memory) > 0 {
+		same = false
+	}
+	if l.Display {
+		d.ScreenA, d.ScreenB = *screenOf(l.A), *screenOf(l.B)
+		if d.ScreenA.buf != d.ScreenB.buf || d.ScreenA.hires != d.ScreenB.hires {
+			same = false
+		}
+	}
+	if same {
+		return nil
+	}
+	return d
+}
+
+// screenOf returns the display memory of a machine. The VIP only updates
+// its screen once a frame, so its display page is read instead.
+func screenOf(m Machine) *VideoMemory {
+	vip, ok := m.(*VIP)
+	if !ok {
+		return m.Screen()
+	}
+	vme := NewVideoMemory()
+	page := vip.ram[len(vip.ram)-0x100:]
+	for y := 0; y < V_PIXELS; y++ {
+		for x := 0; x < H_PIXELS; x++ {
+			vme.buf[x][y] = page[y*8+x/8] >> (7 - x%8) & 1
+		}
+	}
+	return vme
+}
+
+func equalStacks(a, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for n := range a {
+		if a[n] != b[n] {
+			return false
+		}
+	}
+	return true
+}
+
+// String lists the registers, memory and display rows that differ.
+func (d *Divergence) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "step %d: %03X: %04X\n", d.Step, d.PC, d.Opcode)
+	row := func(name string, before, a, b2 interface{}) {
+		if fmt.Sprint(a) != fmt.Sprint(b2) {
+			fmt.Fprintf(&b, "  %-6s %-10v A %-10v B %v\n", name, before, a, b2)
+		}
+	}
+	if d.ErrA != nil || d.ErrB != nil {
+		row("error", "", d.ErrA, d.ErrB)
+	}
+	for n := range d.A.V {
+		row(fmt.Sprintf("v%X", n), fmt.Sprintf("%02X", d.Before.V[n]), fmt.Sprintf("%02X", d.A.V[n]), fmt.Sprintf("%02X", d.B.V[n]))
+	}
+	row("i", fmt.Sprintf("%03X", d.Before.I), fmt.Sprintf("%03X", d.A.I), fmt.Sprintf("%03X", d.B.I))
+	row("pc", fmt.Sprintf("%03X", d.Before.PC), fmt.Sprintf("%03X", d.A.PC), fmt.Sprintf("%03X", d.B.PC))
+	row("stack", fmt.Sprintf("%03X", d.Before.Stack), fmt.Sprintf("%03X", d.A.Stack), fmt.Sprintf("%03X", d.B.Stack))
+	if d.timers {
+		row("dt", d.Before.DT, d.A.DT, d.B.DT)
+		row("st", d.Before.ST, d.A.ST, d.B.ST)
+	}
+	for _, m := range d.Memory {
+		fmt.Fprintf(&b, "  %03X    %-10s A %02X         B %02X\n", m.Addr, "", m.A, m.B)
+	}
+	_, heightA := d.ScreenA.Size()
+	_, heightB := d.ScreenB.Size()
+	for y := 0; y < heightA || y < heightB; y++ {
+		lineA, lineB := d.ScreenA.row(y), d.ScreenB.row(y)
+		if lineA != lineB {
+			fmt.Fprintf(&b, "  row %2d A %s\n         B %s\n", y, lineA, lineB)
+		}
+	}
+	return b.String()
+}
+
+// row draws a line of the display with # for lit pixels.
+func (vme *VideoMemory) row(y int) string {
+	width, _ := vme.Size()
+	line := make([]byte, width)
+	for x := range line {
+		line[x] = '.'
+		if vme.buf[x][y] != 0 {
+			line[x] = '#'
+		}
+	}
+	return string(line)
+}
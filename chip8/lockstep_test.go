@@ -0,0 +1,156 @@
+package chip8
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func lockstepSystem(t *testing.T, rom []byte, quirks Quirks) *System {
+	sys := NewSystem()
+	sys.Cpu.Seed(1)
+	sys.Cpu.Quirks = quirks
+	if err := sys.LoadBytes(rom); err != nil {
+		t.Fatal(err)
+	}
+	return sys
+}
+
+// TestLockstepAgree runs a game on two identical machines.
+func TestLockstepAgree(t *testing.T) {
+	rom, err := ioutil.ReadFile(filepath.Join("..", "roms", "Tetris [Fran Dachille, 1991].ch8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := lockstepSystem(t, rom, QUIRK_PROFILES["modern"])
+	b := lockstepSystem(t, rom, QUIRK_PROFILES["modern"])
+	a.Replay, b.Replay = NewReplay(), NewReplay()
+	for frame := 30; frame < 40; frame++ {
+		a.Replay.Press(frame, 0x4)
+		b.Replay.Press(frame, 0x4)
+	}
+	d, err := NewLockstep(a, b).Run(10000)
+	if err != nil || d != nil {
+		t.Fatalf("diverged: %v %v", err, d)
+	}
+}
+
+// TestLockstepQuirk finds the first shift that differs between quirk
+// profiles.
+func TestLockstepQuirk(t *testing.T) {
+	rom := []byte{0x60, 0x05, 0x61, 0x03, 0x80, 0x16, 0x12, 0x06}
+	a := lockstepSystem(t, rom, QUIRK_PROFILES["modern"])
+	b := lockstepSystem(t, rom, QUIRK_PROFILES["chip8"])
+	d, err := NewLockstep(a, b).Run(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d == nil {
+		t.Fatal("no divergence")
+	}
+	if d.Step != 2 || d.PC != 0x204 || d.Opcode != 0x8016 {
+		t.Errorf("diverged at step %d %03X: %04X, want step 2 204: 8016", d.Step, d.PC, d.Opcode)
+	}
+	if d.A.V[0] != 2 || d.B.V[0] != 1 {
+		t.Errorf("v0 %d and %d, want 2 and 1", d.A.V[0], d.B.V[0])
+	}
+	report := d.String()
+	if !strings.Contains(report, "v0") || strings.Contains(report, "v1") {
+		t.Errorf("report does not list just v0:\n%s", report)
+	}
+}
+
+// TestLockstepDisplay reports the rows a sprite was drawn to differently.
+func TestLockstepDisplay(t *testing.T) {
+	// Draw the 0 glyph at x 62, where it is clipped or wraps.
+	rom := []byte{0x60, 0x3E, 0x61, 0x00, 0xA0, 0x00, 0xD0, 0x15, 0x12, 0x08}
+	a := lockstepSystem(t, rom, QUIRK_PROFILES["modern"])
+	b := lockstepSystem(t, rom, QUIRK_PROFILES["xochip"])
+	d, err := NewLockstep(a, b).Run(100)
+	if err != nil || d == nil {
+		t.Fatalf("no divergence: %v", err)
+	}
+	if d.Opcode != 0xD015 {
+		t.Errorf("diverged on %04X, want D015", d.Opcode)
+	}
+	if report := d.String(); strings.Count(report, "row") != 5 {
+		t.Errorf("report does not list the 5 rows of the sprite:\n%s", report)
+	}
+}
+
+// TestLockstepTopOfMemory finds a byte at 0xFF0, which only the VIP keeps to
+// itself.
+func TestLockstepTopOfMemory(t *testing.T) {
+	rom := []byte{0x12, 0x00}
+	a := lockstepSystem(t, rom, QUIRK_PROFILES["modern"])
+	b := lockstepSystem(t, rom, QUIRK_PROFILES["modern"])
+	if err := b.Poke(0xFF0, []byte{0x42}); err != nil {
+		t.Fatal(err)
+	}
+	l := NewLockstep(a, b)
+	if l.MemEnd != 0x1000 {
+		t.Errorf("memory compared up to %03X, want 1000", l.MemEnd)
+	}
+	d, err := l.Run(100)
+	if err != nil || d == nil {
+		t.Fatalf("no divergence: %v", err)
+	}
+	if len(d.Memory) != 1 || d.Memory[0] != (MemoryDiff{0xFF0, 0, 0x42}) {
+		t.Errorf("memory differs at %v, want FF0 only", d.Memory)
+	}
+	if vip := newTestVIP(t); NewLockstep(a, vip).MemEnd != len(vip.RAM())-VIP_RESERVED {
+		t.Errorf("memory reserved by the VIP is compared")
+	}
+}
+
+// TestLockstepVIP compares the two backends. The stand-in interpreter does
+// not execute instructions, so the program counters part at once.
+func TestLockstepVIP(t *testing.T) {
+	rom := []byte{0x12, 0x00}
+	a := lockstepSystem(t, rom, QUIRK_PROFILES["chip8"])
+	b := newTestVIP(t)
+	b.LoadBytes(rom)
+	l := NewLockstep(a, b)
+	l.Timers = false
+	d, err := l.Run(100)
+	if err != nil || d == nil {
+		t.Fatalf("no divergence: %v", err)
+	}
+	if d.Step != 0 || d.A.PC != 0x200 || d.B.PC != 0x202 || len(d.Memory) != 0 {
+		t.Errorf("got %s", d)
+	}
+}
+
+// TestLockstepVIPInterpreter runs IBM Logo on System and on a VIP running
+// the original interpreter, which is not distributed with the emulator. Set
+// EBITEN8_VIP_INTERPRETER to the path of its 512 byte image to run it.
+func TestLockstepVIPInterpreter(t *testing.T) {
+	path := os.Getenv("EBITEN8_VIP_INTERPRETER")
+	if path == "" {
+		t.Skip("EBITEN8_VIP_INTERPRETER is not set")
+	}
+	interpreter, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rom, err := ioutil.ReadFile(filepath.Join("..", "roms", "IBM Logo.ch8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := lockstepSystem(t, rom, QUIRK_PROFILES["chip8"])
+	b, err := NewVIP(interpreter, 0x1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.LoadBytes(rom); err != nil {
+		t.Fatal(err)
+	}
+	l := NewLockstep(a, b)
+	l.Timers = false
+	d, err := l.Run(100)
+	if err != nil || d != nil {
+		t.Fatalf("diverged: %v %v", err, d)
+	}
+}
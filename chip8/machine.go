@@ -0,0 +1,96 @@
+package chip8
+
+import "fmt"
+
+// Machine is a CHIP-8 computer driven a frame or an instruction at a time.
+// *System interprets CHIP-8 directly and *VIP emulates the COSMAC VIP
+// running the original interpreter, so the tools can use either and the two
+// can be compared.
+type Machine interface {
+	LoadBytes(rom []byte) error
+	RunFrame() error
+	Step() error // Executes one CHIP-8 instruction.
+	State() State
+	SetState(s State) error
+	Poke(addr int, data []byte) error // Writes memory, as a debugger does.
+	Screen() *VideoMemory
+	Keypad() *Keyboard
+	RAM() []byte
+	Buzzing() bool
+}
+
+// State is the CHIP-8 state of a Machine.
+type State struct {
+	V      [16]uint8
+	I      uint16
+	PC     uint16
+	Stack  []uint16 // Return addresses, oldest first.
+	DT, ST uint8
+}
+
+func (s *System) LoadBytes(rom []byte) error {
+	return s.Mem.LoadBytes(rom)
+}
+
+func (s *System) State() State {
+	cpu := s.Cpu
+	state := State{
+		I:     cpu.i,
+		PC:    cpu.pc,
+		Stack: []uint16{},
+		DT:    uint8(cpu.dt),
+		ST:    uint8(cpu.st),
+	}
+	copy(state.V[:], cpu.v[:16])
+	// The stack holds the address of the call.
+	for _, pc := range cpu.stack[:cpu.sp] {
+		state.Stack = append(state.Stack, pc+2)
+	}
+	return state
+}
+
+// SetState changes the registers and the stack.
+func (s *System) SetState(state State) error {
+	cpu := s.Cpu
+	if len(state.Stack) > len(cpu.stack) {
+		return ErrStackOverflow
+	}
+	copy(cpu.v[:16], state.V[:])
+	cpu.i, cpu.pc = state.I, state.PC
+	cpu.dt, cpu.st = uint16(state.DT), uint16(state.ST)
+	for n, pc := range state.Stack {
+		cpu.stack[n] = pc - 2
+	}
+	cpu.sp = uint16(len(state.Stack))
+	return nil
+}
+
+// Poke writes memory and drops the instructions decoded from it.
+func (s *System) Poke(addr int, data []byte) error {
+	if err := checkPoke(len(s.Mem.buf), addr, data); err != nil {
+		return err
+	}
+	for n, b := range data {
+		s.Mem.store(uint16(addr+n), b)
+	}
+	return nil
+}
+
+func checkPoke(size, addr int, data []byte) error {
+	if addr < 0 || addr+len(data) > size {
+		return fmt.Errorf("%d bytes at %#03x: %w", len(data), addr, ErrMemory)
+	}
+	return nil
+}
+
+func (s *System) Screen() *VideoMemory {
+	return s.Vme
+}
+
+func (s *System) Keypad() *Keyboard {
+	return s.Kb
+}
+
+func (s *System) RAM() []byte {
+	return s.Mem.buf[:]
+}
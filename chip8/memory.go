@@ -0,0 +1,74 @@
+package chip8
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// Programs are loaded at this address.
+const PROGRAM_START = 0x200
+
+type Memory struct {
+	buf   [0x1000]byte        // Chip-8 has 0x1000 (4096) bytes of RAM.
+	code  [0x1000]instruction // Instructions decoded at each address.
+	names [0x1000]string      // Names decode gave them, where code is set.
+}
+
+func (m *Memory) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	n, err := f.Read(m.buf[0x200:])
+	m.code = [0x1000]instruction{}
+	log.Printf("%d bytes read from \"%s\".", n, path)
+	return nil
+}
+
+// Copy a program image into memory at PROGRAM_START.
+func (m *Memory) LoadBytes(rom []byte) error {
+	if len(rom) > len(m.buf)-PROGRAM_START {
+		return fmt.Errorf("program of %d bytes does not fit in memory", len(rom))
+	}
+	copy(m.buf[PROGRAM_START:], rom)
+	m.code = [0x1000]instruction{}
+	return nil
+}
+
+// store writes a byte and drops the decoded instructions it overlaps.
+func (m *Memory) store(addr uint16, b byte) {
+	m.buf[addr] = b
+	m.code[addr] = nil
+	if addr > 0 {
+		m.code[addr-1] = nil
+	}
+}
+
+// inRange reports whether n bytes from addr are in memory.
+func (m *Memory) inRange(addr uint16, n int) bool {
+	return int(addr)+n <= len(m.buf)
+}
+
+// opcode reads the instruction at addr, or 0 past the end of memory.
+func (m *Memory) opcode(addr uint16) uint16 {
+	if int(addr)+1 >= len(m.buf) {
+		return 0
+	}
+	return uint16(m.buf[addr])<<8 | uint16(m.buf[addr+1])
+}
+
+// Bytes available for a program.
+func (m *Memory) ProgramSize() int {
+	return len(m.buf) - PROGRAM_START
+}
+
+func NewMemory() *Memory {
+	m := new(Memory)
+
+	// Load fontsets.
+	m.buf = [0x1000]byte{0xF0, 0x90, 0x90, 0x90, 0xF0, 0x20, 0x60, 0x20, 0x20, 0x70, 0xF0, 0x10, 0xF0, 0x80, 0xF0, 0xF0, 0x10, 0xF0, 0x10, 0xF0, 0x90, 0x90, 0xF0, 0x10, 0x10, 0xF0, 0x80, 0xF0, 0x10, 0xF0, 0xF0, 0x80, 0xF0, 0x90, 0xF0, 0xF0, 0x10, 0x20, 0x40, 0x40, 0xF0, 0x90, 0xF0, 0x90, 0xF0, 0xF0, 0x90, 0xF0, 0x10, 0xF0, 0xF0, 0x90, 0xF0, 0x90, 0x90, 0xE0, 0x90, 0xE0, 0x90, 0xE0, 0xF0, 0x80, 0x80, 0x80, 0xF0, 0xE0, 0x90, 0x90, 0x90, 0xE0, 0xF0, 0x80, 0xF0, 0x80, 0xF0, 0xF0, 0x80, 0xF0, 0x80, 0x80}
+
+	return m
+}
@@ -0,0 +1,267 @@
+package chip8
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	MEMVIEW_COLUMNS       = 8  // Bytes on a line of the hex dump.
+	MEMVIEW_RECENT_FRAMES = 30 // Frames a written byte stays highlighted.
+	MEMVIEW_MAX_SELECTION = 32 // Bytes of the largest sprite, 16x16.
+)
+
+// Registers of the register editor, after v0 to vf.
+const (
+	MEMVIEW_REG_I = 16 + iota
+	MEMVIEW_REG_PC
+	MEMVIEW_REG_DT
+	MEMVIEW_REG_ST
+	MEMVIEW_REGS
+)
+
+// Highlight is how a byte of the hex dump stands out.
+type Highlight int
+
+const (
+	HIGHLIGHT_NONE     Highlight = iota
+	HIGHLIGHT_RECENT             // Written in the last frames.
+	HIGHLIGHT_I                  // Pointed to by I.
+	HIGHLIGHT_PC                 // Part of the current instruction.
+	HIGHLIGHT_SELECTED           // In the selection.
+	HIGHLIGHT_CURSOR             // Being edited.
+)
+
+// MemoryView is a hex dump of the memory of a Machine, with the bytes after
+// the cursor shown as a sprite. It edits the selected byte or register a
+// hex digit at a time, which should only be done while the machine is
+// paused.
+type MemoryView struct {
+	M         Machine
+	Start     int  // First address shown, a multiple of MEMVIEW_COLUMNS.
+	Rows      int  // Lines of the hex dump.
+	Cursor    int  // Selected address.
+	Length    int  // Bytes selected from the cursor.
+	Register  int  // Selected register.
+	Registers bool // Editing registers rather than memory.
+	typed     int  // Hex digits typed into the selected byte.
+	last      []byte
+	recent    []int // Frames each byte stays highlighted.
+}
+
+func NewMemoryView(m Machine, rows int) *MemoryView {
+	v := &MemoryView{M: m, Rows: rows, Length: 8}
+	v.Goto(PROGRAM_START)
+	return v
+}
+
+// Frame highlights the bytes written since the last frame and fades the
+// older ones.
+func (v *MemoryView) Frame() {
+	ram := v.M.RAM()
+	if len(v.recent) != len(ram) {
+		v.recent = make([]int, len(ram))
+		v.last = append([]byte{}, ram...)
+		return
+	}
+	for addr, b := range ram {
+		if b != v.last[addr] {
+			v.recent[addr] = MEMVIEW_RECENT_FRAMES
+			v.last[addr] = b
+		} else if v.recent[addr] > 0 {
+			v.recent[addr]--
+		}
+	}
+}
+
+// Goto moves the cursor to an address, scrolling to show it.
+func (v *MemoryView) Goto(addr int) {
+	size := len(v.M.RAM())
+	if addr < 0 {
+		addr = 0
+	}
+	if addr >= size {
+		addr = size - 1
+	}
+	v.Cursor, v.typed = addr, 0
+	if addr < v.Start {
+		v.Start = addr - addr%MEMVIEW_COLUMNS
+	}
+	// Moving past the last line scrolls a line, jumping further puts the
+	// address on the first one.
+	switch end := v.Start + v.Rows*MEMVIEW_COLUMNS; {
+	case addr >= end+MEMVIEW_COLUMNS:
+		v.Start = addr - addr%MEMVIEW_COLUMNS
+	case addr >= end:
+		v.Start += MEMVIEW_COLUMNS
+	}
+}
+
+// Move moves the cursor by delta bytes, or the register selection by delta
+// registers.
+func (v *MemoryView) Move(delta int) {
+	if v.Registers {
+		v.Register = ((v.Register+delta)%MEMVIEW_REGS + MEMVIEW_REGS) % MEMVIEW_REGS
+		v.typed = 0
+		return
+	}
+	v.Goto(v.Cursor + delta)
+}
+
+// Select changes how many bytes from the cursor are selected.
+func (v *MemoryView) Select(delta int) {
+	v.Length += delta
+	if v.Length < 1 {
+		v.Length = 1
+	}
+	if v.Length > MEMVIEW_MAX_SELECTION {
+		v.Length = MEMVIEW_MAX_SELECTION
+	}
+}
+
+// HexDigit returns the digit a key types into the view, given its name as
+// Ebiten spells it: "A" to "F", "Digit0" to "Digit9" or "Numpad0" to
+// "Numpad9". It reports false for any other key.
+func HexDigit(key string) (int, bool) {
+	for _, prefix := range []string{"Digit", "Numpad"} {
+		if d := strings.TrimPrefix(key, prefix); d != key && len(d) == 1 && d[0] >= '0' && d[0] <= '9' {
+			return int(d[0] - '0'), true
+		}
+	}
+	if len(key) == 1 && key[0] >= 'A' && key[0] <= 'F' {
+		return int(key[0]-'A') + 0xA, true
+	}
+	return 0, false
+}
+
+// Type shifts a hex digit into the selected register, or into the byte at
+// the cursor, moving on to the next byte after two digits.
+func (v *MemoryView) Type(digit int) error {
+	if digit < 0 || digit > 0xF {
+		return fmt.Errorf("%d is not a hex digit", digit)
+	}
+	if v.Registers {
+		s := v.M.State()
+		switch r := v.Register; {
+		case r < 16:
+			s.V[r] = s.V[r]<<4 | uint8(digit)
+		case r == MEMVIEW_REG_I:
+			s.I = (s.I<<4 | uint16(digit)) & 0xFFF
+		case r == MEMVIEW_REG_PC:
+			s.PC = (s.PC<<4 | uint16(digit)) & 0xFFF
+		case r == MEMVIEW_REG_DT:
+			s.DT = s.DT<<4 | uint8(digit)
+		case r == MEMVIEW_REG_ST:
+			s.ST = s.ST<<4 | uint8(digit)
+		}
+		return v.M.SetState(s)
+	}
+	b := v.M.RAM()[v.Cursor]<<4 | uint8(digit)
+	if err := v.M.Poke(v.Cursor, []byte{b}); err != nil {
+		return err
+	}
+	if v.typed++; v.typed == 2 {
+		v.Goto(v.Cursor + 1)
+	}
+	return nil
+}
+
+// Selection returns the selected bytes, which are drawn as a sprite.
+func (v *MemoryView) Selection() []byte {
+	ram := v.M.RAM()
+	end := v.Cursor + v.Length
+	if end > len(ram) {
+		end = len(ram)
+	}
+	return ram[v.Cursor:end]
+}
+
+// Highlight returns how the byte at addr stands out, the strongest first.
+func (v *MemoryView) Highlight(addr int) Highlight {
+	s := v.M.State()
+	switch {
+	case !v.Registers && addr == v.Cursor:
+		return HIGHLIGHT_CURSOR
+	case addr >= v.Cursor && addr < v.Cursor+v.Length:
+		return HIGHLIGHT_SELECTED
+	case addr == int(s.PC) || addr == int(s.PC)+1:
+		return HIGHLIGHT_PC
+	case addr == int(s.I):
+		return HIGHLIGHT_I
+	case addr < len(v.recent) && v.recent[addr] > 0:
+		return HIGHLIGHT_RECENT
+	}
+	return HIGHLIGHT_NONE
+}
+
+// Lines returns the hex dump, each line with the address, the bytes in hex
+// and the bytes as ASCII.
+func (v *MemoryView) Lines() []string {
+	ram := v.M.RAM()
+	lines := []string{}
+	for row := 0; row < v.Rows; row++ {
+		addr := v.Start + row*MEMVIEW_COLUMNS
+		if addr >= len(ram) {
+			break
+		}
+		end := addr + MEMVIEW_COLUMNS
+		if end > len(ram) {
+			end = len(ram)
+		}
+		var hex, ascii strings.Builder
+		for _, b := range ram[addr:end] {
+			fmt.Fprintf(&hex, " %02X", b)
+			if b >= 0x20 && b < 0x7F {
+				ascii.WriteByte(b)
+			} else {
+				ascii.WriteByte('.')
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%03X:%-*s  %s", addr, 3*MEMVIEW_COLUMNS, hex.String(), ascii.String()))
+	}
+	return lines
+}
+
+// Cell returns the line and column of the byte at addr in Lines, if it is
+// shown, and the column of its ASCII character.
+func (v *MemoryView) Cell(addr int) (line, column, ascii int, ok bool) {
+	if addr < v.Start || addr >= v.Start+v.Rows*MEMVIEW_COLUMNS || addr >= len(v.M.RAM()) {
+		return 0, 0, 0, false
+	}
+	n := addr - v.Start
+	col := n % MEMVIEW_COLUMNS
+	return n / MEMVIEW_COLUMNS, 5 + 3*col, 6 + 3*MEMVIEW_COLUMNS + col, true
+}
+
+// RegisterLines shows the registers, four to a line, with the selected one
+// in brackets while they are edited.
+func (v *MemoryView) RegisterLines() []string {
+	s := v.M.State()
+	var lines []string
+	var b strings.Builder
+	for r := 0; r < MEMVIEW_REGS; r++ {
+		var name, value string
+		switch {
+		case r < 16:
+			name, value = fmt.Sprintf("V%X", r), fmt.Sprintf("%02X", s.V[r])
+		case r == MEMVIEW_REG_I:
+			name, value = "I", fmt.Sprintf("%03X", s.I)
+		case r == MEMVIEW_REG_PC:
+			name, value = "PC", fmt.Sprintf("%03X", s.PC)
+		case r == MEMVIEW_REG_DT:
+			name, value = "DT", fmt.Sprintf("%02X", s.DT)
+		case r == MEMVIEW_REG_ST:
+			name, value = "ST", fmt.Sprintf("%02X", s.ST)
+		}
+		if v.Registers && r == v.Register {
+			fmt.Fprintf(&b, "%2s[%s] ", name, value)
+		} else {
+			fmt.Fprintf(&b, "%2s %s  ", name, value)
+		}
+		if r%4 == 3 || r == MEMVIEW_REGS-1 {
+			lines = append(lines, strings.TrimRight(b.String(), " "))
+			b.Reset()
+		}
+	}
+	return lines
+}
@@ -0,0 +1,144 @@
+package chip8
+
+import (
+	"testing"
+)
+
+// memviewProgram stores "A" at 300 and loops.
+var memviewProgram = []byte{
+	0xA3, 0x00, // 200 LD I, 300
+	0x60, 0x41, // 202 LD v0, 41
+	0xF0, 0x55, // 204 LD [I], v0
+	0x12, 0x06, // 206 JP 206
+}
+
+func TestMemoryViewDump(t *testing.T) {
+	sys := lockstepSystem(t, memviewProgram, QUIRK_PROFILES["modern"])
+	v := NewMemoryView(sys, 4)
+	v.Frame()
+	for n := 0; n < 3; n++ {
+		if err := sys.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	v.Frame()
+	if got, want := v.Lines()[0], "200: A3 00 60 41 F0 55 12 06  ..`A.U.."; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	line, column, ascii, ok := v.Cell(0x203)
+	if !ok || line != 0 || v.Lines()[line][column:column+2] != "41" || v.Lines()[line][ascii] != 'A' {
+		t.Errorf("cell of 203: line %d column %d ascii %d", line, column, ascii)
+	}
+	if _, _, _, ok := v.Cell(0x220); ok {
+		t.Errorf("220 is shown")
+	}
+
+	v.Length = 2
+	tests := []struct {
+		addr int
+		want Highlight
+	}{
+		{0x200, HIGHLIGHT_CURSOR},
+		{0x201, HIGHLIGHT_SELECTED},
+		{0x206, HIGHLIGHT_PC},
+		{0x207, HIGHLIGHT_PC},
+		{0x300, HIGHLIGHT_I},
+		{0x301, HIGHLIGHT_NONE},
+	}
+	for _, test := range tests {
+		if got := v.Highlight(test.addr); got != test.want {
+			t.Errorf("%03X: highlight %d, want %d", test.addr, got, test.want)
+		}
+	}
+	// The write to 300 is highlighted once I moves on, and fades.
+	sys.Cpu.i = 0x400
+	if got := v.Highlight(0x300); got != HIGHLIGHT_RECENT {
+		t.Errorf("written byte: highlight %d", got)
+	}
+	for n := 0; n < MEMVIEW_RECENT_FRAMES; n++ {
+		v.Frame()
+	}
+	if got := v.Highlight(0x300); got != HIGHLIGHT_NONE {
+		t.Errorf("old write: highlight %d", got)
+	}
+}
+
+func TestMemoryViewEdit(t *testing.T) {
+	sys := lockstepSystem(t, memviewProgram, QUIRK_PROFILES["modern"])
+	v := NewMemoryView(sys, 4)
+	// Make LD v0, 41 load 7E after it was decoded.
+	if err := sys.Step(); err != nil {
+		t.Fatal(err)
+	}
+	v.Move(3)
+	for _, digit := range []int{7, 0xE} {
+		if err := v.Type(digit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v.Cursor != 0x204 {
+		t.Errorf("cursor at %03X after typing a byte", v.Cursor)
+	}
+	if err := sys.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if sys.Cpu.v[0] != 0x7E {
+		t.Errorf("v0 %02X, want 7E", sys.Cpu.v[0])
+	}
+	if err := v.Type(16); err == nil {
+		t.Errorf("typed 16 as a hex digit")
+	}
+
+	v.Registers = true
+	v.Move(-3)
+	if v.Register != MEMVIEW_REG_PC {
+		t.Fatalf("register %d selected, want PC", v.Register)
+	}
+	for _, digit := range []int{2, 0, 6} {
+		v.Type(digit)
+	}
+	if s := sys.State(); s.PC != 0x206 {
+		t.Errorf("pc %03X, want 206", s.PC)
+	}
+	if got, want := v.RegisterLines()[4], " I 300  PC[206] DT 00  ST 00"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	v.Registers = false
+	v.Goto(0x240)
+	if v.Start != 0x240 || v.Cursor != 0x240 {
+		t.Errorf("start %03X cursor %03X after going to 240", v.Start, v.Cursor)
+	}
+	v.Move(-1)
+	if v.Start != 0x238 {
+		t.Errorf("start %03X after moving back a byte", v.Start)
+	}
+	v.Select(100)
+	if len(v.Selection()) != MEMVIEW_MAX_SELECTION {
+		t.Errorf("selected %d bytes", len(v.Selection()))
+	}
+}
+
+func TestHexDigit(t *testing.T) {
+	for _, tc := range []struct {
+		key   string
+		digit int
+		ok    bool
+	}{
+		{"Digit0", 0, true},
+		{"Digit9", 9, true},
+		{"Numpad7", 7, true},
+		{"A", 0xA, true},
+		{"C", 0xC, true},
+		{"F", 0xF, true},
+		{"G", 0, false},
+		{"Digit", 0, false},
+		{"Digit10", 0, false},
+		{"F1", 0, false},
+		{"Tab", 0, false},
+	} {
+		if digit, ok := HexDigit(tc.key); digit != tc.digit || ok != tc.ok {
+			t.Errorf("%s: %X %v, want %X %v", tc.key, digit, ok, tc.digit, tc.ok)
+		}
+	}
+}
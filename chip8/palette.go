@@ -0,0 +1,108 @@
+package chip8
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"strings"
+
+	"github.com/yukinarit/ebiten8/octo"
+)
+
+// Palette maps the planes a pixel is drawn on to a color. Colors[0] is the
+// background, Colors[1] the first plane, Colors[2] the second plane and
+// Colors[3] both planes. 2, 4 or 16 colors may be given.
+type Palette struct {
+	Name   string
+	Colors []color.RGBA
+}
+
+// Built-in palettes, cycled through at runtime.
+var PALETTES = []Palette{
+	{"Classic", []color.RGBA{rgb(0x000000), rgb(0xFFFFFF), rgb(0xAAAAAA), rgb(0x555555)}},
+	{"Green Phosphor", []color.RGBA{rgb(0x0A1A0A), rgb(0x33FF66), rgb(0x1F9940), rgb(0x14662A)}},
+	{"Amber", []color.RGBA{rgb(0x1A0F00), rgb(0xFFB000), rgb(0xB37B00), rgb(0x664600)}},
+	{"LCD", []color.RGBA{rgb(0x9BBC0F), rgb(0x0F380F), rgb(0x306230), rgb(0x8BAC0F)}},
+	{"Octo", []color.RGBA{rgb(0x996600), rgb(0xFFCC00), rgb(0xFF6600), rgb(0x662200)}},
+}
+
+func rgb(v uint32) color.RGBA {
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xFF}
+}
+
+// Find a built-in palette by case insensitive name.
+func FindPalette(name string) (Palette, bool) {
+	for _, p := range PALETTES {
+		if strings.EqualFold(p.Name, name) {
+			return p, true
+		}
+	}
+	return Palette{}, false
+}
+
+// Palette built from the colors of an Octo cartridge.
+func OctoPalette(opts octo.Options) Palette {
+	p, _ := FindPalette("Octo")
+	colors := append([]color.RGBA{}, p.Colors...)
+	for n, s := range []string{opts.BackgroundColor, opts.FillColor, opts.FillColor2, opts.BlendColor} {
+		if c, err := octo.ParseColor(s); err == nil {
+			colors[n] = c
+		}
+	}
+	return Palette{"Cartridge", colors}
+}
+
+// The palette following p in PALETTES.
+func (p Palette) Next() Palette {
+	for n, q := range PALETTES {
+		if q.Name == p.Name {
+			return PALETTES[(n+1)%len(PALETTES)]
+		}
+	}
+	return PALETTES[0]
+}
+
+func (p Palette) Background() color.RGBA {
+	return p.Colors[0]
+}
+
+// Color of a pixel drawn on the given bitmask of planes.
+func (p Palette) Color(planes int) color.RGBA {
+	if planes < len(p.Colors) {
+		return p.Colors[planes]
+	}
+	return p.Colors[1]
+}
+
+type paletteJSON struct {
+	Name   string   `json:"name"`
+	Colors []string `json:"colors"`
+}
+
+func (p Palette) MarshalJSON() ([]byte, error) {
+	v := paletteJSON{p.Name, []string{}}
+	for _, c := range p.Colors {
+		v.Colors = append(v.Colors, fmt.Sprintf("#%02X%02X%02X", c.R, c.G, c.B))
+	}
+	return json.Marshal(v)
+}
+
+func (p *Palette) UnmarshalJSON(data []byte) error {
+	var v paletteJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if len(v.Colors) != 2 && len(v.Colors) != 4 && len(v.Colors) != 16 {
+		return fmt.Errorf("palette %q must have 2, 4 or 16 colors", v.Name)
+	}
+	p.Name = v.Name
+	p.Colors = nil
+	for _, s := range v.Colors {
+		c, err := octo.ParseColor(s)
+		if err != nil {
+			return err
+		}
+		p.Colors = append(p.Colors, c)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package chip8
+
+import (
+	"encoding/json"
+	"image/color"
+	"reflect"
+	"testing"
+
+	"github.com/yukinarit/ebiten8/octo"
+)
+
+func TestPaletteJSON(t *testing.T) {
+	for _, p := range PALETTES {
+		t.Run(p.Name, func(t *testing.T) {
+			data, err := json.Marshal(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var q Palette
+			if err := json.Unmarshal(data, &q); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(p, q) {
+				t.Errorf("%s read back as %+v", data, q)
+			}
+		})
+	}
+
+	data, _ := json.Marshal(Palette{"Mono", []color.RGBA{rgb(0x102030), rgb(0xFFEEDD)}})
+	if want := `{"name":"Mono","colors":["#102030","#FFEEDD"]}`; string(data) != want {
+		t.Errorf("palette = %s, want %s", data, want)
+	}
+}
+
+func TestPaletteJSONErrors(t *testing.T) {
+	for _, data := range []string{
+		`{"name": "Three", "colors": ["#000", "#FFF", "#888"]}`,
+		`{"name": "Bad", "colors": ["#000", "white"]}`,
+		`[]`,
+	} {
+		var p Palette
+		if err := json.Unmarshal([]byte(data), &p); err == nil {
+			t.Errorf("%s read as %+v, want an error", data, p)
+		}
+	}
+}
+
+func TestPaletteColors(t *testing.T) {
+	p, ok := FindPalette("green phosphor")
+	if !ok || p.Name != "Green Phosphor" {
+		t.Fatalf("FindPalette = %+v, %v", p, ok)
+	}
+	if _, ok := FindPalette("Sepia"); ok {
+		t.Error("found an unknown palette")
+	}
+	if p.Background() != p.Colors[0] || p.Color(3) != p.Colors[3] {
+		t.Error("colors do not come from the palette")
+	}
+	mono := Palette{"Mono", []color.RGBA{rgb(0), rgb(0xFFFFFF)}}
+	if mono.Color(2) != mono.Colors[1] {
+		t.Error("a second plane without a color of its own is not drawn in the first color")
+	}
+	if next := PALETTES[len(PALETTES)-1].Next(); next.Name != PALETTES[0].Name {
+		t.Errorf("palettes do not cycle back to %s: %s", PALETTES[0].Name, next.Name)
+	}
+	if next := mono.Next(); next.Name != PALETTES[0].Name {
+		t.Errorf("a custom palette is followed by %s", next.Name)
+	}
+}
+
+func TestOctoPalette(t *testing.T) {
+	opts := octo.DefaultOptions()
+	opts.FillColor = "#FF0000"
+	opts.BlendColor = "none"
+	p := OctoPalette(opts)
+	want := []color.RGBA{rgb(0x996600), rgb(0xFF0000), rgb(0xFF6600), rgb(0x662200)}
+	if !reflect.DeepEqual(p.Colors, want) {
+		t.Errorf("colors = %v, want %v", p.Colors, want)
+	}
+}
@@ -0,0 +1,234 @@
+package chip8
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MAX_FRAME_TICKS is the longest a stored frame lasts, about 10 minutes,
+// which keeps its delay within the 16 bits GIF and APNG store it in. A
+// longer still display is stored as several frames.
+const MAX_FRAME_TICKS = 39000
+
+// Recorder captures the display once per frame and encodes the result as an
+// animated GIF or APNG. Consecutive identical frames are stored once with a
+// longer duration.
+type Recorder struct {
+	Scale  int
+	Colors []color.RGBA // Indexed by pixel value.
+	frames []recordedFrame
+	ticks  []int // 60 Hz frames each stored frame lasts.
+	hires  bool  // Some frame is 128x64, so all are stored at that size.
+}
+
+type recordedFrame struct {
+	buf   screenBuf
+	hires bool
+}
+
+func NewRecorder(scale int, colors []color.RGBA) *Recorder {
+	if scale < 1 {
+		scale = 1
+	}
+	return &Recorder{Scale: scale, Colors: colors}
+}
+
+// Capture records the display. It is called once per 60 Hz frame.
+func (r *Recorder) Capture(vme *VideoMemory) {
+	frame := recordedFrame{vme.buf, vme.hires}
+	if n := len(r.frames); n > 0 && r.frames[n-1] == frame && r.ticks[n-1] < MAX_FRAME_TICKS {
+		r.ticks[n-1]++
+		return
+	}
+	r.frames = append(r.frames, frame)
+	r.ticks = append(r.ticks, 1)
+	r.hires = r.hires || vme.hires
+}
+
+// Frames stored after deduplication.
+func (r *Recorder) Frames() int {
+	return len(r.frames)
+}
+
+// delays converts frame durations to the given time unit per second, rounding
+// on the running total so that no time is lost over long recordings.
+func (r *Recorder) delays(unit int) []int {
+	delays := make([]int, len(r.ticks))
+	elapsed, shown := 0, 0
+	for n, ticks := range r.ticks {
+		elapsed += ticks
+		end := (elapsed*unit + 30) / 60
+		delays[n] = end - shown
+		shown = end
+	}
+	return delays
+}
+
+// size of the images, 64x32 or 128x64 pixels scaled up. A recording with
+// both keeps the 64x32 frames at the same size with pixels twice as large.
+func (r *Recorder) size() (int, int) {
+	if r.hires {
+		return HIRES_H_PIXELS * r.Scale, HIRES_V_PIXELS * r.Scale
+	}
+	return H_PIXELS * r.Scale, V_PIXELS * r.Scale
+}
+
+func (r *Recorder) image(n int) *image.Paletted {
+	palette := color.Palette{}
+	for _, c := range r.Colors {
+		palette = append(palette, c)
+	}
+	width, height := r.size()
+	scale := r.Scale
+	if r.hires && !r.frames[n].hires {
+		scale *= 2
+	}
+	img := image.NewPaletted(image.Rect(0, 0, width, height), palette)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Pix[y*img.Stride+x] = r.frames[n].buf[x/scale][y/scale]
+		}
+	}
+	return img
+}
+
+func (r *Recorder) EncodeGIF(w io.Writer) error {
+	if len(r.frames) == 0 {
+		return fmt.Errorf("nothing was recorded")
+	}
+	g := &gif.GIF{}
+	for n, delay := range r.delays(100) {
+		g.Image = append(g.Image, r.image(n))
+		g.Delay = append(g.Delay, delay)
+		g.Disposal = append(g.Disposal, gif.DisposalNone)
+	}
+	return gif.EncodeAll(w, g)
+}
+
+// EncodeAPNG writes an animated PNG. Unlike GIF it keeps exact 1/60 s
+// timing.
+func (r *Recorder) EncodeAPNG(w io.Writer) error {
+	if len(r.frames) == 0 {
+		return fmt.Errorf("nothing was recorded")
+	}
+	width, height := r.size()
+	enc := &apngWriter{w: w}
+	enc.write([]byte("\x89PNG\r\n\x1a\n"))
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(width))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(height))
+	ihdr[8] = 8 // Bit depth.
+	ihdr[9] = 3 // Indexed color.
+	enc.chunk("IHDR", ihdr)
+
+	actl := make([]byte, 8)
+	binary.BigEndian.PutUint32(actl[0:], uint32(len(r.frames)))
+	enc.chunk("acTL", actl) // Loop forever.
+
+	plte := []byte{}
+	for _, c := range r.Colors {
+		plte = append(plte, c.R, c.G, c.B)
+	}
+	enc.chunk("PLTE", plte)
+
+	seq := uint32(0)
+	for n, ticks := range r.ticks {
+		fctl := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl[0:], seq)
+		binary.BigEndian.PutUint32(fctl[4:], uint32(width))
+		binary.BigEndian.PutUint32(fctl[8:], uint32(height))
+		binary.BigEndian.PutUint16(fctl[20:], uint16(ticks))
+		binary.BigEndian.PutUint16(fctl[22:], 60)
+		enc.chunk("fcTL", fctl)
+		seq++
+
+		data, err := r.compress(n)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			enc.chunk("IDAT", data)
+		} else {
+			fdat := make([]byte, 4, 4+len(data))
+			binary.BigEndian.PutUint32(fdat, seq)
+			enc.chunk("fdAT", append(fdat, data...))
+			seq++
+		}
+	}
+	enc.chunk("IEND", nil)
+	return enc.err
+}
+
+// compress encodes a frame as zlib compressed, unfiltered PNG scanlines.
+func (r *Recorder) compress(n int) ([]byte, error) {
+	img := r.image(n)
+	var buf bytes.Buffer
+	z := zlib.NewWriter(&buf)
+	row := make([]byte, 1+img.Rect.Dx())
+	for y := 0; y < img.Rect.Dy(); y++ {
+		copy(row[1:], img.Pix[y*img.Stride:])
+		if _, err := z.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	if err := z.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type apngWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (a *apngWriter) write(b []byte) {
+	if a.err == nil {
+		_, a.err = a.w.Write(b)
+	}
+}
+
+func (a *apngWriter) chunk(name string, data []byte) {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, uint32(len(data)))
+	copy(header[4:], name)
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	a.write(header)
+	a.write(data)
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, crc.Sum32())
+	a.write(sum)
+}
+
+// Save encodes the recording as APNG if the path ends in ".png" or ".apng",
+// and as GIF otherwise.
+func (r *Recorder) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".png" || ext == ".apng" {
+		err = r.EncodeAPNG(f)
+	} else {
+		err = r.EncodeGIF(f)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,135 @@
+package chip8
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+// record captures a display which alternates between blank and one lit
+// pixel, for the given number of frames each.
+func record(runs ...int) *Recorder {
+	r := NewRecorder(2, []color.RGBA{{0, 0, 0, 0xFF}, {0xFF, 0xFF, 0xFF, 0xFF}})
+	vme := NewVideoMemory()
+	for n, frames := range runs {
+		vme.buf[0][0] = byte(n % 2)
+		for i := 0; i < frames; i++ {
+			r.Capture(vme)
+		}
+	}
+	return r
+}
+
+func TestRecorderGIF(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		runs   []int
+		delays []int
+	}{
+		{"merged", []int{3, 1, 2}, []int{5, 2, 3}},
+		{"single", []int{1}, []int{2}},
+		{"split", []int{MAX_FRAME_TICKS + 6}, []int{65000, 10}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := record(tc.runs...).EncodeGIF(&b); err != nil {
+				t.Fatal(err)
+			}
+			g, err := gif.DecodeAll(&b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(g.Image) != len(tc.delays) {
+				t.Fatalf("%d frames, want %d", len(g.Image), len(tc.delays))
+			}
+			for n, delay := range g.Delay {
+				if delay != tc.delays[n] {
+					t.Errorf("delay of frame %d = %d, want %d", n, delay, tc.delays[n])
+				}
+			}
+			if size := g.Image[0].Bounds().Size(); size.X != 2*H_PIXELS || size.Y != 2*V_PIXELS {
+				t.Errorf("size %v, want %dx%d", size, 2*H_PIXELS, 2*V_PIXELS)
+			}
+		})
+	}
+}
+
+func TestRecorderAPNG(t *testing.T) {
+	var b bytes.Buffer
+	if err := record(2, 1, MAX_FRAME_TICKS+3).EncodeAPNG(&b); err != nil {
+		t.Fatal(err)
+	}
+	data := b.Bytes()[8:]
+	frames := uint32(0)
+	delays := []uint16{}
+	for len(data) >= 12 {
+		size := binary.BigEndian.Uint32(data)
+		chunk := data[8 : 8+size]
+		switch string(data[4:8]) {
+		case "acTL":
+			frames = binary.BigEndian.Uint32(chunk)
+		case "fcTL":
+			delays = append(delays, binary.BigEndian.Uint16(chunk[20:]))
+			if den := binary.BigEndian.Uint16(chunk[22:]); den != 60 {
+				t.Errorf("delay denominator %d, want 60", den)
+			}
+		}
+		data = data[12+size:]
+	}
+	want := []uint16{2, 1, MAX_FRAME_TICKS, 3}
+	if int(frames) != len(want) || len(delays) != len(want) {
+		t.Fatalf("%d frames with delays %v, want %v", frames, delays, want)
+	}
+	for n := range want {
+		if delays[n] != want[n] {
+			t.Errorf("delay of frame %d = %d, want %d", n, delays[n], want[n])
+		}
+	}
+}
+
+// TestRecorderHires records a 64x32 frame and a 128x64 one. Both come out
+// at the hires size, the first with pixels twice as large.
+func TestRecorderHires(t *testing.T) {
+	r := NewRecorder(1, []color.RGBA{{0, 0, 0, 0xFF}, {0xFF, 0xFF, 0xFF, 0xFF}})
+	vme := NewVideoMemory()
+	vme.buf[1][1] = 1
+	r.Capture(vme)
+	vme.setHires(true)
+	vme.buf[1][1] = 1
+	r.Capture(vme)
+
+	var b bytes.Buffer
+	if err := r.EncodeGIF(&b); err != nil {
+		t.Fatal(err)
+	}
+	g, err := gif.DecodeAll(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Image) != 2 {
+		t.Fatalf("%d frames, want 2", len(g.Image))
+	}
+	for n, img := range g.Image {
+		if size := img.Bounds().Size(); size.X != HIRES_H_PIXELS || size.Y != HIRES_V_PIXELS {
+			t.Errorf("frame %d is %v, want %dx%d", n, size, HIRES_H_PIXELS, HIRES_V_PIXELS)
+		}
+	}
+	if img := g.Image[0]; img.ColorIndexAt(2, 2) != 1 || img.ColorIndexAt(3, 3) != 1 || img.ColorIndexAt(1, 1) != 0 {
+		t.Error("64x32 frame not scaled up")
+	}
+	if img := g.Image[1]; img.ColorIndexAt(1, 1) != 1 || img.ColorIndexAt(2, 2) != 0 {
+		t.Error("128x64 frame scaled")
+	}
+}
+
+func TestRecorderEmpty(t *testing.T) {
+	r := NewRecorder(1, nil)
+	if err := r.EncodeGIF(&bytes.Buffer{}); err == nil {
+		t.Error("GIF of nothing encoded without error")
+	}
+	if err := r.EncodeAPNG(&bytes.Buffer{}); err == nil {
+		t.Error("APNG of nothing encoded without error")
+	}
+}
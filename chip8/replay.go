@@ -0,0 +1,101 @@
+package chip8
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Replay is scripted keypad input: the keys held down on each frame.
+//
+// The text format has one entry per line: a frame number or an inclusive
+// range of frames, followed by the hex keys held on those frames. "#" starts
+// a comment.
+//
+//	# Start the game, then hold 4 for a second.
+//	10 5
+//	60-119 4
+type Replay struct {
+	keys   map[int][]uint16
+	frames int
+}
+
+func NewReplay() *Replay {
+	return &Replay{keys: map[int][]uint16{}}
+}
+
+func LoadReplay(path string) (*Replay, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadReplay(f)
+}
+
+func ReadReplay(r io.Reader) (*Replay, error) {
+	replay := NewReplay()
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		first, last, err := parseFrames(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("replay line %d: %v", n, err)
+		}
+		for _, field := range fields[1:] {
+			key, err := strconv.ParseUint(field, 16, 8)
+			if err != nil || key > 0xF {
+				return nil, fmt.Errorf("replay line %d: invalid key %q", n, field)
+			}
+			for frame := first; frame <= last; frame++ {
+				replay.Press(frame, uint16(key))
+			}
+		}
+	}
+	return replay, scanner.Err()
+}
+
+func parseFrames(s string) (int, int, error) {
+	parts := strings.SplitN(s, "-", 2)
+	first, err := strconv.Atoi(parts[0])
+	if err != nil || first < 0 {
+		return 0, 0, fmt.Errorf("invalid frame %q", s)
+	}
+	last := first
+	if len(parts) == 2 {
+		last, err = strconv.Atoi(parts[1])
+		if err != nil || last < first {
+			return 0, 0, fmt.Errorf("invalid frame range %q", s)
+		}
+	}
+	return first, last, nil
+}
+
+// Press holds a key down on a frame.
+func (r *Replay) Press(frame int, key uint16) {
+	r.keys[frame] = append(r.keys[frame], key)
+	if frame >= r.frames {
+		r.frames = frame + 1
+	}
+}
+
+// Keys held down on a frame.
+func (r *Replay) Keys(frame int) []uint16 {
+	return r.keys[frame]
+}
+
+// Frames up to and including the last one with input.
+func (r *Replay) Frames() int {
+	return r.frames
+}
@@ -0,0 +1,78 @@
+package chip8
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Screenshot renders the display scaled up by a whole factor, with colors
+// indexed by pixel value.
+func (vme *VideoMemory) Screenshot(scale int, colors []color.RGBA) *image.RGBA {
+	if scale < 1 {
+		scale = 1
+	}
+	w, h := vme.Size()
+	img := image.NewRGBA(image.Rect(0, 0, w*scale, h*scale))
+	for y := 0; y < h*scale; y++ {
+		for x := 0; x < w*scale; x++ {
+			img.SetRGBA(x, y, colors[vme.buf[x/scale][y/scale]])
+		}
+	}
+	return img
+}
+
+// WritePNG encodes a screenshot as PNG.
+func (vme *VideoMemory) WritePNG(w io.Writer, scale int, colors []color.RGBA) error {
+	return png.Encode(w, vme.Screenshot(scale, colors))
+}
+
+// SavePNG writes a screenshot to a file.
+func (vme *VideoMemory) SavePNG(path string, scale int, colors []color.RGBA) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := vme.WritePNG(f, scale, colors); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ScreenshotName derives a file name from a ROM path and a frame number,
+// e.g. "Pong_1_player-000120.png".
+func ScreenshotName(rom string, frame int) string {
+	return fmt.Sprintf("%s-%06d.png", baseName(rom), frame)
+}
+
+// RecordingName is like ScreenshotName for an animated GIF.
+func RecordingName(rom string, frame int) string {
+	return fmt.Sprintf("%s-%06d.gif", baseName(rom), frame)
+}
+
+// baseName turns a ROM path into a name safe to use in file names.
+func baseName(rom string) string {
+	base := strings.TrimSuffix(filepath.Base(rom), filepath.Ext(rom))
+	var b strings.Builder
+	underscore := false
+	for _, r := range base {
+		if r < 0x80 && (r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.') {
+			b.WriteRune(r)
+			underscore = false
+		} else if !underscore && b.Len() > 0 {
+			b.WriteByte('_')
+			underscore = true
+		}
+	}
+	name := strings.TrimSuffix(b.String(), "_")
+	if name == "" {
+		name = "chip8"
+	}
+	return name
+}
@@ -0,0 +1,78 @@
+package chip8
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestWritePNG(t *testing.T) {
+	vme := NewVideoMemory()
+	vme.draw(0, 0, []byte{0x80}, 8, true)
+	vme.draw(63, 31, []byte{0x80}, 8, true)
+	bg, fg := color.RGBA{0x10, 0x20, 0x30, 0xFF}, color.RGBA{0xF0, 0xE0, 0xD0, 0xFF}
+
+	var b bytes.Buffer
+	if err := vme.WritePNG(&b, 3, []color.RGBA{bg, fg}); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := img.Bounds().Size(); size.X != 3*H_PIXELS || size.Y != 3*V_PIXELS {
+		t.Fatalf("size = %v, want %dx%d", size, 3*H_PIXELS, 3*V_PIXELS)
+	}
+	for _, tc := range []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{0, 0, fg}, {2, 2, fg}, {3, 0, bg}, {0, 3, bg},
+		{189, 93, fg}, {191, 95, fg}, {188, 95, bg}, {100, 50, bg},
+	} {
+		if c := color.RGBAModel.Convert(img.At(tc.x, tc.y)).(color.RGBA); c != tc.want {
+			t.Errorf("pixel %d,%d = %v, want %v", tc.x, tc.y, c, tc.want)
+		}
+	}
+}
+
+func TestWritePNGHires(t *testing.T) {
+	vme := NewVideoMemory()
+	vme.setHires(true)
+	vme.draw(127, 63, []byte{0x80}, 8, true)
+
+	var b bytes.Buffer
+	if err := vme.WritePNG(&b, 2, []color.RGBA{{0, 0, 0, 0xFF}, {0xFF, 0xFF, 0xFF, 0xFF}}); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := img.Bounds().Size(); size.X != 2*HIRES_H_PIXELS || size.Y != 2*HIRES_V_PIXELS {
+		t.Fatalf("size = %v, want %dx%d", size, 2*HIRES_H_PIXELS, 2*HIRES_V_PIXELS)
+	}
+	if r, _, _, _ := img.At(255, 127).RGBA(); r != 0xFFFF {
+		t.Error("bottom right pixel not lit")
+	}
+}
+
+func TestScreenshotName(t *testing.T) {
+	for _, tc := range []struct {
+		rom  string
+		want string
+	}{
+		{"roms/Pong (1 player).ch8", "Pong_1_player-000120.png"},
+		{"/tmp/BLINKY", "BLINKY-000120.png"},
+		{"game.v2.gif", "game.v2-000120.png"},
+		{"（）.ch8", "chip8-000120.png"},
+	} {
+		if name := ScreenshotName(tc.rom, 120); name != tc.want {
+			t.Errorf("ScreenshotName(%q) = %q, want %q", tc.rom, name, tc.want)
+		}
+	}
+	if name := RecordingName("Pong.ch8", 7); name != "Pong-000007.gif" {
+		t.Errorf("RecordingName = %q", name)
+	}
+}
@@ -0,0 +1,101 @@
+package chip8
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Settings remembered for a ROM.
+type RomSettings struct {
+	Palette *Palette         `json:"palette,omitempty"`
+	Display *DisplaySettings `json:"display,omitempty"`
+}
+
+// Flicker reduction of a ROM. See Display.
+type DisplaySettings struct {
+	Mode   string  `json:"mode"`
+	Frames int     `json:"frames,omitempty"`
+	Decay  float64 `json:"decay,omitempty"`
+}
+
+func (ds *DisplaySettings) Apply(d *Display) error {
+	mode, err := ParseDisplayMode(ds.Mode)
+	if err != nil {
+		return err
+	}
+	d.Mode = mode
+	if ds.Frames > 0 {
+		d.Frames = ds.Frames
+	}
+	if ds.Decay > 0 && ds.Decay < 1 {
+		d.Decay = ds.Decay
+	}
+	return nil
+}
+
+// Settings of every ROM, keyed by ROM file name.
+type Settings map[string]RomSettings
+
+// SettingsPath is where the settings are kept, under the user config
+// directory.
+func SettingsPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ebiten8", "settings.json"), nil
+}
+
+// Read a settings file. A missing or empty file yields empty settings. A
+// file that cannot be decoded is renamed aside with a ".bad" suffix, so that
+// saving new settings does not overwrite it, and yields empty settings and
+// an error.
+func LoadSettings(path string) (Settings, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) || (err == nil && len(bytes.TrimSpace(data)) == 0) {
+		return Settings{}, nil
+	} else if err != nil {
+		return Settings{}, err
+	}
+	settings := Settings{}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		if renameErr := os.Rename(path, path+".bad"); renameErr != nil {
+			return Settings{}, fmt.Errorf("%s: %v, and it could not be moved aside: %v", path, err, renameErr)
+		}
+		return Settings{}, fmt.Errorf("%s: %v, moved it to %s.bad", path, err, path)
+	}
+	// A file holding null leaves no map to add ROMs to.
+	if settings == nil {
+		settings = Settings{}
+	}
+	return settings, nil
+}
+
+func (s Settings) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// Remember the palette of a ROM.
+func (s Settings) SetPalette(rom string, p Palette) {
+	rs := s[rom]
+	rs.Palette = &p
+	s[rom] = rs
+}
+
+// Remember the flicker reduction of a ROM.
+func (s Settings) SetDisplay(rom string, d *Display) {
+	rs := s[rom]
+	rs.Display = &DisplaySettings{d.Mode.String(), d.Frames, d.Decay}
+	s[rom] = rs
+}
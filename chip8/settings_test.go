@@ -0,0 +1,97 @@
+package chip8
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSettingsPerROM(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ebiten8", "settings.json")
+	settings, err := LoadSettings(path)
+	if err != nil || len(settings) != 0 {
+		t.Fatalf("missing file loaded as %v, %v", settings, err)
+	}
+
+	amber, _ := FindPalette("Amber")
+	settings.SetPalette("PONG", amber)
+	d := NewDisplay()
+	d.Mode, d.Frames = DISPLAY_BLEND, 5
+	settings.SetDisplay("BLINKY", d)
+	if err := settings.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadSettings(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, settings) {
+		t.Errorf("settings read back as %+v, want %+v", loaded, settings)
+	}
+	if p := loaded["PONG"].Palette; p == nil || p.Name != "Amber" || loaded["PONG"].Display != nil {
+		t.Errorf("PONG = %+v, want only the Amber palette", loaded["PONG"])
+	}
+	if _, ok := loaded["TETRIS"]; ok {
+		t.Error("a ROM without settings has some")
+	}
+
+	d = NewDisplay()
+	if err := loaded["BLINKY"].Display.Apply(d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Mode != DISPLAY_BLEND || d.Frames != 5 {
+		t.Errorf("display = %v for %d frames, want blend for 5", d.Mode, d.Frames)
+	}
+}
+
+func TestLoadSettingsNull(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.json")
+	if err := ioutil.WriteFile(path, []byte("null"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	settings, err := LoadSettings(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Remembering a palette must not panic.
+	settings.SetPalette("PONG", PALETTES[0])
+	if settings["PONG"].Palette == nil {
+		t.Error("palette not remembered")
+	}
+}
+
+func TestLoadSettingsErrors(t *testing.T) {
+	for _, data := range []string{
+		`{"PONG": {"palette": {"name": "X", "colors": ["#000"]}}}`,
+		`{"PONG": {"palette"`,
+	} {
+		path := filepath.Join(t.TempDir(), "settings.json")
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		settings, err := LoadSettings(path)
+		if err == nil || settings == nil || len(settings) != 0 {
+			t.Errorf("LoadSettings(%s) = %v, %v, want empty settings and an error", data, settings, err)
+		}
+		// The bad file is kept aside rather than overwritten by the next save.
+		if kept, err := ioutil.ReadFile(path + ".bad"); err != nil || string(kept) != data {
+			t.Errorf("bad file kept as %q, %v", kept, err)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("bad file still in place: %v", err)
+		}
+	}
+}
+
+func TestLoadSettingsEmpty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.json")
+	if err := ioutil.WriteFile(path, []byte("\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if settings, err := LoadSettings(path); err != nil || settings == nil {
+		t.Errorf("empty file loaded as %v, %v", settings, err)
+	}
+}
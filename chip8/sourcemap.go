@@ -0,0 +1,277 @@
+package chip8
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SourceMap links the addresses of a program to the labels and lines of
+// the source it was assembled from.
+//
+// Its symbol file has one entry per line: the source file, the address of
+// each label, the addresses assembled from each source line, and the text
+// of those lines. Addresses are hex. "#" starts a comment when it begins a
+// line.
+//
+//	source /home/me/game.8o
+//	label 200 main
+//	line 200-201 2
+//	text 2 	v0 := 0 # Count from zero.
+type SourceMap struct {
+	Path   string            // Source file.
+	Lines  map[uint16]int    // Line of the byte at each address, from 1.
+	Labels map[string]uint16 // Address of each label.
+	Text   map[int]string    // Source of the lines with code or data.
+}
+
+func newSourceMap(path string) *SourceMap {
+	return &SourceMap{Path: path, Lines: map[uint16]int{}, Labels: map[string]uint16{}, Text: map[int]string{}}
+}
+
+// NewSourceMap makes a source map from the labels and lines the assembler
+// recorded by address, keeping the text of the lines they point to.
+func NewSourceMap(path, source string, labels map[string]int, lines map[int]int) *SourceMap {
+	m := newSourceMap(path)
+	text := strings.Split(source, "\n")
+	for addr, line := range lines {
+		m.Lines[uint16(addr)] = line
+		if line >= 1 && line <= len(text) {
+			m.Text[line] = strings.TrimRight(text[line-1], "\r")
+		}
+	}
+	for name, addr := range labels {
+		m.Labels[name] = uint16(addr)
+	}
+	return m
+}
+
+// SymbolsName is the name of the symbol file of a ROM, next to it.
+func SymbolsName(rom string) string {
+	return strings.TrimSuffix(rom, filepath.Ext(rom)) + ".sym"
+}
+
+// Line returns the source line of an address, or 0 if there is none.
+func (m *SourceMap) Line(addr uint16) int {
+	if m == nil {
+		return 0
+	}
+	return m.Lines[addr]
+}
+
+// Address returns the first address assembled from a line. Lines without
+// code, such as comments and labels, move to the next line that has some,
+// which is returned with it.
+func (m *SourceMap) Address(line int) (uint16, int, bool) {
+	if m == nil {
+		return 0, 0, false
+	}
+	found := false
+	var best uint16
+	bestLine := 0
+	for addr, l := range m.Lines {
+		if l < line {
+			continue
+		}
+		if !found || l < bestLine || l == bestLine && addr < best {
+			best, bestLine, found = addr, l, true
+		}
+	}
+	return best, bestLine, found
+}
+
+// Label returns the label defined at an address, the first by name if
+// there are several, or "".
+func (m *SourceMap) Label(addr uint16) string {
+	if m == nil {
+		return ""
+	}
+	label := ""
+	for name, a := range m.Labels {
+		if a == addr && (label == "" || name < label) {
+			label = name
+		}
+	}
+	return label
+}
+
+// Symbol names an address after the closest label at or before it, such as
+// main+4, or returns "" if there is none.
+func (m *SourceMap) Symbol(addr uint16) string {
+	if m == nil {
+		return ""
+	}
+	label := ""
+	var at uint16
+	for name, a := range m.Labels {
+		if a > addr {
+			continue
+		}
+		if label == "" || a > at || a == at && name < label {
+			label, at = name, a
+		}
+	}
+	if label == "" || addr == at {
+		return label
+	}
+	return fmt.Sprintf("%s+%d", label, addr-at)
+}
+
+// Source returns the line of an address and its text without the comment.
+func (m *SourceMap) Source(addr uint16) (int, string) {
+	line := m.Line(addr)
+	if line == 0 {
+		return 0, ""
+	}
+	text := m.Text[line]
+	if i := strings.IndexByte(text, '#'); i >= 0 {
+		text = text[:i]
+	}
+	return line, strings.TrimSpace(text)
+}
+
+// Comment returns the comment on the line of an address, or "".
+func (m *SourceMap) Comment(addr uint16) string {
+	line := m.Line(addr)
+	if line == 0 {
+		return ""
+	}
+	text := m.Text[line]
+	i := strings.IndexByte(text, '#')
+	if i < 0 {
+		return ""
+	}
+	return strings.TrimSpace(text[i+1:])
+}
+
+// Describe shows the symbol and source line of an address, or "".
+func (m *SourceMap) Describe(addr uint16) string {
+	var parts []string
+	if symbol := m.Symbol(addr); symbol != "" {
+		parts = append(parts, symbol)
+	}
+	if line, text := m.Source(addr); line != 0 {
+		parts = append(parts, fmt.Sprintf("%d: %s", line, text))
+	}
+	if comment := m.Comment(addr); comment != "" {
+		parts = append(parts, "# "+comment)
+	}
+	return strings.Join(parts, " ")
+}
+
+func LoadSourceMap(path string) (*SourceMap, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadSourceMap(f)
+}
+
+func ReadSourceMap(r io.Reader) (*SourceMap, error) {
+	m := newSourceMap("")
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("symbols line %d: %q", n, line)
+		}
+		var err error
+		switch fields[0] {
+		case "source":
+			m.Path = strings.Join(fields[1:], " ")
+		case "label":
+			var addr uint64
+			if addr, err = strconv.ParseUint(fields[1], 16, 16); err == nil && len(fields) == 3 {
+				m.Labels[fields[2]] = uint16(addr)
+			}
+		case "line":
+			var first, last uint16
+			var l int
+			if first, last, err = ParseAddressRange(fields[1]); err == nil && len(fields) == 3 {
+				l, err = strconv.Atoi(fields[2])
+				for addr := int(first); addr <= int(last) && err == nil; addr++ {
+					m.Lines[uint16(addr)] = l
+				}
+			}
+		case "text":
+			var l int
+			if l, err = strconv.Atoi(fields[1]); err == nil {
+				m.Text[l] = strings.Join(fields[2:], "")
+			}
+		default:
+			err = fmt.Errorf("unknown entry %q", fields[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("symbols line %d: %v", n, err)
+		}
+	}
+	return m, scanner.Err()
+}
+
+func (m *SourceMap) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := m.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Write writes the symbol file, with the entries sorted by address.
+func (m *SourceMap) Write(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "source %s\n", m.Path)
+
+	names := make([]string, 0, len(m.Labels))
+	for name := range m.Labels {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := m.Labels[names[i]], m.Labels[names[j]]
+		return a < b || a == b && names[i] < names[j]
+	})
+	for _, name := range names {
+		fmt.Fprintf(b, "label %03X %s\n", m.Labels[name], name)
+	}
+
+	addrs := make([]int, 0, len(m.Lines))
+	for addr := range m.Lines {
+		addrs = append(addrs, int(addr))
+	}
+	sort.Ints(addrs)
+	for n := 0; n < len(addrs); {
+		first, line := addrs[n], m.Lines[uint16(addrs[n])]
+		last := first
+		for n++; n < len(addrs) && addrs[n] == last+1 && m.Lines[uint16(addrs[n])] == line; n++ {
+			last++
+		}
+		if first == last {
+			fmt.Fprintf(b, "line %03X %d\n", first, line)
+		} else {
+			fmt.Fprintf(b, "line %03X-%03X %d\n", first, last, line)
+		}
+	}
+
+	lines := make([]int, 0, len(m.Text))
+	for line := range m.Text {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+	for _, line := range lines {
+		fmt.Fprintf(b, "text %d %s\n", line, m.Text[line])
+	}
+	return b.Flush()
+}
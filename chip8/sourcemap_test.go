@@ -0,0 +1,82 @@
+package chip8
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yukinarit/ebiten8/octo"
+)
+
+const symbolSource = `: main
+	v0 := 0 # Count from zero.
+	loop
+		v0 += 1
+		count
+	again
+
+: count
+	v1 += 2
+	return
+`
+
+func symbolMap(t *testing.T) *SourceMap {
+	prog, err := octo.Compile(symbolSource, 0xE00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewSourceMap("/src/count.8o", symbolSource, prog.Labels, prog.Lines)
+}
+
+func TestSourceMapSymbols(t *testing.T) {
+	m := symbolMap(t)
+	tests := []struct {
+		addr    uint16
+		symbol  string
+		line    int
+		source  string
+		comment string
+	}{
+		{0x200, "main", 2, "v0 := 0", "Count from zero."},
+		{0x202, "main+2", 4, "v0 += 1", ""},
+		{0x208, "count", 9, "v1 += 2", ""},
+		{0x20A, "count+2", 10, "return", ""},
+	}
+	for _, test := range tests {
+		line, source := m.Source(test.addr)
+		if symbol := m.Symbol(test.addr); symbol != test.symbol || line != test.line || source != test.source || m.Comment(test.addr) != test.comment {
+			t.Errorf("%03X: %s %d %q %q", test.addr, symbol, line, source, m.Comment(test.addr))
+		}
+	}
+	if got := m.Describe(0x200); got != "main 2: v0 := 0 # Count from zero." {
+		t.Errorf("Describe %q", got)
+	}
+	var none *SourceMap
+	if none.Symbol(0x200) != "" || none.Describe(0x200) != "" || none.Label(0x200) != "" {
+		t.Errorf("a nil source map has symbols")
+	}
+}
+
+func TestSourceMapFile(t *testing.T) {
+	m := symbolMap(t)
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"source /src/count.8o\n", "label 200 main\n", "line 200-201 2\n", "text 2 \tv0 := 0 # Count from zero.\n"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("no %q in\n%s", want, buf.String())
+		}
+	}
+	read, err := ReadSourceMap(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, m) {
+		t.Errorf("read %+v, want %+v", read, m)
+	}
+	if _, err := ReadSourceMap(strings.NewReader("line 2G0 4\n")); err == nil || !strings.Contains(err.Error(), "symbols line 1") {
+		t.Errorf("invalid address: %v", err)
+	}
+}
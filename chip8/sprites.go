@@ -0,0 +1,178 @@
+package chip8
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// Sprite is memory a program drew with Dxyn.
+type Sprite struct {
+	Addr   uint16
+	Height int // Rows of 8 pixels, a byte each.
+	Draws  int // Times it was drawn.
+}
+
+// SpriteLog collects the sprites a Cpu draws, set as its Sprites. Each
+// address is one sprite, as tall as the tallest draw from it.
+type SpriteLog struct {
+	sprites map[uint16]*Sprite
+}
+
+func NewSpriteLog() *SpriteLog {
+	return &SpriteLog{sprites: map[uint16]*Sprite{}}
+}
+
+func (l *SpriteLog) draw(addr uint16, height int) {
+	s, ok := l.sprites[addr]
+	if !ok {
+		s = &Sprite{Addr: addr}
+		l.sprites[addr] = s
+	}
+	if height > s.Height {
+		s.Height = height
+	}
+	s.Draws++
+}
+
+// Sprites returns the sprites drawn so far, by address.
+func (l *SpriteLog) Sprites() []Sprite {
+	sprites := make([]Sprite, 0, len(l.sprites))
+	for _, s := range l.sprites {
+		if s.Height > 0 {
+			sprites = append(sprites, *s)
+		}
+	}
+	sort.Slice(sprites, func(i, j int) bool { return sprites[i].Addr < sprites[j].Addr })
+	return sprites
+}
+
+// Data returns the bytes of the sprite in ram.
+func (s Sprite) Data(ram []byte) []byte {
+	end := int(s.Addr) + s.Height
+	if end > len(ram) {
+		end = len(ram)
+	}
+	return ram[s.Addr:end]
+}
+
+// SpriteImage draws the rows of a sprite, scaled up by a whole factor, in
+// white on black.
+func SpriteImage(data []byte, scale int) *image.Paletted {
+	if scale < 1 {
+		scale = 1
+	}
+	img := image.NewPaletted(image.Rect(0, 0, 8*scale, len(data)*scale), color.Palette{color.Black, color.White})
+	for y := 0; y < len(data)*scale; y++ {
+		for x := 0; x < 8*scale; x++ {
+			if data[y/scale]&(0x80>>(x/scale)) != 0 {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+	return img
+}
+
+// SpriteBytes reads the rows of a sprite back from an image drawn by
+// SpriteImage, which may have been edited. The scale is found from the
+// width, and pixels brighter than half are set.
+func SpriteBytes(img image.Image) ([]byte, error) {
+	b := img.Bounds()
+	if b.Dx() == 0 || b.Dx()%8 != 0 || b.Dy()%(b.Dx()/8) != 0 {
+		return nil, fmt.Errorf("sprite image of %dx%d is not 8 pixels wide scaled by a whole factor", b.Dx(), b.Dy())
+	}
+	scale := b.Dx() / 8
+	data := make([]byte, b.Dy()/scale)
+	for row := range data {
+		for bit := 0; bit < 8; bit++ {
+			c := img.At(b.Min.X+bit*scale+scale/2, b.Min.Y+row*scale+scale/2)
+			gray := color.GrayModel.Convert(c).(color.Gray)
+			if _, _, _, a := c.RGBA(); a >= 0x8000 && gray.Y >= 0x80 {
+				data[row] |= 0x80 >> bit
+			}
+		}
+	}
+	return data, nil
+}
+
+// spriteName matches the names SpriteName makes.
+var spriteName = regexp.MustCompile(`-sprite-([0-9A-Fa-f]{3})-([0-9]+)\.png$`)
+
+// SpriteName names the image of a sprite after the ROM and its address and
+// height, e.g. "Pong_1_player-sprite-2EA-6.png", so it can be patched back.
+func SpriteName(rom string, s Sprite) string {
+	return fmt.Sprintf("%s-sprite-%03X-%d.png", baseName(rom), s.Addr, s.Height)
+}
+
+// SpritesDir names the directory for the sprites of a ROM, e.g.
+// "Pong_1_player-sprites".
+func SpritesDir(rom string) string {
+	return baseName(rom) + "-sprites"
+}
+
+// ParseSpriteName returns the address and height in a name from SpriteName.
+func ParseSpriteName(path string) (Sprite, error) {
+	m := spriteName.FindStringSubmatch(filepath.Base(path))
+	if m == nil {
+		return Sprite{}, fmt.Errorf("%q is not named like NAME-sprite-ADDR-HEIGHT.png", path)
+	}
+	addr, _ := strconv.ParseUint(m[1], 16, 16)
+	height, _ := strconv.Atoi(m[2])
+	return Sprite{Addr: uint16(addr), Height: height}, nil
+}
+
+// SaveSprite writes the image of a sprite to a PNG file.
+func SaveSprite(path string, data []byte, scale int) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, SpriteImage(data, scale)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadSprite reads the rows of a sprite from a PNG file named by
+// SpriteName, checking that it still has the height of the name.
+func LoadSprite(path string) (Sprite, []byte, error) {
+	s, err := ParseSpriteName(path)
+	if err != nil {
+		return s, nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return s, nil, err
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		return s, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	data, err := SpriteBytes(img)
+	if err != nil {
+		return s, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(data) != s.Height {
+		return s, nil, fmt.Errorf("%s: %d rows, want %d", path, len(data), s.Height)
+	}
+	return s, data, nil
+}
+
+// PatchSprite writes the rows of a sprite into a ROM image, which is loaded
+// at PROGRAM_START.
+func PatchSprite(rom []byte, addr uint16, data []byte) error {
+	offset := int(addr) - PROGRAM_START
+	if offset < 0 || offset+len(data) > len(rom) {
+		return fmt.Errorf("sprite of %d rows at %03X: %w", len(data), addr, ErrMemory)
+	}
+	copy(rom[offset:], data)
+	return nil
+}
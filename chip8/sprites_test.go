@@ -0,0 +1,86 @@
+package chip8
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"path/filepath"
+	"testing"
+)
+
+// spriteProgram draws the sprite at 20C twice and the font digit 0 once.
+var spriteProgram = []byte{
+	0xA2, 0x0C, // 200 LD I, 20C
+	0xD0, 0x13, // 202 DRW v0, v1, 3
+	0xD0, 0x15, // 204 DRW v0, v1, 5
+	0xA0, 0x00, // 206 LD I, 000
+	0xD0, 0x15, // 208 DRW v0, v1, 5
+	0x12, 0x0A, // 20A JP 20A
+	0x3C, 0x42, 0x81, 0x42, 0x3C, // 20C
+}
+
+func TestSpriteLog(t *testing.T) {
+	sys := lockstepSystem(t, spriteProgram, QUIRK_PROFILES["modern"])
+	sys.Cpu.Sprites = NewSpriteLog()
+	for n := 0; n < 6; n++ {
+		if err := sys.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sprites := sys.Cpu.Sprites.Sprites()
+	want := []Sprite{{0x000, 5, 1}, {0x20C, 5, 2}}
+	if len(sprites) != len(want) || sprites[0] != want[0] || sprites[1] != want[1] {
+		t.Fatalf("sprites %v, want %v", sprites, want)
+	}
+	if data := sprites[1].Data(sys.RAM()); !bytes.Equal(data, spriteProgram[0xC:]) {
+		t.Errorf("data % X", data)
+	}
+}
+
+func TestSpriteImage(t *testing.T) {
+	data := []byte{0x3C, 0x42, 0x81, 0x42, 0x3C}
+	img := SpriteImage(data, 3)
+	if b := img.Bounds(); b.Dx() != 24 || b.Dy() != 15 {
+		t.Errorf("image of %v", b)
+	}
+	if img.ColorIndexAt(6, 0) != 1 || img.ColorIndexAt(5, 0) != 0 {
+		t.Errorf("pixels of the first row")
+	}
+	got, err := SpriteBytes(img)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("read back % X, %v", got, err)
+	}
+	if _, err := SpriteBytes(SpriteImage(data, 1).SubImage(image.Rect(0, 0, 6, 5))); err == nil {
+		t.Errorf("read a sprite 6 pixels wide")
+	}
+}
+
+func TestSpriteFiles(t *testing.T) {
+	s := Sprite{Addr: 0x2EA, Height: 6}
+	path := filepath.Join(t.TempDir(), SpriteName("roms/Pong (1 player).ch8", s))
+	if filepath.Base(path) != "Pong_1_player-sprite-2EA-6.png" || SpritesDir("roms/Pong (1 player).ch8") != "Pong_1_player-sprites" {
+		t.Errorf("name %s", filepath.Base(path))
+	}
+	data := []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0xFF}
+	if err := SaveSprite(path, data, 4); err != nil {
+		t.Fatal(err)
+	}
+	got, read, err := LoadSprite(path)
+	if err != nil || got.Addr != s.Addr || got.Height != s.Height || !bytes.Equal(read, data) {
+		t.Errorf("loaded %v % X %v", got, read, err)
+	}
+	if _, err := ParseSpriteName("pong.png"); err == nil {
+		t.Errorf("parsed a name without an address")
+	}
+
+	rom := make([]byte, 0x100)
+	if err := PatchSprite(rom, 0x2FA, data); err != nil || !bytes.Equal(rom[0xFA:], data) {
+		t.Errorf("patched % X, %v", rom[0xFA:], err)
+	}
+	if err := PatchSprite(rom, 0x2FB, data); !errors.Is(err, ErrMemory) {
+		t.Errorf("patch past the end: %v", err)
+	}
+	if err := PatchSprite(rom, 0x000, data); !errors.Is(err, ErrMemory) {
+		t.Errorf("patch of the font: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package chip8
+
+// TICKRATE is the default number of instructions executed per frame.
+const TICKRATE = 13
+
+// System is a complete CHIP-8 machine. It runs without a window; the GUI
+// and headless tools drive it one 60 Hz frame at a time.
+type System struct {
+	Cpu      *Cpu
+	Mem      *Memory
+	Vme      *VideoMemory
+	Kb       *Keyboard
+	Audio    Audio   // May be nil to run silently.
+	Replay   *Replay // Scripted input replacing the keyboard, if any.
+	Tickrate int     // Instructions per frame with TIMING_TICKRATE.
+	Timing   Timing
+	Frame    int // Frames run so far.
+	buzzing  bool
+	inFrame  bool // A frame has begun and not ended.
+	ticks    int  // Instructions executed in the frame.
+	cycles   int  // VIP cycles left in the frame.
+}
+
+func NewSystem() *System {
+	return &System{
+		Cpu:      NewCpu(),
+		Mem:      NewMemory(),
+		Vme:      NewVideoMemory(),
+		Kb:       NewKeyboard(),
+		Tickrate: TICKRATE,
+	}
+}
+
+// RunFrame executes one frame worth of instructions and then counts the
+// timers down. The frame ends early if Dxyn waits for the next one.
+func (s *System) RunFrame() error {
+	for frame := s.Frame; s.Frame == frame; {
+		if _, err := s.tick(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Step executes one instruction. Frames begin and end as they would under
+// RunFrame, so that stepping through a frame has the same effect.
+func (s *System) Step() error {
+	executed, err := s.tick()
+	if err == nil && !executed {
+		// Dxyn waited for the next frame, where it executes first.
+		_, err = s.tick()
+	}
+	return err
+}
+
+// tick executes the next instruction of the frame, beginning the frame if
+// needed, and ends the frame once its instructions are used up. With
+// TIMING_VIP an instruction that overruns the frame is paid for by the
+// next one. It reports false if Dxyn waited for the next frame instead.
+func (s *System) tick() (bool, error) {
+	if !s.inFrame {
+		s.beginFrame()
+	}
+	var op uint16
+	var pc uint16
+	var vx uint8
+	if s.Timing == TIMING_VIP {
+		pc = s.Cpu.pc
+		op = s.Mem.opcode(pc)
+		vx = s.Cpu.v[op>>8&0xF]
+	}
+	if err := s.Cpu.Tick(s.Mem, s.Vme, s.Kb); err != nil {
+		return false, err
+	}
+	if s.Cpu.Waiting() {
+		// The rest of the frame is spent waiting for the interrupt.
+		s.cycles = 0
+		s.endFrame()
+		return false, nil
+	}
+	s.ticks++
+	if s.Timing == TIMING_VIP {
+		s.cycles -= vipCycles(op, vx, s.Cpu.pc == pc+4)
+		if s.cycles <= 0 {
+			s.endFrame()
+		}
+	} else if s.ticks >= s.Tickrate {
+		s.endFrame()
+	}
+	return true, nil
+}
+
+// beginFrame presses the keys of the replay and starts the frame.
+func (s *System) beginFrame() {
+	if s.Replay != nil {
+		for _, key := range s.Replay.Keys(s.Frame) {
+			s.Kb.Push(key)
+		}
+	}
+	s.Cpu.VBlank()
+	s.ticks = 0
+	if s.Timing == TIMING_VIP {
+		s.cycles += VIP_CYCLES_PER_FRAME - VIP_DISPLAY_CYCLES
+	}
+	s.inFrame = true
+}
+
+// endFrame counts the timers down at the end of a frame.
+func (s *System) endFrame() {
+	s.buzzing = s.Cpu.st > 0
+	s.Cpu.TickTimers(s.Audio)
+	if s.Cpu.Trace != nil {
+		s.Cpu.Trace.frame(s.Frame)
+	}
+	s.Frame++
+	s.inFrame = false
+}
+
+// Buzzing reports whether the sound timer was active during the last frame.
+func (s *System) Buzzing() bool {
+	return s.buzzing
+}
@@ -0,0 +1,147 @@
+package chip8
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Timing selects how many instructions a frame executes.
+type Timing int
+
+const (
+	TIMING_TICKRATE Timing = iota // System.Tickrate instructions per frame.
+	TIMING_VIP                    // As many as the COSMAC VIP interpreter runs in a frame.
+)
+
+var timingNames = []string{"tickrate", "vip"}
+
+func (t Timing) String() string {
+	if int(t) < len(timingNames) {
+		return timingNames[t]
+	}
+	return fmt.Sprintf("Timing(%d)", int(t))
+}
+
+func ParseTiming(name string) (Timing, error) {
+	for n, s := range timingNames {
+		if strings.EqualFold(s, name) {
+			return Timing(n), nil
+		}
+	}
+	return TIMING_TICKRATE, fmt.Errorf("unknown timing %q", name)
+}
+
+// COSMAC VIP timing in 1802 machine cycles of 8 clocks. The 1.76 MHz clock
+// gives 3668 cycles per 60 Hz frame. The 1861 takes 1024 of them for the
+// display DMA of 128 lines of 8 bytes, and the interrupt routine which
+// counts the timers down takes some more.
+const (
+	VIP_CYCLES_PER_FRAME = 3668
+	VIP_DISPLAY_CYCLES   = 1024 + 46
+	VIP_FETCH_CYCLES     = 68 // Fetching and dispatching an instruction.
+	VIP_CLEAR_CYCLES     = 6  // A round of the 00E0 loop, per display byte.
+	VIP_SHIFT_CYCLES     = 8  // A round of the Dxyn loop shifting a row by a bit.
+)
+
+// vipCycles approximates the machine cycles the VIP interpreter spends on an
+// instruction, given vx before it and whether it skipped the next one. The
+// routines are those of the interpreter listing in the RCA COSMAC VIP
+// Instruction Manual (VIP-311), as disassembled in Laurence Scotford's
+// "Chip-8 on the COSMAC VIP" articles. An 1802 instruction takes two
+// machine cycles, so loops cost twice their instructions per round; the
+// fixed part of each routine is an estimate, not a count of the listing.
+func vipCycles(op uint16, vx uint8, skipped bool) int {
+	x := op >> 8 & 0xF
+
+	cycles := VIP_FETCH_CYCLES
+	// A taken skip adds the two cycles of each of the branch and the extra
+	// increment of the program counter.
+	skip := func(base int) int {
+		if skipped {
+			return base + 4
+		}
+		return base
+	}
+	switch op >> 12 {
+	case 0x0:
+		switch op {
+		case 0x00E0:
+			// Sets up the display page, then stores, decrements and tests
+			// its way down the 256 display bytes.
+			cycles += 24 + 256*VIP_CLEAR_CYCLES
+		case 0x00EE:
+			// Pops the return address off the stack.
+			cycles += 10
+		default:
+			// Calls the machine code subroutine, counting only the call.
+			cycles += 12
+		}
+	case 0x1:
+		// Loads both bytes of the program counter.
+		cycles += 12
+	case 0x2:
+		// Pushes the program counter, then jumps like 1nnn.
+		cycles += 26
+	case 0x3, 0x4:
+		// Compares vx with the immediate byte.
+		cycles += skip(10)
+	case 0x5, 0x9:
+		// Points at vy as well before comparing.
+		cycles += skip(14)
+	case 0x6:
+		// Stores the immediate byte through the pointer to vx.
+		cycles += 6
+	case 0x7:
+		// Loads vx, adds the immediate byte and stores it back.
+		cycles += 10
+	case 0x8:
+		if op&0xF == 0 {
+			// Copies vy to vx.
+			cycles += 12
+		} else {
+			// Builds the arithmetic instruction in RAM and calls it, which
+			// also stores the carry into vF.
+			cycles += 44
+		}
+	case 0xA:
+		// Loads both bytes of i.
+		cycles += 12
+	case 0xB:
+		// Adds v0 to the address and jumps like 1nnn.
+		cycles += 22
+	case 0xC:
+		// Steps the random number generator and masks the result.
+		cycles += 36
+	case 0xD:
+		// Sets up the display address and vF, then shifts every row right
+		// one bit per round, x mod 8 rounds, and XORs it onto one display
+		// byte, or two if the sprite is not byte aligned.
+		rows := int(op & 0xF)
+		shift := int(vx % 8)
+		perRow := 24 + shift*VIP_SHIFT_CYCLES
+		if shift != 0 {
+			perRow += 20
+		}
+		cycles += 26 + rows*perRow
+	case 0xE:
+		// Reads the keypad latch for the key in vx.
+		cycles += skip(14)
+	case 0xF:
+		switch op & 0xFF {
+		case 0x33:
+			// Divides by repeated subtraction, one round for every unit of
+			// every digit.
+			cycles += 84 + 16*(int(vx)/100+int(vx)/10%10+int(vx)%10)
+		case 0x55, 0x65:
+			// Copies the registers one by one.
+			cycles += 14 + 14*int(x+1)
+		case 0x1E, 0x29:
+			// Adds to both bytes of i, or looks up the font address.
+			cycles += 16
+		default:
+			// Copies one byte between vx and a timer or the key latch.
+			cycles += 10
+		}
+	}
+	return cycles
+}
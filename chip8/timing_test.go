@@ -0,0 +1,75 @@
+package chip8
+
+import "testing"
+
+// TestVIPTiming counts the instructions of a frame: 7001 costs 78 cycles
+// and 1200 costs 80, and the instruction that overruns the budget still
+// executes.
+func TestVIPTiming(t *testing.T) {
+	sys := NewSystem()
+	sys.Timing = TIMING_VIP
+	sys.Mem.LoadBytes([]byte{0x70, 0x01, 0x12, 0x00})
+	if err := sys.RunFrame(); err != nil {
+		t.Fatal(err)
+	}
+	budget := VIP_CYCLES_PER_FRAME - VIP_DISPLAY_CYCLES
+	want := budget/158 + 1
+	if got := int(sys.Cpu.v[0]); got != want {
+		t.Errorf("%d instructions of 7001 in a frame, want %d", got, want)
+	}
+}
+
+// TestVIPCycles checks whole instructions, fetch included, against their
+// counts in cycles: 00E0 clears the page a byte at a time, and each row of
+// Dxyn costs more the further it is shifted.
+func TestVIPCycles(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		op     uint16
+		before cpuState
+		want   int
+	}{
+		{"00E0", 0x00E0, cpuState{}, 1628},
+		{"6xkk", 0x6012, cpuState{}, 74},
+		{"7xkk", 0x7012, cpuState{}, 78},
+		{"1nnn", 0x1200, cpuState{}, 80},
+		{"3xkk not taken", 0x3012, cpuState{}, 78},
+		{"3xkk taken", 0x3000, cpuState{}, 82},
+		{"Dxy5 aligned", 0xD015, cpuState{v: regs(0, 8)}, 214},
+		{"DxyF aligned", 0xD01F, cpuState{v: regs(0, 8)}, 454},
+		{"Dxy5 shifted by 1", 0xD015, cpuState{v: regs(0, 9)}, 354},
+		{"Dxy5 shifted by 7", 0xD015, cpuState{v: regs(0, 15)}, 594},
+		{"Fx33", 0xF033, cpuState{v: regs(0, 123), i: 0x300}, 248},
+		{"Fx55", 0xF355, cpuState{i: 0x300}, 138},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sys := NewSystem()
+			copy(sys.Cpu.v[:], tc.before.v[:])
+			sys.Cpu.i = tc.before.i
+			sys.Mem.buf[0x200], sys.Mem.buf[0x201] = byte(tc.op>>8), byte(tc.op)
+			sys.Cpu.VBlank()
+			vx := sys.Cpu.v[tc.op>>8&0xF]
+			if err := sys.Cpu.Tick(sys.Mem, sys.Vme, sys.Kb); err != nil {
+				t.Fatal(err)
+			}
+			if got := vipCycles(tc.op, vx, sys.Cpu.pc == 0x204); got != tc.want {
+				t.Errorf("%d cycles, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestVIPClearFrame checks that 00E0 takes most of a frame: a loop which
+// clears the screen and counts in v0 goes round once, the second clear
+// overrunning the frame.
+func TestVIPClearFrame(t *testing.T) {
+	sys := NewSystem()
+	sys.Timing = TIMING_VIP
+	sys.Mem.LoadBytes([]byte{0x00, 0xE0, 0x70, 0x01, 0x12, 0x00})
+	if err := sys.RunFrame(); err != nil {
+		t.Fatal(err)
+	}
+	if v0, pc := sys.Cpu.v[0], sys.Cpu.pc; v0 != 1 || pc != 0x202 {
+		t.Errorf("v0 = %d and pc %03X after a frame, want 1 and 202", v0, pc)
+	}
+}
@@ -0,0 +1,293 @@
+package chip8
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// TraceLevel selects what a Tracer records. Every level includes the ones
+// before it.
+type TraceLevel int
+
+const (
+	TRACE_OFF          TraceLevel = iota
+	TRACE_EVENTS                  // Frames, decoding, waits and errors.
+	TRACE_OPCODES                 // The address, opcode and name of every instruction.
+	TRACE_INSTRUCTIONS            // The registers before every instruction.
+)
+
+var traceLevelNames = []string{"off", "events", "opcodes", "instructions"}
+
+func (l TraceLevel) String() string {
+	if int(l) < len(traceLevelNames) {
+		return traceLevelNames[l]
+	}
+	return fmt.Sprintf("TraceLevel(%d)", int(l))
+}
+
+func ParseTraceLevel(name string) (TraceLevel, error) {
+	for n, s := range traceLevelNames {
+		if strings.EqualFold(s, name) {
+			return TraceLevel(n), nil
+		}
+	}
+	return TRACE_OFF, fmt.Errorf("unknown trace level %q", name)
+}
+
+// TraceFormat is the output format of a Tracer.
+type TraceFormat int
+
+const (
+	TRACE_TEXT TraceFormat = iota // One line per record.
+	TRACE_JSON                    // One JSON object per line.
+	TRACE_CSV                     // One row per instruction, for ReadTrace.
+)
+
+var traceFormatNames = []string{"text", "json", "csv"}
+
+func (f TraceFormat) String() string {
+	if int(f) < len(traceFormatNames) {
+		return traceFormatNames[f]
+	}
+	return fmt.Sprintf("TraceFormat(%d)", int(f))
+}
+
+func ParseTraceFormat(name string) (TraceFormat, error) {
+	for n, s := range traceFormatNames {
+		if strings.EqualFold(s, name) {
+			return TraceFormat(n), nil
+		}
+	}
+	return TRACE_TEXT, fmt.Errorf("unknown trace format %q", name)
+}
+
+// OpcodeFilter matches the opcodes whose bits under Mask equal Value.
+type OpcodeFilter struct {
+	Mask, Value uint16
+}
+
+// ParseOpcodeFilter reads a pattern of four hex digits in which the letters
+// n, k, x, y and _ match any digit, such as 8xy4, Dxyn or Fx55.
+func ParseOpcodeFilter(pattern string) (OpcodeFilter, error) {
+	var f OpcodeFilter
+	if len(pattern) != 4 {
+		return f, fmt.Errorf("opcode pattern %q is not 4 digits", pattern)
+	}
+	for _, c := range strings.ToLower(pattern) {
+		f.Mask <<= 4
+		f.Value <<= 4
+		if strings.ContainsRune("nkxy_", c) {
+			continue
+		}
+		d, err := strconv.ParseUint(string(c), 16, 4)
+		if err != nil {
+			return f, fmt.Errorf("opcode pattern %q: %q is not a hex digit", pattern, c)
+		}
+		f.Mask |= 0xF
+		f.Value |= uint16(d)
+	}
+	return f, nil
+}
+
+func (f OpcodeFilter) Match(op uint16) bool {
+	return op&f.Mask == f.Value
+}
+
+// ParseAddressRange reads an inclusive range of hex addresses such as
+// 200-2FF, or a single address.
+func ParseAddressRange(s string) (uint16, uint16, error) {
+	parts := strings.SplitN(s, "-", 2)
+	from, err := strconv.ParseUint(parts[0], 16, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("address range %q: %v", s, err)
+	}
+	to := from
+	if len(parts) == 2 {
+		if to, err = strconv.ParseUint(parts[1], 16, 16); err != nil {
+			return 0, 0, fmt.Errorf("address range %q: %v", s, err)
+		}
+	}
+	return uint16(from), uint16(to), nil
+}
+
+// Tracer records the execution of a Cpu, set as its Trace. A Cpu without
+// one only pays for checking that it is nil.
+type Tracer struct {
+	Level   TraceLevel
+	Format  TraceFormat
+	From    uint16         // Instructions are traced from this address
+	To      uint16         // up to this one.
+	Opcodes []OpcodeFilter // If any, only matching instructions are traced.
+	Symbols *SourceMap     // If set, names the instructions after their source.
+	w       io.Writer
+	enc     *json.Encoder
+	err     error
+	cycle   int        // Instructions executed so far.
+	entry   TraceEntry // State before the current instruction.
+	header  bool       // The CSV header was written.
+}
+
+func NewTracer(w io.Writer, level TraceLevel, format TraceFormat) *Tracer {
+	return &Tracer{Level: level, Format: format, To: 0xFFFF, w: w, enc: json.NewEncoder(w)}
+}
+
+// Err returns the first error writing the trace.
+func (t *Tracer) Err() error {
+	return t.err
+}
+
+// traceRecord is a line of the trace. Fields that do not apply are left
+// out of JSON.
+type traceRecord struct {
+	Event  string  `json:"event,omitempty"`
+	Frame  *int    `json:"frame,omitempty"`
+	Cycle  *int    `json:"cycle,omitempty"`
+	PC     *uint16 `json:"pc,omitempty"`
+	Opcode *uint16 `json:"opcode,omitempty"`
+	Name   string  `json:"name,omitempty"`
+	V      []uint8 `json:"v,omitempty"`
+	I      *uint16 `json:"i,omitempty"`
+	SP     *uint8  `json:"sp,omitempty"`
+	DT     *uint8  `json:"dt,omitempty"`
+	ST     *uint8  `json:"st,omitempty"`
+	Error  string  `json:"error,omitempty"`
+	Symbol string  `json:"symbol,omitempty"`
+	Line   int     `json:"line,omitempty"`
+	Source string  `json:"source,omitempty"`
+}
+
+func (t *Tracer) write(r traceRecord) {
+	if t.err != nil {
+		return
+	}
+	switch t.Format {
+	case TRACE_CSV:
+		// Only instructions have rows.
+		if r.Event != "" {
+			return
+		}
+		if !t.header {
+			t.header = true
+			if _, t.err = io.WriteString(t.w, TRACE_CSV_HEADER+"\n"); t.err != nil {
+				return
+			}
+		}
+		_, t.err = io.WriteString(t.w, t.entry.csv()+"\n")
+		return
+	case TRACE_JSON:
+		if r.PC != nil {
+			r.Symbol = t.Symbols.Symbol(*r.PC)
+			r.Line, r.Source = t.Symbols.Source(*r.PC)
+		}
+		t.err = t.enc.Encode(r)
+		return
+	}
+	var b strings.Builder
+	if r.Event != "" {
+		b.WriteString(r.Event)
+	}
+	if r.Frame != nil {
+		fmt.Fprintf(&b, " %d", *r.Frame)
+	}
+	if r.PC != nil {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%03X: %04X %s", *r.PC, *r.Opcode, r.Name)
+	}
+	if r.V != nil {
+		fmt.Fprintf(&b, "  v=% X i=%03X sp=%X dt=%02X st=%02X", r.V, *r.I, *r.SP, *r.DT, *r.ST)
+	}
+	if r.Error != "" {
+		fmt.Fprintf(&b, ": %s", r.Error)
+	}
+	if r.PC != nil {
+		if d := t.Symbols.Describe(*r.PC); d != "" {
+			fmt.Fprintf(&b, "  ; %s", d)
+		}
+	}
+	b.WriteByte('\n')
+	_, t.err = io.WriteString(t.w, b.String())
+}
+
+// traced reports whether the instruction at pc is included by the filters.
+func (t *Tracer) traced(pc, op uint16) bool {
+	if pc < t.From || pc > t.To {
+		return false
+	}
+	if len(t.Opcodes) == 0 {
+		return true
+	}
+	for _, f := range t.Opcodes {
+		if f.Match(op) {
+			return true
+		}
+	}
+	return false
+}
+
+// event records something that happened to the instruction at pc, named
+// inst by decode.
+func (t *Tracer) event(name string, pc, op uint16, inst string, err error) {
+	if t.Level < TRACE_EVENTS || !t.traced(pc, op) {
+		return
+	}
+	r := traceRecord{Event: name, PC: &pc, Opcode: &op, Name: mnemonic(inst)}
+	if cpuErr, ok := err.(*CpuError); ok {
+		err = cpuErr.Err
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	t.write(r)
+}
+
+// frame records the end of a frame.
+func (t *Tracer) frame(n int) {
+	if t.Level >= TRACE_EVENTS {
+		t.write(traceRecord{Event: "frame", Frame: &n})
+	}
+}
+
+// fetch saves the state before the instruction at pc executes.
+func (t *Tracer) fetch(cpu *Cpu, pc, op uint16) {
+	t.entry = TraceEntry{
+		Cycle:  t.cycle,
+		PC:     pc,
+		Opcode: op,
+		I:      cpu.i,
+		SP:     uint8(cpu.sp),
+		DT:     uint8(cpu.dt),
+		ST:     uint8(cpu.st),
+		Has:    TRACE_ALL,
+	}
+	copy(t.entry.V[:], cpu.v[:16])
+}
+
+// instruction records the instruction at pc, named inst by decode, which
+// executed from the state saved by fetch.
+func (t *Tracer) instruction(pc, op uint16, inst string) {
+	t.cycle++
+	if t.Level < TRACE_OPCODES || !t.traced(pc, op) {
+		return
+	}
+	r := traceRecord{PC: &pc, Opcode: &op, Name: mnemonic(inst)}
+	if t.Level >= TRACE_INSTRUCTIONS {
+		e := &t.entry
+		r.Cycle = &e.Cycle
+		r.V = e.V[:]
+		r.I, r.SP, r.DT, r.ST = &e.I, &e.SP, &e.DT, &e.ST
+	}
+	t.write(r)
+}
+
+// mnemonic drops the pattern decode puts before the name of an instruction.
+func mnemonic(name string) string {
+	if n := strings.IndexByte(name, ' '); n >= 0 {
+		return name[n+1:]
+	}
+	return name
+}
@@ -0,0 +1,175 @@
+package chip8
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// traceProgram loads v0 and v1, draws a sprite and loops.
+var traceProgram = []byte{0x60, 0x05, 0x61, 0x03, 0xD0, 0x15, 0x12, 0x06}
+
+func traceSystem(t *testing.T, tracer *Tracer) *System {
+	sys := lockstepSystem(t, traceProgram, QUIRK_PROFILES["modern"])
+	sys.Cpu.Trace = tracer
+	return sys
+}
+
+func TestTraceText(t *testing.T) {
+	var buf bytes.Buffer
+	sys := traceSystem(t, NewTracer(&buf, TRACE_INSTRUCTIONS, TRACE_TEXT))
+	for n := 0; n < 3; n++ {
+		if err := sys.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{
+		"decode 200: 6005 LD",
+		"200: 6005 LD  v=00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 i=000 sp=0 dt=00 st=00",
+		"decode 202: 6103 LD",
+		"202: 6103 LD  v=05 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 i=000 sp=0 dt=00 st=00",
+		"decode 204: D015 DRW",
+		"204: D015 DRW  v=05 03 00 00 00 00 00 00 00 00 00 00 00 00 00 00 i=000 sp=0 dt=00 st=00",
+	}
+	if got := strings.Split(strings.TrimSpace(buf.String()), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestTraceJSON(t *testing.T) {
+	var buf bytes.Buffer
+	sys := traceSystem(t, NewTracer(&buf, TRACE_OPCODES, TRACE_JSON))
+	if err := sys.RunFrame(); err != nil {
+		t.Fatal(err)
+	}
+	var records []traceRecord
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var r traceRecord
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+	if n := len(records); n != sys.Tickrate+5 {
+		t.Fatalf("%d records, want %d", n, sys.Tickrate+5)
+	}
+	first, last := records[1], records[len(records)-1]
+	if first.Event != "" || *first.PC != 0x200 || *first.Opcode != 0x6005 || first.Name != "LD" || first.V != nil {
+		t.Errorf("first instruction %+v", first)
+	}
+	if last.Event != "frame" || *last.Frame != 0 {
+		t.Errorf("last record %+v, want the end of frame 0", last)
+	}
+}
+
+// TestTraceFilters keeps only the instructions the filters match, but still
+// records frames.
+func TestTraceFilters(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := NewTracer(&buf, TRACE_OPCODES, TRACE_TEXT)
+	tracer.From, tracer.To = 0x202, 0x204
+	filter, err := ParseOpcodeFilter("Dxyn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer.Opcodes = []OpcodeFilter{filter}
+	sys := traceSystem(t, tracer)
+	if err := sys.RunFrame(); err != nil {
+		t.Fatal(err)
+	}
+	want := "decode 204: D015 DRW\n204: D015 DRW\nframe 0\n"
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestTraceOff(t *testing.T) {
+	var buf bytes.Buffer
+	sys := traceSystem(t, NewTracer(&buf, TRACE_OFF, TRACE_TEXT))
+	if err := sys.RunFrame(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("trace off wrote %q", buf.String())
+	}
+}
+
+// TestTraceEvents records the Dxyn wait of the VBlank quirk and errors,
+// without instructions.
+func TestTraceEvents(t *testing.T) {
+	var buf bytes.Buffer
+	sys := lockstepSystem(t, []byte{0x60, 0x00, 0xD0, 0x15, 0x00, 0xEE}, QUIRK_PROFILES["chip8"])
+	sys.Cpu.Trace = NewTracer(&buf, TRACE_EVENTS, TRACE_TEXT)
+	for sys.Step() == nil {
+	}
+	for _, want := range []string{"wait 202: D015 DRW", "frame 0", "error 204: 00EE RET: return with an empty stack"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("trace has no %q:\n%s", want, buf.String())
+		}
+	}
+	if strings.Contains(buf.String(), "\n202:") {
+		t.Errorf("events level traced instructions:\n%s", buf.String())
+	}
+}
+
+func TestParseOpcodeFilter(t *testing.T) {
+	for _, test := range []struct {
+		pattern     string
+		mask, value uint16
+	}{
+		{"Dxyn", 0xF000, 0xD000},
+		{"8xy4", 0xF00F, 0x8004},
+		{"fx55", 0xF0FF, 0xF055},
+		{"00E0", 0xFFFF, 0x00E0},
+	} {
+		f, err := ParseOpcodeFilter(test.pattern)
+		if err != nil || f.Mask != test.mask || f.Value != test.value {
+			t.Errorf("%s: %04X %04X %v, want %04X %04X", test.pattern, f.Mask, f.Value, err, test.mask, test.value)
+		}
+	}
+	for _, pattern := range []string{"D0", "Gxyn", "Dxyn0"} {
+		if _, err := ParseOpcodeFilter(pattern); err == nil {
+			t.Errorf("%s accepted", pattern)
+		}
+	}
+}
+
+// TestTraceSymbols names the traced instructions after their source.
+func TestTraceSymbols(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := NewTracer(&buf, TRACE_OPCODES, TRACE_TEXT)
+	tracer.Symbols = NewSourceMap("trace.8o", ": main\n\tv0 := 5 # Column\n\tv1 := 3\n", map[string]int{"main": 0x200}, map[int]int{0x200: 2, 0x201: 2, 0x202: 3, 0x203: 3})
+	sys := traceSystem(t, tracer)
+	for n := 0; n < 2; n++ {
+		if err := sys.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := "decode 200: 6005 LD  ; main 2: v0 := 5 # Column\n200: 6005 LD  ; main 2: v0 := 5 # Column\n" +
+		"decode 202: 6103 LD  ; main+2 3: v1 := 3\n202: 6103 LD  ; main+2 3: v1 := 3\n"
+	if buf.String() != want {
+		t.Errorf("got\n%swant\n%s", buf.String(), want)
+	}
+}
+
+// BenchmarkTrace traces every instruction with its registers, in each
+// format.
+func BenchmarkTrace(b *testing.B) {
+	for _, format := range []TraceFormat{TRACE_TEXT, TRACE_JSON, TRACE_CSV} {
+		b.Run(format.String(), func(b *testing.B) {
+			sys := NewSystem()
+			sys.LoadBytes([]byte{0x70, 0x01, 0x81, 0x04, 0x12, 0x00})
+			sys.Cpu.Trace = NewTracer(ioutil.Discard, TRACE_INSTRUCTIONS, format)
+			b.ResetTimer()
+			start := time.Now()
+			for n := 0; n < b.N; n++ {
+				sys.Cpu.Tick(sys.Mem, sys.Vme, sys.Kb)
+			}
+			reportSpeed(b, start, b.N)
+		})
+	}
+}
@@ -0,0 +1,357 @@
+package chip8
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Columns of a CSV trace. Numbers are hex except the cycle.
+const TRACE_CSV_HEADER = "cycle,pc,opcode,v0,v1,v2,v3,v4,v5,v6,v7,v8,v9,va,vb,vc,vd,ve,vf,i,sp,dt,st"
+
+// Fields of a TraceEntry, as bits of Has. Traces of other emulators may
+// leave some out.
+const (
+	TRACE_V      = 0xFFFF // One bit for each register from v0.
+	TRACE_I      = 1 << 16
+	TRACE_SP     = 1 << 17
+	TRACE_DT     = 1 << 18
+	TRACE_ST     = 1 << 19
+	TRACE_OPCODE = 1 << 20
+	TRACE_CYCLE  = 1 << 21
+	TRACE_ALL    = 1<<22 - 1
+)
+
+// TraceEntry is the state of the machine before an instruction executes.
+type TraceEntry struct {
+	Line   int // Line of the trace file, from 1.
+	Cycle  int // Instructions executed before it.
+	PC     uint16
+	Opcode uint16
+	V      [16]uint8
+	I      uint16
+	SP     uint8
+	DT, ST uint8
+	Has    uint32
+}
+
+func (e *TraceEntry) csv() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d,%03X,%04X", e.Cycle, e.PC, e.Opcode)
+	for _, v := range e.V {
+		fmt.Fprintf(&b, ",%02X", v)
+	}
+	fmt.Fprintf(&b, ",%03X,%X,%02X,%02X", e.I, e.SP, e.DT, e.ST)
+	return b.String()
+}
+
+// String shows the fields the entry has.
+func (e *TraceEntry) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%03X:", e.PC)
+	if e.Has&TRACE_OPCODE != 0 {
+		_, name := decode(e.Opcode)
+		fmt.Fprintf(&b, " %04X %-6s", e.Opcode, mnemonic(name))
+	}
+	for n, v := range e.V {
+		if e.Has&(1<<n) != 0 {
+			fmt.Fprintf(&b, " v%X=%02X", n, v)
+		}
+	}
+	if e.Has&TRACE_I != 0 {
+		fmt.Fprintf(&b, " i=%03X", e.I)
+	}
+	if e.Has&TRACE_SP != 0 {
+		fmt.Fprintf(&b, " sp=%X", e.SP)
+	}
+	if e.Has&TRACE_DT != 0 {
+		fmt.Fprintf(&b, " dt=%02X", e.DT)
+	}
+	if e.Has&TRACE_ST != 0 {
+		fmt.Fprintf(&b, " st=%02X", e.ST)
+	}
+	return b.String()
+}
+
+// set stores a field by the name a trace gives it. Unknown names are
+// ignored.
+func (e *TraceEntry) set(name, value string, base int) error {
+	name = strings.NewReplacer("[", "", "]", "").Replace(strings.ToLower(name))
+	value = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(value), "0x"), "$")
+	var field uint32
+	var bits int
+	switch name {
+	case "cycle", "cycles", "step", "steps":
+		field, bits, base = TRACE_CYCLE, 64, 10
+	case "pc":
+		bits = 16
+	case "op", "opcode", "instr", "instruction":
+		field, bits = TRACE_OPCODE, 16
+	case "i":
+		field, bits = TRACE_I, 16
+	case "sp":
+		field, bits = TRACE_SP, 8
+	case "dt", "delay":
+		field, bits = TRACE_DT, 8
+	case "st", "sound":
+		field, bits = TRACE_ST, 8
+	default:
+		if len(name) != 2 || name[0] != 'v' {
+			return nil
+		}
+		n, err := strconv.ParseUint(name[1:], 16, 4)
+		if err != nil {
+			return nil
+		}
+		field, bits = 1<<n, 8
+	}
+	n, err := strconv.ParseUint(value, base, bits)
+	if err != nil && field == TRACE_SP {
+		// Some emulators log the address of the stack instead.
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s %q: %v", name, value, err)
+	}
+	switch field {
+	case 0:
+		e.PC = uint16(n)
+	case TRACE_CYCLE:
+		e.Cycle = int(n)
+	case TRACE_OPCODE:
+		e.Opcode = uint16(n)
+	case TRACE_I:
+		e.I = uint16(n)
+	case TRACE_SP:
+		e.SP = uint8(n)
+	case TRACE_DT:
+		e.DT = uint8(n)
+	case TRACE_ST:
+		e.ST = uint8(n)
+	default:
+		for r := range e.V {
+			if field == 1<<r {
+				e.V[r] = uint8(n)
+			}
+		}
+	}
+	e.Has |= field
+	return nil
+}
+
+var (
+	// 200: 6005 at the start of a line, as in the text trace.
+	traceAddress = regexp.MustCompile(`^\s*([0-9A-Fa-f]{3,4}):\s+([0-9A-Fa-f]{4})\b`)
+	// v=05 03 ..., all 16 registers at once.
+	traceRegisters = regexp.MustCompile(`(?i)\bv\s*[:=]\s*\[?((?:(?:0x)?[0-9a-f]{1,2}[\s,]+){15}(?:0x)?[0-9a-f]{1,2})\]?`)
+	// pc=0200, PC: 0x200, V[3]=$1F and the like.
+	traceField = regexp.MustCompile(`(?i)\b([a-z]+(?:\[?[0-9a-f]\]?)?)\s*[:=]\s*((?:0x|\$)?[0-9a-f]+)\b`)
+)
+
+// ReadTrace reads a trace written in the CSV format of the Tracer, or one
+// line per instruction of name=value or name: value pairs, as many
+// emulators log. Values are hex, with or without 0x or $, except for the
+// cycle. Text traces of the Tracer can be read too. Lines without a pc,
+// such as events, are skipped, and so is text after a ;.
+func ReadTrace(r io.Reader) ([]TraceEntry, error) {
+	var entries []TraceEntry
+	var columns []string
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if columns == nil && len(entries) == 0 && isCSVHeader(text) {
+			columns = strings.Split(text, ",")
+			continue
+		}
+		e := TraceEntry{Line: line}
+		found := true
+		var err error
+		if columns != nil {
+			err = e.readCSV(columns, text)
+		} else {
+			found, err = e.readFields(text)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if !found {
+			continue
+		}
+		if e.Has&TRACE_CYCLE == 0 {
+			e.Cycle = len(entries)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// isCSVHeader reports whether a line names the columns of a CSV trace.
+func isCSVHeader(text string) bool {
+	for _, column := range strings.Split(text, ",") {
+		if strings.EqualFold(strings.TrimSpace(column), "pc") {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *TraceEntry) readCSV(columns []string, text string) error {
+	values := strings.Split(text, ",")
+	if len(values) != len(columns) {
+		return fmt.Errorf("%d values for %d columns", len(values), len(columns))
+	}
+	for n, column := range columns {
+		if err := e.set(strings.TrimSpace(column), strings.TrimSpace(values[n]), 16); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readFields reads the pairs on a line, and reports whether it had a pc.
+// Anything after a ; is a comment, such as the source line of a text trace.
+func (e *TraceEntry) readFields(text string) (bool, error) {
+	if n := strings.IndexByte(text, ';'); n >= 0 {
+		text = text[:n]
+	}
+	found := false
+	if m := traceAddress.FindStringSubmatch(text); m != nil {
+		e.set("pc", m[1], 16)
+		e.set("opcode", m[2], 16)
+		found = true
+	}
+	if m := traceRegisters.FindStringSubmatchIndex(text); m != nil {
+		values := strings.FieldsFunc(text[m[2]:m[3]], func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+		for n, value := range values {
+			if err := e.set(fmt.Sprintf("v%x", n), value, 16); err != nil {
+				return false, err
+			}
+		}
+		text = text[:m[0]] + text[m[1]:]
+	}
+	for _, m := range traceField.FindAllStringSubmatch(text, -1) {
+		if err := e.set(m[1], m[2], 16); err != nil {
+			return false, err
+		}
+		if strings.EqualFold(m[1], "pc") {
+			found = true
+		}
+	}
+	return found, nil
+}
+
+// TraceMismatch is the first instruction on which two aligned traces
+// disagree.
+type TraceMismatch struct {
+	A, B   TraceEntry
+	Fields []string     // Names of the fields that differ.
+	Before []TraceEntry // Entries of A the traces agreed on before it.
+}
+
+func (m *TraceMismatch) String() string {
+	var b strings.Builder
+	for _, e := range m.Before {
+		fmt.Fprintf(&b, "    %6d %s\n", e.Line, e.String())
+	}
+	fmt.Fprintf(&b, "  A %6d %s\n", m.A.Line, m.A.String())
+	fmt.Fprintf(&b, "  B %6d %s\n", m.B.Line, m.B.String())
+	fmt.Fprintf(&b, "differ in %s", strings.Join(m.Fields, ", "))
+	return b.String()
+}
+
+// TRACE_ALIGN_WINDOW is how far into one trace DiffTraces looks for the
+// first instruction of the other, to skip what only one emulator logged
+// before the program started.
+const TRACE_ALIGN_WINDOW = 1000
+
+// DiffTraces aligns two traces and returns the first instruction on which
+// they differ, with up to context instructions they agreed on before it,
+// or nil if they agree for as long as both last. It also returns how many
+// instructions were compared.
+//
+// Only fields both traces have are compared, and cycles never are, since
+// emulators count them differently. Where one trace repeats an instruction
+// that the other logged once, such as Fx0A waiting for a key or Dxyn
+// waiting for the frame, the repeats are skipped.
+func DiffTraces(a, b []TraceEntry, context int) (*TraceMismatch, int) {
+	i, j := alignTraces(a, b)
+	compared := 0
+	for i < len(a) && j < len(b) {
+		if fields := differences(&a[i], &b[j]); len(fields) > 0 {
+			switch {
+			case a[i].PC == b[j].PC:
+			case i > 0 && a[i].PC == a[i-1].PC:
+				i++
+				continue
+			case j > 0 && b[j].PC == b[j-1].PC:
+				j++
+				continue
+			}
+			start := i - context
+			if start < 0 {
+				start = 0
+			}
+			return &TraceMismatch{A: a[i], B: b[j], Fields: fields, Before: a[start:i]}, compared
+		}
+		i++
+		j++
+		compared++
+	}
+	return nil, compared
+}
+
+// alignTraces finds where the traces start on the same instruction,
+// preferring the smallest skip.
+func alignTraces(a, b []TraceEntry) (int, int) {
+	if len(a) == 0 || len(b) == 0 {
+		return 0, 0
+	}
+	same := func(x, y *TraceEntry) bool {
+		return x.PC == y.PC && len(differences(x, y)) == 0
+	}
+	for skip := 0; skip < TRACE_ALIGN_WINDOW; skip++ {
+		if skip < len(b) && same(&a[0], &b[skip]) {
+			return 0, skip
+		}
+		if skip < len(a) && same(&a[skip], &b[0]) {
+			return skip, 0
+		}
+	}
+	return 0, 0
+}
+
+// differences names the fields both entries have that differ.
+func differences(a, b *TraceEntry) []string {
+	var fields []string
+	has := a.Has & b.Has
+	if a.PC != b.PC {
+		fields = append(fields, "pc")
+	}
+	if has&TRACE_OPCODE != 0 && a.Opcode != b.Opcode {
+		fields = append(fields, "opcode")
+	}
+	for n := range a.V {
+		if has&(1<<n) != 0 && a.V[n] != b.V[n] {
+			fields = append(fields, fmt.Sprintf("v%X", n))
+		}
+	}
+	if has&TRACE_I != 0 && a.I != b.I {
+		fields = append(fields, "i")
+	}
+	if has&TRACE_SP != 0 && a.SP != b.SP {
+		fields = append(fields, "sp")
+	}
+	if has&TRACE_DT != 0 && a.DT != b.DT {
+		fields = append(fields, "dt")
+	}
+	if has&TRACE_ST != 0 && a.ST != b.ST {
+		fields = append(fields, "st")
+	}
+	return fields
+}
@@ -0,0 +1,124 @@
+package chip8
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// runTrace runs the program for a frame with the tracer and reads the
+// trace back.
+func runTrace(t *testing.T, rom []byte, quirks Quirks, level TraceLevel, format TraceFormat) []TraceEntry {
+	var buf bytes.Buffer
+	sys := lockstepSystem(t, rom, quirks)
+	sys.Cpu.Trace = NewTracer(&buf, level, format)
+	if err := sys.RunFrame(); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ReadTrace(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+// TestTraceCSV reads back the state before every instruction.
+func TestTraceCSV(t *testing.T) {
+	entries := runTrace(t, traceProgram, QUIRK_PROFILES["modern"], TRACE_OPCODES, TRACE_CSV)
+	if len(entries) != NewSystem().Tickrate {
+		t.Fatalf("%d entries, want one per instruction", len(entries))
+	}
+	e := entries[2]
+	if e.Cycle != 2 || e.PC != 0x204 || e.Opcode != 0xD015 || e.V[0] != 5 || e.V[1] != 3 || e.V[0xF] != 0 || e.Has != TRACE_ALL {
+		t.Errorf("third entry %+v", e)
+	}
+	if e := entries[3]; e.V[0xF] != 0 || e.PC != 0x206 {
+		t.Errorf("fourth entry %+v", e)
+	}
+}
+
+// TestReadTraceText reads the text trace, skipping events.
+func TestReadTraceText(t *testing.T) {
+	text := runTrace(t, traceProgram, QUIRK_PROFILES["modern"], TRACE_INSTRUCTIONS, TRACE_TEXT)
+	csv := runTrace(t, traceProgram, QUIRK_PROFILES["modern"], TRACE_INSTRUCTIONS, TRACE_CSV)
+	if len(text) != len(csv) {
+		t.Fatalf("%d entries in the text trace and %d in the CSV one", len(text), len(csv))
+	}
+	for n := range text {
+		text[n].Line, csv[n].Line = 0, 0
+		if text[n].Has|TRACE_CYCLE != csv[n].Has || text[n].PC != csv[n].PC || text[n].V != csv[n].V || text[n].I != csv[n].I {
+			t.Fatalf("entry %d: %+v, want %+v", n, text[n], csv[n])
+		}
+	}
+}
+
+// TestReadTraceFields reads the name and value pairs of other emulators.
+func TestReadTraceFields(t *testing.T) {
+	trace := `Booting...
+PC:0x0200 OP:0x6005 V0:0x00 V1:0x00 I:0x0000 SP:0xEA0 DT:0x00
+pc=0202 opcode=6103 v[0]=$05 v[1]=$00 i=000 delay=00 sound=00
+`
+	entries, err := ReadTrace(strings.NewReader(trace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("%d entries, want 2", len(entries))
+	}
+	first, second := entries[0], entries[1]
+	if first.Line != 2 || first.PC != 0x200 || first.Opcode != 0x6005 || first.Has&TRACE_SP != 0 || first.Has&TRACE_DT == 0 {
+		t.Errorf("first entry %+v", first)
+	}
+	if second.Cycle != 1 || second.V[0] != 5 || second.Has != TRACE_OPCODE|TRACE_I|TRACE_DT|TRACE_ST|0x3 {
+		t.Errorf("second entry %+v", second)
+	}
+}
+
+// TestReadTraceComments ignores pairs in the source lines appended to a
+// text trace.
+func TestReadTraceComments(t *testing.T) {
+	trace := `202: 7001 ADD  ; main+2 4: v0 += 1 # pc: 300
+204: A300 LD I  ; 5: i := 0x300 # v1=7
+PC=0206 OP=1206 ; sp=1
+`
+	entries, err := ReadTrace(strings.NewReader(trace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("%d entries, want 3", len(entries))
+	}
+	for n, pc := range []uint16{0x202, 0x204, 0x206} {
+		if e := entries[n]; e.PC != pc || e.Has != TRACE_OPCODE {
+			t.Errorf("entry %d: %+v, want only pc %03X and its opcode", n, e, pc)
+		}
+	}
+}
+
+// TestDiffTraces aligns a trace which starts with lines of its own and logs
+// a waiting instruction twice, then finds the first shift that differs.
+func TestDiffTraces(t *testing.T) {
+	rom := []byte{0x60, 0x05, 0x61, 0x03, 0x80, 0x16, 0x12, 0x06}
+	a := runTrace(t, rom, QUIRK_PROFILES["modern"], TRACE_OPCODES, TRACE_CSV)
+	b := `PC=0100 OP=1200
+PC=0200 OP=6005 V0=00 V1=00
+PC=0202 OP=6103 V0=05 V1=00
+PC=0202 OP=6103 V0=05 V1=00
+PC=0204 OP=8016 V0=05 V1=03
+PC=0206 OP=1206 V0=01 V1=03
+`
+	entries, err := ReadTrace(strings.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, compared := DiffTraces(a, entries, 2)
+	if m == nil {
+		t.Fatal("no mismatch")
+	}
+	if compared != 3 || m.A.PC != 0x206 || m.B.Line != 6 || strings.Join(m.Fields, ",") != "v0" || len(m.Before) != 2 {
+		t.Errorf("compared %d, mismatch\n%s", compared, m)
+	}
+	if m, compared := DiffTraces(a, a, 0); m != nil || compared != len(a) {
+		t.Errorf("trace differs from itself after %d: %s", compared, m)
+	}
+}
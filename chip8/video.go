@@ -0,0 +1,141 @@
+package chip8
+
+import "image/color"
+
+const (
+	V_PIXELS = 32
+	H_PIXELS = 64
+	// SCHIP hires mode, switched on by 00FF and off by 00FE.
+	HIRES_V_PIXELS = 64
+	HIRES_H_PIXELS = 128
+)
+
+// screenBuf holds one byte per pixel, indexed by [x][y]. The 64x32 mode
+// only uses its top left corner.
+type screenBuf [HIRES_H_PIXELS][HIRES_V_PIXELS]byte
+
+// VideoMemory is the display, 64x32 pixels or 128x64 in hires mode.
+type VideoMemory struct {
+	buf    screenBuf
+	hires  bool
+	drawn  bool // A sprite was drawn without collision since the last vblank.
+	erased bool // Pixels were cleared or erased since the last vblank.
+}
+
+func NewVideoMemory() *VideoMemory {
+	return new(VideoMemory)
+}
+
+// Size of the display in pixels in the current mode.
+func (vme *VideoMemory) Size() (int, int) {
+	if vme.hires {
+		return HIRES_H_PIXELS, HIRES_V_PIXELS
+	}
+	return H_PIXELS, V_PIXELS
+}
+
+// Hires reports whether the display is in the SCHIP 128x64 mode.
+func (vme *VideoMemory) Hires() bool {
+	return vme.hires
+}
+
+// setHires switches the resolution and clears the display, as Octo does.
+func (vme *VideoMemory) setHires(hires bool) {
+	vme.hires = hires
+	vme.clear()
+}
+
+func (vme *VideoMemory) clear() {
+	vme.buf = screenBuf{}
+	vme.erased = true
+}
+
+// Render converts the display into RGBA pixels. pix must hold 4 bytes for
+// every pixel of Size and colors is indexed by pixel value.
+func (vme *VideoMemory) Render(pix []byte, colors []color.RGBA) {
+	w, h := vme.Size()
+	render(&vme.buf, w, h, pix, colors)
+}
+
+func render(buf *screenBuf, w, h int, pix []byte, colors []color.RGBA) {
+	for y := 0; y < h; y++ {
+		row := pix[4*y*w:]
+		for x := 0; x < w; x++ {
+			c := colors[buf[x][y]]
+			row[4*x] = c.R
+			row[4*x+1] = c.G
+			row[4*x+2] = c.B
+			row[4*x+3] = c.A
+		}
+	}
+}
+
+// draw XORs a sprite width pixels wide, 8 or 16, onto the display and
+// returns 1 if any pixel was erased. The start coordinates wrap around the
+// screen. Pixels past the right or bottom edge are clipped, or wrapped
+// around if clip is false. In hires mode it returns the number of rows
+// which erased a pixel or were clipped off the bottom, as the SCHIP does.
+func (vme *VideoMemory) draw(x uint16, y uint16, sprite []byte, width int, clip bool) uint8 {
+	w, h := vme.Size()
+	x %= uint16(w)
+	y %= uint16(h)
+	bytes := width / 8
+	rows := len(sprite) / bytes
+	collided, clipped := 0, 0
+	for i := 0; i < rows; i++ {
+		py := y + uint16(i)
+		if py >= uint16(h) {
+			if clip {
+				clipped = rows - i
+				break
+			}
+			py %= uint16(h)
+		}
+		bits := 0
+		for _, b := range sprite[i*bytes : (i+1)*bytes] {
+			bits = bits<<8 | int(b)
+		}
+		vf := uint16(0)
+		for bit := 0; bit < width; bit++ {
+			px := x + uint16(bit)
+			if px >= uint16(w) {
+				if clip {
+					break
+				}
+				px %= uint16(w)
+			}
+			vf += vme.draw_pixcel(px, py, byte(bits>>(width-1-bit))&0x1)
+		}
+		if vf > 0 {
+			collided++
+		}
+	}
+
+	if collided > 0 {
+		vme.erased = true
+	} else {
+		vme.drawn = true
+	}
+	switch {
+	case vme.hires:
+		return uint8(collided + clipped)
+	case collided > 0:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (vme *VideoMemory) draw_pixcel(x uint16, y uint16, new byte) uint16 {
+	var vf uint16
+
+	// Check collision.
+	if vme.buf[x][y] == 1 && new == 1 {
+		vf = 1
+	} else {
+		vf = 0
+	}
+
+	vme.buf[x][y] ^= new
+	return vf
+}
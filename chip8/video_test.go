@@ -0,0 +1,170 @@
+package chip8
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+// benchScreen draws the same sprites in both benchmarks, lighting half the
+// pixels of the top rows.
+func benchScreen() *VideoMemory {
+	vme := NewVideoMemory()
+	for x := 0; x+8 <= H_PIXELS; x += 8 {
+		vme.draw(uint16(x), 0, []byte{0xAA, 0x55, 0xAA, 0x55}, 8, true)
+	}
+	return vme
+}
+
+// BenchmarkRender converts a full framebuffer into RGBA once per iteration.
+// Drawing used to allocate an ebiten.Image for every lit pixel each frame;
+// rendering into a reused buffer must not allocate at all.
+func BenchmarkRender(b *testing.B) {
+	vme := benchScreen()
+	pix := make([]byte, 4*H_PIXELS*V_PIXELS)
+	colors := []color.RGBA{{0, 0, 0, 0xFF}, {0xFF, 0xFF, 0xFF, 0xFF}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		vme.Render(pix, colors)
+	}
+}
+
+// BenchmarkRenderPerPixel draws the frame the way the GUI used to, filling a
+// new 10x10 image for every lit pixel and drawing it onto the scaled screen.
+// image.RGBA stands in for ebiten.Image, which needs a window.
+func BenchmarkRenderPerPixel(b *testing.B) {
+	vme := benchScreen()
+	screen := image.NewRGBA(image.Rect(0, 0, 10*H_PIXELS, 10*V_PIXELS))
+	bg, fg := image.NewUniform(color.Black), image.NewUniform(color.White)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		draw.Draw(screen, screen.Bounds(), bg, image.Point{}, draw.Src)
+		for x := 0; x < H_PIXELS; x++ {
+			for y := 0; y < V_PIXELS; y++ {
+				if vme.buf[x][y] == 1 {
+					img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+					draw.Draw(img, img.Bounds(), fg, image.Point{}, draw.Src)
+					draw.Draw(screen, img.Bounds().Add(image.Pt(10*x, 10*y)), img, image.Point{}, draw.Src)
+				}
+			}
+		}
+	}
+}
+
+func TestDraw(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		x, y   uint16
+		sprite []byte
+		clip   bool
+		lit    [][2]int // Pixels expected to be lit.
+	}{
+		{"inside", 3, 4, []byte{0x81}, true, [][2]int{{3, 4}, {10, 4}}},
+		{"start wraps", H_PIXELS + 3, V_PIXELS + 4, []byte{0x81}, true, [][2]int{{3, 4}, {10, 4}}},
+		{"right edge clips", 60, 0, []byte{0x88}, true, [][2]int{{60, 0}}},
+		{"right edge wraps", 60, 0, []byte{0x88}, false, [][2]int{{60, 0}, {0, 0}}},
+		{"bottom edge clips", 0, 30, []byte{0x80, 0x80, 0x80}, true, [][2]int{{0, 30}, {0, 31}}},
+		{"bottom edge wraps", 0, 30, []byte{0x80, 0x80, 0x80}, false, [][2]int{{0, 30}, {0, 31}, {0, 0}}},
+		{"corner wraps", 63, 31, []byte{0xC0, 0xC0}, false, [][2]int{{63, 31}, {0, 31}, {63, 0}, {0, 0}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			vme := NewVideoMemory()
+			if vf := vme.draw(tc.x, tc.y, tc.sprite, 8, tc.clip); vf != 0 {
+				t.Errorf("vf = %d on an empty screen", vf)
+			}
+			want := screenBuf{}
+			for _, p := range tc.lit {
+				want[p[0]][p[1]] = 1
+			}
+			if vme.buf != want {
+				for x := range want {
+					for y := range want[x] {
+						if vme.buf[x][y] != want[x][y] {
+							t.Errorf("pixel %d,%d = %d, want %d", x, y, vme.buf[x][y], want[x][y])
+						}
+					}
+				}
+			}
+			if vf := vme.draw(tc.x, tc.y, tc.sprite, 8, tc.clip); vf != 1 || vme.buf != (screenBuf{}) {
+				t.Errorf("drawing again: vf = %d, want 1 and an empty screen", vf)
+			}
+		})
+	}
+}
+
+func TestHires(t *testing.T) {
+	sys := NewSystem()
+	sprite := make([]byte, 32)
+	for n := range sprite {
+		sprite[n] = 0xFF
+	}
+	// 00FF, then a 16x16 sprite at 120,60, which clips at the corner.
+	rom := append([]byte{0x00, 0xFF, 0x60, 0x78, 0x61, 0x3C, 0xA2, 0x0A, 0xD0, 0x10}, sprite...)
+	if err := sys.LoadBytes(rom); err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < 5; n++ {
+		if err := sys.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w, h := sys.Vme.Size(); w != HIRES_H_PIXELS || h != HIRES_V_PIXELS {
+		t.Fatalf("size %dx%d, want %dx%d", w, h, HIRES_H_PIXELS, HIRES_V_PIXELS)
+	}
+	lit := 0
+	for x := range sys.Vme.buf {
+		for y := range sys.Vme.buf[x] {
+			lit += int(sys.Vme.buf[x][y])
+		}
+	}
+	if lit != 8*4 || sys.Vme.buf[120][60] != 1 || sys.Vme.buf[127][63] != 1 {
+		t.Errorf("%d pixels lit, want the 8x4 left of the sprite", lit)
+	}
+
+	pix := make([]byte, 4*HIRES_H_PIXELS*HIRES_V_PIXELS)
+	sys.Vme.Render(pix, []color.RGBA{{0, 0, 0, 0xFF}, {0xFF, 0xFF, 0xFF, 0xFF}})
+	if p := pix[4*(63*HIRES_H_PIXELS+127):]; p[0] != 0xFF {
+		t.Error("bottom right pixel not rendered")
+	}
+
+	d := NewDisplay()
+	d.VBlank(sys.Vme)
+	if w, h := d.Size(); w != HIRES_H_PIXELS || h != HIRES_V_PIXELS {
+		t.Errorf("display size %dx%d, want %dx%d", w, h, HIRES_H_PIXELS, HIRES_V_PIXELS)
+	}
+}
+
+// TestDrawHiresRows checks that vF counts the sprite rows which collided or
+// were clipped off the bottom in hires mode, and stays 0 or 1 otherwise.
+func TestDrawHiresRows(t *testing.T) {
+	block := []byte{0xFF, 0xFF, 0xFF, 0xFF} // 8x4
+	tall := []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80}
+
+	vme := NewVideoMemory()
+	vme.setHires(true)
+	vme.draw(0, 58, block, 8, true)
+	// Rows 58 to 61 hit the block, 62 and 63 are drawn and 2 are clipped.
+	if vf := vme.draw(0, 58, tall, 8, true); vf != 4+2 {
+		t.Errorf("vf = %d, want 6", vf)
+	}
+	if vme.buf[0][63] != 1 || vme.buf[0][58] != 0 {
+		t.Error("sprite not drawn")
+	}
+	// Wrapping clips nothing, so only the collisions count.
+	vme.setHires(true)
+	vme.draw(0, 0, block, 8, true)
+	if vf := vme.draw(0, 60, tall, 8, false); vf != 4 {
+		t.Errorf("wrapping: vf = %d, want 4", vf)
+	}
+
+	vme.setHires(false)
+	vme.draw(0, 26, block, 8, true)
+	if vf := vme.draw(0, 26, tall, 8, true); vf != 1 {
+		t.Errorf("64x32: vf = %d, want 1", vf)
+	}
+}
@@ -0,0 +1,392 @@
+package chip8
+
+import "fmt"
+
+// COSMAC VIP video timing. The 1861 draws 262 lines of 14 machine cycles a
+// frame. It interrupts the CPU two lines before the 128 display lines, and
+// on each of them takes 8 bytes by DMA after letting the CPU run for 6
+// cycles. EF1 is asserted for the 4 lines before the display starts and ends.
+const (
+	VIP_LINE_CYCLES   = 14
+	VIP_INT_LINE      = 78
+	VIP_FIRST_LINE    = 80
+	VIP_DISPLAY_LINES = 128
+	VIP_DMA_START     = 6
+)
+
+// VIP memory. The interpreter occupies the first 512 bytes of RAM and keeps
+// its variables, the stack and the display in the top 352, which programs
+// must not use. The monitor ROM appears at 0x8000.
+const (
+	VIP_INTERPRETER_SIZE = 0x200
+	VIP_RESERVED         = 0x160
+	VIP_ROM_START        = 0x8000
+	VIP_ROM_SIZE         = 0x200
+)
+
+// vipInterrupt stands in for the display interrupt routine of the monitor
+// ROM, which the interpreter points R1 at. It sets R0 to the display page,
+// repeats every 8 byte row on 4 lines, counts the timers in R8 down and
+// sounds the tone with Q while R8.0 is not zero. Like the original it exits
+// through the two bytes before its entry, leaving R1 at the entry again.
+var vipInterrupt = []byte{
+	0x72,       // 8144 LDXA          Restore D.
+	0x70,       // 8145 RET
+	0x22,       // 8146 DEC 2         Entry: save T and D.
+	0x78,       // 8147 SAV
+	0x22,       // 8148 DEC 2
+	0x52,       // 8149 STR 2
+	0x9B,       // 814A GHI B         R0 = display page.
+	0xB0,       // 814B PHI 0
+	0xF8, 0x00, // 814C LDI 00
+	0xA0,       // 814E PLO 0
+	0x34, 0x4F, // 814F B1 814F       Wait for the display to start.
+	0xE2,       // 8151 SEX 2
+	0xE2,       // 8152 SEX 2         The first line is fetched here.
+	0x80,       // 8153 GLO 0         Show the row again on the next 3 lines.
+	0xFF, 0x08, // 8154 SMI 08
+	0xA0,       // 8156 PLO 0
+	0x80,       // 8157 GLO 0
+	0xFF, 0x08, // 8158 SMI 08
+	0xA0,       // 815A PLO 0
+	0x80,       // 815B GLO 0
+	0xFF, 0x08, // 815C SMI 08
+	0xA0,       // 815E PLO 0
+	0xE2,       // 815F SEX 2         Move on to the next row until R0.0 wraps.
+	0x80,       // 8160 GLO 0
+	0x3A, 0x53, // 8161 BNZ 8153
+	0x98,       // 8163 GHI 8         Delay timer.
+	0x32, 0x69, // 8164 BZ 8169
+	0xFF, 0x01, // 8166 SMI 01
+	0xB8,       // 8168 PHI 8
+	0x88,       // 8169 GLO 8         Sound timer.
+	0x32, 0x74, // 816A BZ 8174
+	0xFF, 0x01, // 816C SMI 01
+	0xA8,       // 816E PLO 8
+	0x32, 0x74, // 816F BZ 8174
+	0x7B,       // 8171 SEQ
+	0x30, 0x75, // 8172 BR 8175
+	0x7A,       // 8174 REQ
+	0x19,       // 8175 INC 9         Random seed.
+	0x30, 0x44, // 8176 BR 8144
+}
+
+// VIP is a COSMAC VIP: a CDP1802 with 2 or 4 KB of RAM, an 1861 video chip
+// and a hex keypad, running the original CHIP-8 interpreter from an image
+// of its 512 bytes. Neither the interpreter nor the monitor ROM comes with
+// the emulator. Without an image of the monitor, vipInterrupt stands in for
+// its interrupt routine; it was not written from the original, so the
+// cycles left to the interpreter each frame, and with them the speed of
+// programs, are not faithful to the VIP.
+//
+// The interpreter keeps the CHIP-8 program counter in R5, i in RA and the
+// timers in R8.1 and R8.0. Its fetch loop is found as the first LDA R5 it
+// executes, which Step uses to tell instructions apart.
+type VIP struct {
+	Cpu       CDP1802
+	Replay    *Replay // Scripted input replacing the keypad, if any.
+	Frame     int     // Frames run so far.
+	ram       []byte
+	rom       [VIP_ROM_SIZE]byte
+	vme       *VideoMemory
+	kb        *Keyboard
+	keys      [16]bool // Held during the current frame.
+	key       byte     // Latched by OUT 2.
+	display   bool     // Enabled by INP 1, disabled by OUT 1.
+	interrupt bool     // INT is asserted.
+	cycle     int      // Machine cycles into the frame.
+	dmaNext   int      // Next display line to fetch.
+	lines     [VIP_DISPLAY_LINES][8]byte
+	buzzing   bool
+	fetch     uint16 // Address of the fetch loop, once known.
+	fetched   bool   // An instruction was fetched and is executing.
+	stackTop  uint16 // R2 when the fetch loop was found.
+}
+
+// NewVIP builds a VIP with ramSize bytes of RAM, 2048 or 4096, and the
+// interpreter image loaded at address 0.
+func NewVIP(interpreter []byte, ramSize int) (*VIP, error) {
+	if ramSize != 0x800 && ramSize != 0x1000 {
+		return nil, fmt.Errorf("the VIP has 2048 or 4096 bytes of RAM, not %d", ramSize)
+	}
+	if len(interpreter) == 0 || len(interpreter) > VIP_INTERPRETER_SIZE {
+		return nil, fmt.Errorf("interpreter image of %d bytes, want at most %d", len(interpreter), VIP_INTERPRETER_SIZE)
+	}
+	vip := &VIP{
+		ram: make([]byte, ramSize),
+		vme: NewVideoMemory(),
+		kb:  NewKeyboard(),
+	}
+	copy(vip.ram, interpreter)
+	copy(vip.rom[0x144:], vipInterrupt)
+	vip.Reset()
+	return vip, nil
+}
+
+// LoadMonitor replaces the built-in interrupt routine with an image of the
+// monitor ROM.
+func (vip *VIP) LoadMonitor(rom []byte) error {
+	if len(rom) > VIP_ROM_SIZE {
+		return fmt.Errorf("monitor image of %d bytes, want at most %d", len(rom), VIP_ROM_SIZE)
+	}
+	vip.rom = [VIP_ROM_SIZE]byte{}
+	copy(vip.rom[:], rom)
+	return nil
+}
+
+// Reset starts the interpreter as the monitor does, with R1.1 holding the
+// last page of RAM.
+func (vip *VIP) Reset() {
+	vip.Cpu.Reset()
+	vip.Cpu.R[1] = uint16(len(vip.ram)-1) & 0xFF00
+	vip.display = false
+	vip.interrupt = false
+	vip.cycle = 0
+	vip.dmaNext = 0
+	vip.fetch = 0
+	vip.fetched = false
+	vip.Frame = 0
+	vip.beginFrame()
+}
+
+// Bytes available for a program.
+func (vip *VIP) ProgramSize() int {
+	return len(vip.ram) - PROGRAM_START - VIP_RESERVED
+}
+
+// Copy a program image into memory at PROGRAM_START.
+func (vip *VIP) LoadBytes(rom []byte) error {
+	if len(rom) > vip.ProgramSize() {
+		return fmt.Errorf("program of %d bytes does not fit in memory", len(rom))
+	}
+	copy(vip.ram[PROGRAM_START:], rom)
+	return nil
+}
+
+// RunFrame runs the machine until the 1861 starts the next frame.
+func (vip *VIP) RunFrame() error {
+	for frame := vip.Frame; vip.Frame == frame; {
+		vip.step()
+	}
+	return nil
+}
+
+// Step runs the interpreter until it fetches the next CHIP-8 instruction.
+// An instruction still executing after a whole frame, as Fx0A does while
+// waiting for a key, returns early and the next call goes on with it.
+func (vip *VIP) Step() error {
+	for frame := vip.Frame; vip.Frame < frame+2; {
+		vip.step()
+		if vip.fetched && vip.atFetch() {
+			vip.fetched = false
+			break
+		}
+	}
+	return nil
+}
+
+func (vip *VIP) atFetch() bool {
+	cpu := &vip.Cpu
+	return vip.fetch != 0 && cpu.P != 1 && !cpu.Idle && cpu.R[cpu.P] == vip.fetch
+}
+
+// step executes one instruction, or the DMA or interrupt the 1861 requests
+// before it.
+func (vip *VIP) step() {
+	cpu := &vip.Cpu
+	line := vip.cycle/VIP_LINE_CYCLES - VIP_FIRST_LINE
+	var cycles int
+	switch {
+	case vip.display && line >= vip.dmaNext && line < VIP_DISPLAY_LINES && vip.cycle%VIP_LINE_CYCLES >= VIP_DMA_START:
+		for n := range vip.lines[line] {
+			vip.lines[line][n] = vip.Read(cpu.R[0])
+			cpu.R[0]++
+		}
+		vip.dmaNext = line + 1
+		cpu.Idle = false
+		cycles = len(vip.lines[line])
+	case vip.interrupt && cpu.IE:
+		vip.interrupt = false
+		cycles = cpu.Interrupt()
+	default:
+		pc := cpu.R[cpu.P]
+		if cpu.P != 1 && !cpu.Idle {
+			if vip.fetch == 0 && vip.Read(pc) == 0x45 {
+				vip.fetch, vip.stackTop = pc, cpu.R[2]
+			}
+			if vip.fetch != 0 && pc == vip.fetch {
+				vip.fetched = true
+			}
+		}
+		cycles = cpu.Step(vip)
+	}
+	vip.advance(cycles)
+}
+
+// advance moves the beam on.
+func (vip *VIP) advance(cycles int) {
+	before := vip.cycle / VIP_LINE_CYCLES
+	vip.cycle += cycles
+	line := vip.cycle / VIP_LINE_CYCLES
+	if before < VIP_INT_LINE && line >= VIP_INT_LINE && vip.display {
+		vip.interrupt = true
+	}
+	if line >= VIP_FIRST_LINE {
+		vip.interrupt = false
+	}
+	if vip.cycle >= VIP_CYCLES_PER_FRAME {
+		vip.cycle -= VIP_CYCLES_PER_FRAME
+		vip.endFrame()
+		vip.beginFrame()
+	}
+}
+
+// endFrame copies the lines shown into the display.
+func (vip *VIP) endFrame() {
+	for y := 0; y < V_PIXELS; y++ {
+		row := vip.lines[y*VIP_DISPLAY_LINES/V_PIXELS]
+		for x := 0; x < H_PIXELS; x++ {
+			var pixel byte
+			if vip.display {
+				pixel = row[x/8] >> (7 - x%8) & 1
+			}
+			vip.vme.buf[x][y] = pixel
+		}
+	}
+	vip.buzzing = vip.Cpu.Q
+	vip.Frame++
+}
+
+// beginFrame samples the keys held during the frame.
+func (vip *VIP) beginFrame() {
+	vip.dmaNext = 0
+	if vip.Replay != nil {
+		for _, key := range vip.Replay.Keys(vip.Frame) {
+			vip.kb.Push(key)
+		}
+	}
+	vip.keys = [16]bool{}
+	for _, key := range vip.kb.Pending() {
+		vip.keys[key&0xF] = true
+	}
+	vip.kb.Clear()
+}
+
+// The interpreter keeps its variables in the page below the display.
+func (vip *VIP) variables() int {
+	return len(vip.ram) - 0x200
+}
+
+// State reads the CHIP-8 registers from where the interpreter keeps them.
+// Return addresses are pushed below the initial R2, high byte first.
+func (vip *VIP) State() State {
+	cpu := &vip.Cpu
+	state := State{
+		I:     cpu.R[0xA],
+		PC:    cpu.R[5],
+		Stack: []uint16{},
+		DT:    uint8(cpu.R[8] >> 8),
+		ST:    uint8(cpu.R[8]),
+	}
+	copy(state.V[:], vip.ram[vip.variables()+0xF0:])
+	if vip.fetch != 0 {
+		for a := vip.stackTop - 1; a > cpu.R[2] && a < vip.stackTop; a -= 2 {
+			state.Stack = append(state.Stack, uint16(vip.Read(a))<<8|uint16(vip.Read(a+1)))
+		}
+	}
+	return state
+}
+
+// SetState changes the registers of the interpreter. Return addresses can
+// be changed and the newest dropped, but none added.
+func (vip *VIP) SetState(state State) error {
+	cpu := &vip.Cpu
+	old := vip.State()
+	if len(state.Stack) > len(old.Stack) {
+		return ErrStackChange
+	}
+	copy(vip.ram[vip.variables()+0xF0:], state.V[:])
+	cpu.R[0xA], cpu.R[5] = state.I, state.PC
+	cpu.R[8] = uint16(state.DT)<<8 | uint16(state.ST)
+	cpu.R[2] += uint16(2 * (len(old.Stack) - len(state.Stack)))
+	a := vip.stackTop - 1
+	for _, pc := range state.Stack {
+		vip.Write(a, byte(pc>>8))
+		vip.Write(a+1, byte(pc))
+		a -= 2
+	}
+	return nil
+}
+
+// Poke writes RAM.
+func (vip *VIP) Poke(addr int, data []byte) error {
+	if err := checkPoke(len(vip.ram), addr, data); err != nil {
+		return err
+	}
+	copy(vip.ram[addr:], data)
+	return nil
+}
+
+func (vip *VIP) Screen() *VideoMemory {
+	return vip.vme
+}
+
+func (vip *VIP) Keypad() *Keyboard {
+	return vip.kb
+}
+
+func (vip *VIP) RAM() []byte {
+	return vip.ram
+}
+
+// Buzzing reports whether Q sounded the tone at the end of the last frame.
+func (vip *VIP) Buzzing() bool {
+	return vip.buzzing
+}
+
+// Read implements Bus. RAM repeats up to 0x7FFF and the ROM above.
+func (vip *VIP) Read(addr uint16) byte {
+	if addr >= VIP_ROM_START {
+		return vip.rom[addr%VIP_ROM_SIZE]
+	}
+	return vip.ram[int(addr)%len(vip.ram)]
+}
+
+// Write implements Bus.
+func (vip *VIP) Write(addr uint16, b byte) {
+	if addr < VIP_ROM_START {
+		vip.ram[int(addr)%len(vip.ram)] = b
+	}
+}
+
+// Out implements Bus. OUT 1 turns the display off and OUT 2 selects the key
+// that EF3 reports.
+func (vip *VIP) Out(port int, b byte) {
+	switch port {
+	case 1:
+		vip.display = false
+	case 2:
+		vip.key = b & 0xF
+	}
+}
+
+// In implements Bus. INP 1 turns the display on.
+func (vip *VIP) In(port int) byte {
+	if port == 1 {
+		vip.display = true
+	}
+	return 0
+}
+
+// Flag implements Bus. EF1 marks the start and end of the display and EF3
+// is the selected key.
+func (vip *VIP) Flag(n int) bool {
+	switch n {
+	case 1:
+		line := vip.cycle/VIP_LINE_CYCLES - VIP_FIRST_LINE
+		return line >= -4 && line < 0 || line >= VIP_DISPLAY_LINES-4 && line < VIP_DISPLAY_LINES
+	case 3:
+		return vip.keys[vip.key]
+	}
+	return false
+}
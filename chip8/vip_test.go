@@ -0,0 +1,184 @@
+package chip8
+
+import "testing"
+
+// testInterpreter sets the machine up like the VIP interpreter, turns the
+// display on, starts the timers at 3 and 2 and then steps through the
+// program without executing it.
+var testInterpreter = []byte{
+	0x91,       // 0000 GHI 1      RB.1 = display page.
+	0xBB,       // 0001 PHI B
+	0xFF, 0x01, // 0002 SMI 01
+	0xB2,       // 0004 PHI 2      R2 = stack.
+	0xB6,       // 0005 PHI 6
+	0xF8, 0xCF, // 0006 LDI CF
+	0xA2,       // 0008 PLO 2
+	0xF8, 0x81, // 0009 LDI 81     R1 = interrupt routine.
+	0xB1,       // 000B PHI 1
+	0xF8, 0x46, // 000C LDI 46
+	0xA1,       // 000E PLO 1
+	0xE2,       // 000F SEX 2
+	0x69,       // 0010 INP 1      Display on.
+	0xF8, 0x02, // 0011 LDI 02     R5 = 0x200.
+	0xB5,       // 0013 PHI 5
+	0xF8, 0x00, // 0014 LDI 00
+	0xA5,       // 0016 PLO 5
+	0xF8, 0x03, // 0017 LDI 03     R8 = timers.
+	0xB8,       // 0019 PHI 8
+	0xF8, 0x02, // 001A LDI 02
+	0xA8,       // 001C PLO 8
+	0xF8, 0x24, // 001D LDI 24     Continue with R3 as the program
+	0xA3,       // 001F PLO 3      counter, since R0 is the DMA pointer.
+	0xF8, 0x00, // 0020 LDI 00
+	0xB3,       // 0022 PHI 3
+	0xD3,       // 0023 SEP 3
+	0x45,       // 0024 LDA 5      Fetch loop.
+	0x45,       // 0025 LDA 5
+	0x30, 0x24, // 0026 BR 24
+}
+
+func newTestVIP(t *testing.T) *VIP {
+	vip, err := NewVIP(testInterpreter, 0x1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vip
+}
+
+// TestVIPDisplay checks that the interrupt routine and the DMA show the
+// display page, one row of 8 bytes on every 4 lines.
+func TestVIPDisplay(t *testing.T) {
+	vip := newTestVIP(t)
+	ram := vip.RAM()
+	for y := 0; y < V_PIXELS; y++ {
+		ram[0xF00+y*8] = byte(y)
+		ram[0xF00+y*8+7] = 0x80 >> (y % 8)
+	}
+	for n := 0; n < 2; n++ {
+		if err := vip.RunFrame(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for y := 0; y < V_PIXELS; y++ {
+		for x := 0; x < H_PIXELS; x++ {
+			want := ram[0xF00+y*8+x/8] >> (7 - x%8) & 1
+			if got := vip.Screen().buf[x][y]; got != want {
+				t.Fatalf("pixel %d,%d is %d, want %d", x, y, got, want)
+			}
+		}
+	}
+}
+
+// TestVIPTimers counts the timers in R8 down once per frame and sounds the
+// tone while the sound timer runs.
+func TestVIPTimers(t *testing.T) {
+	vip := newTestVIP(t)
+	for n, want := range []struct {
+		dt, st  uint8
+		buzzing bool
+	}{{2, 1, true}, {1, 0, false}, {0, 0, false}, {0, 0, false}} {
+		if err := vip.RunFrame(); err != nil {
+			t.Fatal(err)
+		}
+		s := vip.State()
+		if s.DT != want.dt || s.ST != want.st || vip.Buzzing() != want.buzzing {
+			t.Errorf("frame %d: dt %d st %d buzzing %v, want %+v", n, s.DT, s.ST, vip.Buzzing(), want)
+		}
+	}
+}
+
+// TestVIPStep finds the fetch loop and stops before every fetch, across
+// the interrupts of several frames.
+func TestVIPStep(t *testing.T) {
+	vip := newTestVIP(t)
+	for n := 1; n <= 1000; n++ {
+		if err := vip.Step(); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := vip.State().PC, uint16(PROGRAM_START+2*n); got != want {
+			t.Fatalf("pc %#03x after %d steps, want %#03x", got, n, want)
+		}
+	}
+	if vip.Frame == 0 {
+		t.Errorf("1000 steps did not reach the next frame")
+	}
+}
+
+func TestVIPState(t *testing.T) {
+	vip := newTestVIP(t)
+	vip.Step()
+	ram := vip.RAM()
+	for n := 0; n < 16; n++ {
+		ram[0xEF0+n] = byte(n * 3)
+	}
+	ram[0xECC], ram[0xECD], ram[0xECE], ram[0xECF] = 0x03, 0x46, 0x02, 0x08
+	vip.Cpu.R[2] = 0xECB
+	vip.Cpu.R[0xA] = 0x345
+	s := vip.State()
+	if s.V[15] != 45 || s.I != 0x345 {
+		t.Errorf("v %v i %#03x", s.V, s.I)
+	}
+	if len(s.Stack) != 2 || s.Stack[0] != 0x208 || s.Stack[1] != 0x346 {
+		t.Errorf("stack %#03x, want [0x208 0x346]", s.Stack)
+	}
+}
+
+// TestVIPSetState writes the registers where the interpreter keeps them and
+// pops the newest return address.
+func TestVIPSetState(t *testing.T) {
+	vip := newTestVIP(t)
+	vip.Step()
+	ram := vip.RAM()
+	ram[0xECC], ram[0xECD], ram[0xECE], ram[0xECF] = 0x03, 0x46, 0x02, 0x08
+	vip.Cpu.R[2] = 0xECB
+	s := vip.State()
+	s.V[3], s.I, s.PC, s.DT = 7, 0x123, 0x210, 9
+	s.Stack = s.Stack[:1]
+	if err := vip.SetState(s); err != nil {
+		t.Fatal(err)
+	}
+	got := vip.State()
+	if got.V[3] != 7 || got.I != 0x123 || got.PC != 0x210 || got.DT != 9 || len(got.Stack) != 1 || got.Stack[0] != 0x208 {
+		t.Errorf("state %+v", got)
+	}
+	s.Stack = append(s.Stack, 0x300, 0x400)
+	if err := vip.SetState(s); err != ErrStackChange {
+		t.Errorf("pushed return addresses: %v", err)
+	}
+}
+
+// TestVIPKeypad reports the key selected by OUT 2 on EF3 while it is held.
+func TestVIPKeypad(t *testing.T) {
+	vip := newTestVIP(t)
+	vip.Keypad().Push(0xA)
+	vip.RunFrame()
+	vip.Out(2, 0xA)
+	if !vip.Flag(3) {
+		t.Errorf("key A not reported")
+	}
+	vip.Out(2, 0xB)
+	if vip.Flag(3) {
+		t.Errorf("key B reported")
+	}
+	vip.RunFrame()
+	vip.Out(2, 0xA)
+	if vip.Flag(3) {
+		t.Errorf("key A still held after it was released")
+	}
+}
+
+func TestNewVIP(t *testing.T) {
+	if _, err := NewVIP(testInterpreter, 0x400); err == nil {
+		t.Errorf("1 KB of RAM accepted")
+	}
+	if _, err := NewVIP(make([]byte, 0x201), 0x800); err == nil {
+		t.Errorf("interpreter over 512 bytes accepted")
+	}
+	vip, err := NewVIP(testInterpreter, 0x800)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vip.LoadBytes(make([]byte, 0x800-0x200-VIP_RESERVED+1)); err == nil {
+		t.Errorf("program over the reserved memory accepted")
+	}
+}
@@ -0,0 +1,83 @@
+package chip8
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// WavRecorder records whether the buzzer sounded on each frame and renders
+// it as a square wave in a WAV file. Every frame covers exactly 1/60 s of
+// samples, so the sound lines up with the frames of a recording.
+type WavRecorder struct {
+	SampleRate int
+	Frequency  float64 // Pitch of the buzzer in Hz.
+	Volume     float64 // Amplitude between 0 and 1.
+	frames     []bool
+}
+
+func NewWavRecorder() *WavRecorder {
+	return &WavRecorder{SampleRate: 44100, Frequency: 440, Volume: 0.25}
+}
+
+// Capture records the buzzer of the frame just run.
+func (w *WavRecorder) Capture(m Machine) {
+	w.frames = append(w.frames, m.Buzzing())
+}
+
+// Encode writes 16 bit mono PCM.
+func (w *WavRecorder) Encode(out io.Writer) error {
+	if len(w.frames) == 0 {
+		return fmt.Errorf("nothing was recorded")
+	}
+	samples := len(w.frames) * w.SampleRate / 60
+	size := 2 * samples
+
+	header := make([]byte, 44)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(36+size))
+	copy(header[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(header[16:], 16)
+	binary.LittleEndian.PutUint16(header[20:], 1) // PCM.
+	binary.LittleEndian.PutUint16(header[22:], 1) // Mono.
+	binary.LittleEndian.PutUint32(header[24:], uint32(w.SampleRate))
+	binary.LittleEndian.PutUint32(header[28:], uint32(2*w.SampleRate))
+	binary.LittleEndian.PutUint16(header[32:], 2)
+	binary.LittleEndian.PutUint16(header[34:], 16)
+	copy(header[36:], "data")
+	binary.LittleEndian.PutUint32(header[40:], uint32(size))
+	if _, err := out.Write(header); err != nil {
+		return err
+	}
+
+	amplitude := int16(w.Volume * 32767)
+	data := make([]byte, 0, size)
+	for n, buzzing := range w.frames {
+		// Frame n spans the samples between n/60 s and (n+1)/60 s.
+		for i := n * w.SampleRate / 60; i < (n+1)*w.SampleRate/60; i++ {
+			v := int16(0)
+			if buzzing {
+				v = amplitude
+				if int(2*float64(i)*w.Frequency/float64(w.SampleRate))%2 == 1 {
+					v = -amplitude
+				}
+			}
+			data = append(data, byte(v), byte(uint16(v)>>8))
+		}
+	}
+	_, err := out.Write(data)
+	return err
+}
+
+func (w *WavRecorder) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := w.Encode(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,75 @@
+package chip8
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestWavRecorder(t *testing.T) {
+	for _, rate := range []int{44100, 8000} {
+		sys := NewSystem()
+		// v0 := 3, buzzer := v0, then loop forever.
+		if err := sys.LoadBytes([]byte{0x60, 0x03, 0xF0, 0x18, 0x12, 0x04}); err != nil {
+			t.Fatal(err)
+		}
+		w := NewWavRecorder()
+		w.SampleRate = rate
+		for n := 0; n < 6; n++ {
+			if err := sys.RunFrame(); err != nil {
+				t.Fatal(err)
+			}
+			w.Capture(sys)
+		}
+
+		var b bytes.Buffer
+		if err := w.Encode(&b); err != nil {
+			t.Fatal(err)
+		}
+		data := b.Bytes()
+		samples := 6 * rate / 60
+		if len(data) != 44+2*samples {
+			t.Fatalf("%d Hz: %d bytes, want a header and %d samples", rate, len(data), samples)
+		}
+		le := binary.LittleEndian
+		for _, field := range []struct {
+			name      string
+			got, want uint32
+		}{
+			{"RIFF size", le.Uint32(data[4:]), uint32(36 + 2*samples)},
+			{"format", uint32(le.Uint16(data[20:])), 1},
+			{"channels", uint32(le.Uint16(data[22:])), 1},
+			{"sample rate", le.Uint32(data[24:]), uint32(rate)},
+			{"byte rate", le.Uint32(data[28:]), uint32(2 * rate)},
+			{"bits per sample", uint32(le.Uint16(data[34:])), 16},
+			{"data size", le.Uint32(data[40:]), uint32(2 * samples)},
+		} {
+			if field.got != field.want {
+				t.Errorf("%d Hz: %s = %d, want %d", rate, field.name, field.got, field.want)
+			}
+		}
+		if string(data[0:4]) != "RIFF" || string(data[8:16]) != "WAVEfmt " || string(data[36:40]) != "data" {
+			t.Errorf("%d Hz: header % X", rate, data[:44])
+		}
+
+		// The buzzer sounds for the 3 frames of the sound timer.
+		pcm := data[44:]
+		for n := 0; n < 6; n++ {
+			silent := true
+			for i := n * rate / 60; i < (n+1)*rate/60; i++ {
+				if le.Uint16(pcm[2*i:]) != 0 {
+					silent = false
+				}
+			}
+			if silent != (n >= 3) {
+				t.Errorf("%d Hz: frame %d silent %v", rate, n, silent)
+			}
+		}
+	}
+}
+
+func TestWavRecorderEmpty(t *testing.T) {
+	if err := NewWavRecorder().Encode(&bytes.Buffer{}); err == nil {
+		t.Error("encoded a recording without frames")
+	}
+}
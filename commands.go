@@ -0,0 +1,580 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/yukinarit/ebiten8/chip8"
+	"github.com/yukinarit/ebiten8/octo"
+)
+
+// Subcommands which run the emulator without opening a window.
+var COMMANDS = map[string]func(args []string) error{
+	"record":    recordCommand,
+	"diff":      diffCommand,
+	"tracediff": traceDiffCommand,
+	"gdb":       gdbCommand,
+	"dap":       dapCommand,
+	"compile":   compileCommand,
+	"disasm":    disasmCommand,
+	"sprites":   spritesCommand,
+	"patch":     patchCommand,
+}
+
+// Load a ROM into a Chip8 scene that is never shown, applying the settings
+// and palette the GUI would use.
+func headlessChip8(rom, paletteName, quirks, timing string) (*Chip8, error) {
+	settings, err := loadSettings()
+	if err != nil {
+		log.Printf("Failed to load settings, not saving any: %v", err)
+		settings = nil
+	}
+	sys := chip8.NewSystem()
+	if err := configureSystem(sys, quirks, timing); err != nil {
+		return nil, err
+	}
+	c8 := NewChip8(sys, settings)
+	if err := c8.Load(rom); err != nil {
+		return nil, err
+	}
+	if paletteName != "" {
+		p, ok := chip8.FindPalette(paletteName)
+		if !ok {
+			return nil, fmt.Errorf("unknown palette %q", paletteName)
+		}
+		c8.palette = p
+	}
+	return c8, nil
+}
+
+// The interpreter and monitor ROM images of a COSMAC VIP.
+type vipImages struct {
+	interpreter, monitor []byte
+}
+
+// Read the interpreter image, and the monitor ROM image if one is given.
+func loadVIPImages(interpreter, monitor string) (*vipImages, error) {
+	images := &vipImages{}
+	var err error
+	if images.interpreter, err = ioutil.ReadFile(interpreter); err != nil {
+		return nil, err
+	}
+	if monitor != "" {
+		if images.monitor, err = ioutil.ReadFile(monitor); err != nil {
+			return nil, err
+		}
+	}
+	return images, nil
+}
+
+// Build a VIP with 4 KB of RAM running the images, with the program loaded.
+// Trailing zeros are left out, as RAM starts cleared.
+func (images *vipImages) load(prog []byte) (*chip8.VIP, error) {
+	vip, err := chip8.NewVIP(images.interpreter, 0x1000)
+	if err != nil {
+		return nil, err
+	}
+	if images.monitor != nil {
+		if err := vip.LoadMonitor(images.monitor); err != nil {
+			return nil, err
+		}
+	}
+	return vip, vip.LoadBytes(bytes.TrimRight(prog, "\x00"))
+}
+
+// Load a ROM into a COSMAC VIP with 4 KB of RAM running the interpreter
+// image, and the monitor ROM image if one is given.
+func loadVIP(interpreter, monitor, rom string) (*chip8.VIP, error) {
+	images, err := loadVIPImages(interpreter, monitor)
+	if err != nil {
+		return nil, err
+	}
+	prog, err := ioutil.ReadFile(rom)
+	if err != nil {
+		return nil, err
+	}
+	return images.load(prog)
+}
+
+// ebiten8 record [flags] ROM
+func recordCommand(args []string) error {
+	fs := flag.NewFlagSet("record", flag.ExitOnError)
+	frames := fs.Int("frames", 0, "Frames to record (default: replay length plus one second, or 600)")
+	replayPath := fs.String("replay", "", "Replay file with scripted input")
+	scale := fs.Int("scale", SCALE/2, "Pixel size")
+	paletteName := fs.String("palette", "", "Display palette")
+	out := fs.String("o", "", "Output file, .gif or .png for APNG (default: named after the ROM)")
+	wavPath := fs.String("wav", "", "Also render the buzzer to this WAV file")
+	quirks := fs.String("quirks", "", "Quirk profile: chip8, schip, xochip or modern")
+	timing := fs.String("timing", "", "Instructions per frame: tickrate or vip")
+	interpreter := fs.String("interpreter", "", "Run the original interpreter from this image on an emulated COSMAC VIP")
+	monitor := fs.String("monitor", "", "Image of the VIP monitor ROM, for its interrupt routine")
+	symbols := fs.String("symbols", "", "Symbol file of the program, for the trace (default: the .sym file next to the ROM)")
+	trace := addTraceFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: ebiten8 record [flags] ROM")
+	}
+
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	c8, err := headlessChip8(fs.Arg(0), *paletteName, *quirks, *timing)
+	if err != nil {
+		return err
+	}
+	sys := c8.sys
+	closeTrace, err := trace.open(sys.Cpu)
+	if err != nil {
+		return err
+	}
+	defer closeTrace()
+	if *symbols != "" {
+		if err := c8.LoadSymbols(*symbols); err != nil {
+			return err
+		}
+	}
+
+	n := *frames
+	if *replayPath != "" {
+		if sys.Replay, err = chip8.LoadReplay(*replayPath); err != nil {
+			return err
+		}
+		if n == 0 {
+			n = sys.Replay.Frames() + 60
+		}
+	}
+	if n == 0 {
+		n = 600
+	}
+
+	var m chip8.Machine = sys
+	if *interpreter != "" {
+		vip, err := loadVIP(*interpreter, *monitor, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		vip.Replay = sys.Replay
+		m = vip
+	}
+
+	recorder := chip8.NewRecorder(*scale, c8.palette.Colors)
+	wav := chip8.NewWavRecorder()
+	for frame := 0; frame < n; frame++ {
+		if err := m.RunFrame(); err != nil {
+			return err
+		}
+		recorder.Capture(m.Screen())
+		wav.Capture(m)
+	}
+	if err := closeTrace(); err != nil {
+		return err
+	}
+	if *wavPath != "" {
+		if err := wav.Save(*wavPath); err != nil {
+			return err
+		}
+	}
+
+	path := *out
+	if path == "" {
+		path = chip8.RecordingName(filepath.Base(fs.Arg(0)), n)
+	}
+	if err := recorder.Save(path); err != nil {
+		return err
+	}
+	fmt.Printf("%d frames (%d unique) written to %s\n", n, recorder.Frames(), path)
+	return nil
+}
+
+// Build the machine an engine of diff or gdb names: a quirk profile for the
+// built-in core, or vip for the COSMAC VIP.
+func diffMachine(engine, timing, interpreter, monitor, rom string) (chip8.Machine, error) {
+	if engine == "vip" {
+		if interpreter == "" {
+			return nil, fmt.Errorf("the vip engine needs -interpreter")
+		}
+		return loadVIP(interpreter, monitor, rom)
+	}
+	sys := chip8.NewSystem()
+	sys.Cpu.Seed(1)
+	if err := configureSystem(sys, engine, timing); err != nil {
+		return nil, err
+	}
+	prog, err := ioutil.ReadFile(rom)
+	if err != nil {
+		return nil, err
+	}
+	return sys, sys.LoadBytes(prog)
+}
+
+// ebiten8 diff [flags] ROM
+func diffCommand(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	steps := fs.Int("steps", 100000, "Instructions to compare")
+	replayPath := fs.String("replay", "", "Replay file with scripted input for both machines")
+	engineA := fs.String("a", "chip8", "First engine: a quirk profile or vip")
+	engineB := fs.String("b", "vip", "Second engine: a quirk profile or vip")
+	timing := fs.String("timing", "", "Instructions per frame of the built-in core: tickrate or vip")
+	interpreter := fs.String("interpreter", "", "Interpreter image for the vip engine")
+	monitor := fs.String("monitor", "", "Monitor ROM image for the vip engine")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: ebiten8 diff [flags] ROM")
+	}
+
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	var machines []chip8.Machine
+	for _, engine := range []string{*engineA, *engineB} {
+		m, err := diffMachine(engine, *timing, *interpreter, *monitor, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		if *replayPath != "" {
+			replay, err := chip8.LoadReplay(*replayPath)
+			if err != nil {
+				return err
+			}
+			switch m := m.(type) {
+			case *chip8.System:
+				m.Replay = replay
+			case *chip8.VIP:
+				m.Replay = replay
+			}
+		}
+		machines = append(machines, m)
+	}
+
+	l := chip8.NewLockstep(machines[0], machines[1])
+	// The VIP counts frames by time, so its timers run apart.
+	l.Timers = *engineA != "vip" && *engineB != "vip"
+	d, err := l.Run(*steps)
+	if err != nil {
+		return err
+	}
+	if d != nil {
+		fmt.Print(d)
+		return fmt.Errorf("%s and %s diverged", *engineA, *engineB)
+	}
+	fmt.Printf("%s and %s agree for %d instructions\n", *engineA, *engineB, *steps)
+	return nil
+}
+
+func readTrace(path string) ([]chip8.TraceEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	entries, err := chip8.ReadTrace(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return entries, nil
+}
+
+// ebiten8 tracediff [flags] A B
+func traceDiffCommand(args []string) error {
+	fs := flag.NewFlagSet("tracediff", flag.ExitOnError)
+	context := fs.Int("context", 5, "Instructions to show before the mismatch")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: ebiten8 tracediff [flags] A B")
+	}
+	a, err := readTrace(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	b, err := readTrace(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	m, compared := chip8.DiffTraces(a, b, *context)
+	if m != nil {
+		fmt.Println(m)
+		return fmt.Errorf("traces differ after %d instructions", compared)
+	}
+	fmt.Printf("traces agree for %d instructions\n", compared)
+	return nil
+}
+
+// ebiten8 gdb [flags] ROM
+func gdbCommand(args []string) error {
+	fs := flag.NewFlagSet("gdb", flag.ExitOnError)
+	listen := fs.String("listen", "localhost:1234", "TCP address to serve debuggers on")
+	engine := fs.String("engine", "modern", "Engine: a quirk profile or vip")
+	timing := fs.String("timing", "", "Instructions per frame of the built-in core: tickrate or vip")
+	interpreter := fs.String("interpreter", "", "Interpreter image for the vip engine")
+	monitor := fs.String("monitor", "", "Monitor ROM image for the vip engine")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: ebiten8 gdb [flags] ROM")
+	}
+
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	m, err := diffMachine(*engine, *timing, *interpreter, *monitor, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Serving GDB on %s\n", *listen)
+	return chip8.NewGDBServer(m).ListenAndServe(*listen)
+}
+
+// Load the program of a DAP launch request. Octo source (.8o) is compiled
+// with a source map, so breakpoints can be set on its lines, and so are ROM
+// images with a symbol file next to them. Cartridges only name their stack
+// frames after labels; others are debugged by address.
+func launchDAP(args json.RawMessage) (chip8.Machine, *chip8.SourceMap, error) {
+	var a struct {
+		Program string `json:"program"`
+		Quirks  string `json:"quirks"`
+		Timing  string `json:"timing"`
+	}
+	if err := json.Unmarshal(args, &a); err != nil {
+		return nil, nil, err
+	}
+	if a.Program == "" {
+		return nil, nil, fmt.Errorf("launch needs a program")
+	}
+	if !strings.EqualFold(filepath.Ext(a.Program), ".8o") {
+		c8, err := headlessChip8(a.Program, "", a.Quirks, a.Timing)
+		if err != nil {
+			return nil, nil, err
+		}
+		return c8.sys, c8.symbols, nil
+	}
+	source, err := ioutil.ReadFile(a.Program)
+	if err != nil {
+		return nil, nil, err
+	}
+	sys := chip8.NewSystem()
+	if err := configureSystem(sys, a.Quirks, a.Timing); err != nil {
+		return nil, nil, err
+	}
+	prog, err := octo.Compile(string(source), sys.Mem.ProgramSize())
+	if err != nil {
+		return nil, nil, err
+	}
+	path, err := filepath.Abs(a.Program)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sys, chip8.NewSourceMap(path, string(source), prog.Labels, prog.Lines), sys.LoadBytes(prog.Rom)
+}
+
+// The standard input and output, as the connection to an editor.
+type stdio struct {
+	io.Reader
+	io.Writer
+}
+
+// ebiten8 dap [flags]
+func dapCommand(args []string) error {
+	fs := flag.NewFlagSet("dap", flag.ExitOnError)
+	listen := fs.String("listen", "", "TCP address to serve editors on (default: standard input and output)")
+	fs.Parse(args)
+
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	server := chip8.NewDAPServer(launchDAP)
+	if *listen == "" {
+		err := server.ServeConn(stdio{os.Stdin, os.Stdout})
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	fmt.Printf("Serving DAP on %s\n", l.Addr())
+	return server.Serve(l)
+}
+
+// ebiten8 compile [flags] SOURCE.8o
+func compileCommand(args []string) error {
+	fs := flag.NewFlagSet("compile", flag.ExitOnError)
+	out := fs.String("o", "", "Output ROM (default: named after the source, .ch8)")
+	symbols := fs.String("symbols", "", "Symbol file with the labels and source lines (default: named after the ROM, .sym)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: ebiten8 compile [flags] SOURCE.8o")
+	}
+
+	path, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	prog, err := octo.Compile(string(source), chip8.NewSystem().Mem.ProgramSize())
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		*out = strings.TrimSuffix(fs.Arg(0), filepath.Ext(fs.Arg(0))) + ".ch8"
+	}
+	if *symbols == "" {
+		*symbols = chip8.SymbolsName(*out)
+	}
+	if err := ioutil.WriteFile(*out, prog.Rom, 0644); err != nil {
+		return err
+	}
+	if err := chip8.NewSourceMap(path, string(source), prog.Labels, prog.Lines).Save(*symbols); err != nil {
+		return err
+	}
+	fmt.Printf("%d bytes written to %s, symbols to %s\n", len(prog.Rom), *out, *symbols)
+	return nil
+}
+
+// ebiten8 disasm [flags] ROM
+func disasmCommand(args []string) error {
+	fs := flag.NewFlagSet("disasm", flag.ExitOnError)
+	symbolsPath := fs.String("symbols", "", "Symbol file of the ROM (default: the .sym file next to it, if any)")
+	pc := fs.String("pc", "", "Hex address range to list, such as 200-2FF (default: the whole ROM)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: ebiten8 disasm [flags] ROM")
+	}
+
+	rom, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	var symbols *chip8.SourceMap
+	if *symbolsPath == "" {
+		if _, err := os.Stat(chip8.SymbolsName(fs.Arg(0))); err == nil {
+			*symbolsPath = chip8.SymbolsName(fs.Arg(0))
+		}
+	}
+	if *symbolsPath != "" {
+		if symbols, err = chip8.LoadSourceMap(*symbolsPath); err != nil {
+			return err
+		}
+	}
+	ram := append(make([]byte, chip8.PROGRAM_START), rom...)
+	start, end := chip8.PROGRAM_START, len(ram)
+	if *pc != "" {
+		from, to, err := chip8.ParseAddressRange(*pc)
+		if err != nil {
+			return err
+		}
+		start, end = int(from), int(to)+1
+	}
+	return chip8.WriteDisassembly(os.Stdout, ram, start, end, symbols)
+}
+
+// ebiten8 sprites [flags] ROM
+func spritesCommand(args []string) error {
+	fs := flag.NewFlagSet("sprites", flag.ExitOnError)
+	frames := fs.Int("frames", 0, "Frames to run (default: replay length plus one second, or 600)")
+	replayPath := fs.String("replay", "", "Replay file with scripted input, to reach more sprites")
+	scale := fs.Int("scale", 8, "Pixel size of the images")
+	dir := fs.String("o", "", "Directory for the images (default: named after the ROM)")
+	quirks := fs.String("quirks", "", "Quirk profile: chip8, schip, xochip or modern")
+	timing := fs.String("timing", "", "Instructions per frame: tickrate or vip")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: ebiten8 sprites [flags] ROM")
+	}
+	if strings.EqualFold(filepath.Ext(fs.Arg(0)), ".gif") {
+		return fmt.Errorf("sprites are taken from ROM images; compile the cartridge first")
+	}
+
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	rom, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	c8, err := headlessChip8(fs.Arg(0), "", *quirks, *timing)
+	if err != nil {
+		return err
+	}
+	sys := c8.sys
+	n := *frames
+	if *replayPath != "" {
+		if sys.Replay, err = chip8.LoadReplay(*replayPath); err != nil {
+			return err
+		}
+		if n == 0 {
+			n = sys.Replay.Frames() + 60
+		}
+	}
+	if n == 0 {
+		n = 600
+	}
+	for frame := 0; frame < n; frame++ {
+		if err := sys.RunFrame(); err != nil {
+			return err
+		}
+	}
+
+	if *dir == "" {
+		*dir = chip8.SpritesDir(fs.Arg(0))
+	}
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		return err
+	}
+	// Only sprites in the ROM can be patched back; the font and sprites
+	// built at run time are listed without an image.
+	for _, s := range sys.Cpu.Sprites.Sprites() {
+		if int(s.Addr) < chip8.PROGRAM_START || int(s.Addr)+s.Height > chip8.PROGRAM_START+len(rom) {
+			fmt.Printf("%03X %2d rows %6d draws  not in the ROM\n", s.Addr, s.Height, s.Draws)
+			continue
+		}
+		path := filepath.Join(*dir, chip8.SpriteName(fs.Arg(0), s))
+		if err := chip8.SaveSprite(path, s.Data(sys.RAM()), *scale); err != nil {
+			return err
+		}
+		fmt.Printf("%03X %2d rows %6d draws  %s\n", s.Addr, s.Height, s.Draws, path)
+	}
+	return nil
+}
+
+// ebiten8 patch [flags] ROM SPRITE.png...
+func patchCommand(args []string) error {
+	fs := flag.NewFlagSet("patch", flag.ExitOnError)
+	out := fs.String("o", "", "Patched ROM (default: named after the ROM, -patched)")
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		return fmt.Errorf("usage: ebiten8 patch [flags] ROM SPRITE.png...")
+	}
+
+	rom, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	for _, path := range fs.Args()[1:] {
+		s, data, err := chip8.LoadSprite(path)
+		if err != nil {
+			return err
+		}
+		if err := chip8.PatchSprite(rom, s.Addr, data); err != nil {
+			return err
+		}
+	}
+	if *out == "" {
+		ext := filepath.Ext(fs.Arg(0))
+		*out = strings.TrimSuffix(fs.Arg(0), ext) + "-patched" + ext
+	}
+	if err := ioutil.WriteFile(*out, rom, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("%d sprites patched into %s\n", fs.NArg()-1, *out)
+	return nil
+}
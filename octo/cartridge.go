@@ -0,0 +1,113 @@
+// Package octo reads programs written for the Octo CHIP-8 IDE.
+package octo
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
+	"image/gif"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Options are the emulator settings Octo stores alongside a program.
+type Options struct {
+	Tickrate        int    `json:"tickrate"`
+	FillColor       string `json:"fillColor"`
+	FillColor2      string `json:"fillColor2"`
+	BlendColor      string `json:"blendColor"`
+	BackgroundColor string `json:"backgroundColor"`
+	BuzzColor       string `json:"buzzColor"`
+	QuietColor      string `json:"quietColor"`
+	ShiftQuirks     bool   `json:"shiftQuirks"`
+	LoadStoreQuirks bool   `json:"loadStoreQuirks"`
+	JumpQuirks      bool   `json:"jumpQuirks"`
+	LogicQuirks     bool   `json:"logicQuirks"`
+	ClipQuirks      bool   `json:"clipQuirks"`
+	VBlankQuirks    bool   `json:"vBlankQuirks"`
+	MaxSize         int    `json:"maxSize"`
+	ScreenRotation  int    `json:"screenRotation"`
+	FontStyle       string `json:"fontStyle"`
+	TouchInputMode  string `json:"touchInputMode"`
+}
+
+// DefaultOptions returns the settings Octo uses for a new program.
+func DefaultOptions() Options {
+	return Options{
+		Tickrate:        20,
+		FillColor:       "#FFCC00",
+		FillColor2:      "#FF6600",
+		BlendColor:      "#662200",
+		BackgroundColor: "#996600",
+		BuzzColor:       "#FFAA00",
+		QuietColor:      "#000000",
+		MaxSize:         3584,
+		FontStyle:       "octo",
+		TouchInputMode:  "none",
+	}
+}
+
+// Cartridge is the payload of an Octo cartridge: program source and options.
+type Cartridge struct {
+	Program string  `json:"program"`
+	Options Options `json:"options"`
+}
+
+// DecodeCartridge extracts the payload embedded in an Octo cartridge GIF.
+//
+// Every pixel of every frame carries two bits of data in the low bits of its
+// palette index, most significant pair first. The first four bytes are the
+// big endian length of the JSON document that follows.
+func DecodeCartridge(r io.Reader) (*Cartridge, error) {
+	g, err := gif.DecodeAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	w, h := g.Config.Width, g.Config.Height
+	data := []byte{}
+	pix := make([]uint8, w*h+3)
+	for _, frame := range g.Image {
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				pix[y*w+x] = frame.ColorIndexAt(x, y) & 3
+			}
+		}
+		for z := 0; z < w*h; z += 4 {
+			data = append(data, pix[z]<<6|pix[z+1]<<4|pix[z+2]<<2|pix[z+3])
+		}
+	}
+
+	if len(data) < 4 {
+		return nil, errors.New("octo: cartridge is too small")
+	}
+	n := binary.BigEndian.Uint32(data)
+	if uint64(n) > uint64(len(data)-4) {
+		return nil, fmt.Errorf("octo: cartridge payload of %d bytes exceeds image data", n)
+	}
+
+	cart := &Cartridge{Options: DefaultOptions()}
+	if err := json.Unmarshal(data[4:4+n], cart); err != nil {
+		return nil, fmt.Errorf("octo: malformed cartridge payload: %v", err)
+	}
+	return cart, nil
+}
+
+// ParseColor parses an Octo color string such as "#FFCC00".
+func ParseColor(s string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return color.RGBA{}, fmt.Errorf("octo: invalid color %q", s)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("octo: invalid color %q", s)
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xFF}, nil
+}
@@ -0,0 +1,157 @@
+package octo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/gif"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// encodeCartridge hides payload in the low two bits of the pixels of a GIF
+// the way Octo does, in as many frames of w*h pixels as it takes.
+func encodeCartridge(t *testing.T, payload []byte, w, h int) []byte {
+	data := make([]byte, 4, 4+len(payload))
+	binary.BigEndian.PutUint32(data, uint32(len(payload)))
+	data = append(data, payload...)
+
+	palette := color.Palette{}
+	for n := 0; n < 4; n++ {
+		palette = append(palette, color.Gray{uint8(n * 0x40)})
+	}
+	g := &gif.GIF{Config: image.Config{Width: w, Height: h, ColorModel: palette}}
+	pairs := []uint8{}
+	for _, b := range data {
+		pairs = append(pairs, b>>6, b>>4&3, b>>2&3, b&3)
+	}
+	for len(pairs) > 0 {
+		frame := image.NewPaletted(image.Rect(0, 0, w, h), palette)
+		n := copy(frame.Pix, pairs)
+		pairs = pairs[n:]
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 0)
+	}
+
+	var b bytes.Buffer
+	if err := gif.EncodeAll(&b, g); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestDecodeCartridge(t *testing.T) {
+	custom := DefaultOptions()
+	custom.Tickrate = 1000
+	custom.FillColor = "#FF0000"
+	custom.ShiftQuirks = true
+	custom.ClipQuirks = true
+	custom.VBlankQuirks = true
+	custom.MaxSize = 65024
+
+	for _, tc := range []struct {
+		name    string
+		payload string
+		w, h    int
+		want    Cartridge
+	}{
+		{"defaults", `{"program": ": main jump main"}`, 32, 32, Cartridge{": main jump main", DefaultOptions()}},
+		{"several frames", `{"program": "clear"}`, 8, 8, Cartridge{"clear", DefaultOptions()}},
+		{"options", mustJSON(t, Cartridge{"loop again", custom}), 64, 64, Cartridge{"loop again", custom}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cart, err := DecodeCartridge(bytes.NewReader(encodeCartridge(t, []byte(tc.payload), tc.w, tc.h)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*cart, tc.want) {
+				t.Errorf("cartridge = %+v, want %+v", *cart, tc.want)
+			}
+		})
+	}
+}
+
+func TestDecodeCartridgeErrors(t *testing.T) {
+	tooLong := encodeCartridge(t, []byte(`{}`), 8, 8)
+	for _, tc := range []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"not a gif", []byte("CHIP-8"), "gif"},
+		{"malformed", encodeCartridge(t, []byte(`{"program": `), 16, 16), "malformed cartridge payload"},
+		{"too small", tinyGIF(t), "too small"},
+		{"too long", patchLength(t, tooLong, 1000), "exceeds image data"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := DecodeCartridge(bytes.NewReader(tc.data))
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("error = %v, want it to mention %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want color.RGBA
+		ok   bool
+	}{
+		{"#FFCC00", color.RGBA{0xFF, 0xCC, 0x00, 0xFF}, true},
+		{"996600", color.RGBA{0x99, 0x66, 0x00, 0xFF}, true},
+		{"#f60", color.RGBA{0xFF, 0x66, 0x00, 0xFF}, true},
+		{"#000", color.RGBA{0, 0, 0, 0xFF}, true},
+		{"", color.RGBA{}, false},
+		{"#FFCC", color.RGBA{}, false},
+		{"#GGGGGG", color.RGBA{}, false},
+		{"red", color.RGBA{}, false},
+	} {
+		t.Run(tc.s, func(t *testing.T) {
+			c, err := ParseColor(tc.s)
+			if (err == nil) != tc.ok {
+				t.Fatalf("error = %v, want ok %v", err, tc.ok)
+			}
+			if c != tc.want {
+				t.Errorf("color = %v, want %v", c, tc.want)
+			}
+		})
+	}
+}
+
+// tinyGIF is a single 2x2 frame, which only has room for one byte.
+func tinyGIF(t *testing.T) []byte {
+	var b bytes.Buffer
+	if err := gif.Encode(&b, image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.Black, color.White}), nil); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func mustJSON(t *testing.T, v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// patchLength decodes a cartridge GIF and rewrites the length at its start.
+func patchLength(t *testing.T, data []byte, n uint32) []byte {
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pix := g.Image[0].Pix
+	for i := 0; i < 16; i++ {
+		pix[i] = uint8(n>>(30-2*i)) & 3
+	}
+	var b bytes.Buffer
+	if err := gif.EncodeAll(&b, g); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,1238 @@
+package octo
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Program is the result of compiling Octo source.
+type Program struct {
+	Rom         []byte         // Bytes to be loaded at 0x200.
+	Labels      map[string]int // Address of every label.
+	Breakpoints map[int]string // Addresses marked with :breakpoint.
+	Lines       map[int]int    // Source line of every byte, by address.
+}
+
+type token struct {
+	text string
+	str  bool // Quoted string literal.
+	line int
+}
+
+type fixupKind int
+
+const (
+	fixAddr12 fixupKind = iota // Low 12 bits of an instruction.
+	fixAddr16                  // A full 16 bit word.
+	fixUnpack                  // The operands of a pair of vx := nn.
+	fixUnpackLong
+)
+
+type fixup struct {
+	kind fixupKind
+	addr int
+	nib  int
+	line int
+}
+
+type macro struct {
+	args []string
+	body []token
+}
+
+// stringmode maps each character of its alphabets to the body to expand and
+// the index of the character within its alphabet.
+type stringmode struct {
+	bodies map[byte][]token
+	values map[byte]int
+}
+
+type compiler struct {
+	tokens      []token
+	pos         int
+	rom         []byte
+	written     int // One past the last address written.
+	here        int
+	maxSize     int
+	labels      map[string]int
+	consts      map[string]float64
+	aliases     map[string]int
+	protos      map[string][]fixup
+	macros      map[string]*macro
+	stringmodes map[string]*stringmode
+	calls       int
+	loops       []int
+	whiles      [][]int
+	branches    []int
+	breakpoints map[int]string
+	lines       map[int]int
+	line        int
+}
+
+// Compile assembles Octo source into a program loaded at 0x200. maxSize
+// limits the size of the ROM in bytes.
+func Compile(source string, maxSize int) (*Program, error) {
+	c := &compiler{
+		tokens:      tokenize(source),
+		here:        0x200,
+		maxSize:     maxSize,
+		labels:      map[string]int{},
+		consts:      map[string]float64{},
+		aliases:     map[string]int{"compare-temp": 0xF, "unpack-hi": 0x0, "unpack-lo": 0x1},
+		protos:      map[string][]fixup{},
+		macros:      map[string]*macro{},
+		stringmodes: map[string]*stringmode{},
+		breakpoints: map[int]string{},
+		lines:       map[int]int{},
+	}
+	c.rom = make([]byte, maxSize)
+
+	for c.pos < len(c.tokens) {
+		if err := c.statement(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(c.loops) > 0 {
+		return nil, fmt.Errorf("octo: line %d: 'loop' without matching 'again'", c.line)
+	}
+	if len(c.branches) > 0 {
+		return nil, fmt.Errorf("octo: line %d: 'begin' without matching 'end'", c.line)
+	}
+	for name, fs := range c.protos {
+		return nil, fmt.Errorf("octo: line %d: undefined name '%s'", fs[0].line, name)
+	}
+
+	return &Program{c.rom[:c.written], c.labels, c.breakpoints, c.lines}, nil
+}
+
+func tokenize(source string) []token {
+	tokens := []token{}
+	for n, line := range strings.Split(source, "\n") {
+		for i := 0; i < len(line); {
+			switch ch := line[i]; {
+			case ch == ' ' || ch == '\t' || ch == '\r':
+				i++
+			case ch == '#':
+				i = len(line)
+			case ch == '"':
+				var b strings.Builder
+				i++
+				for i < len(line) && line[i] != '"' {
+					if line[i] == '\\' && i+1 < len(line) {
+						i++
+						switch line[i] {
+						case 'n':
+							b.WriteByte('\n')
+						case 't':
+							b.WriteByte('\t')
+						case '0':
+							b.WriteByte(0)
+						default:
+							b.WriteByte(line[i])
+						}
+					} else {
+						b.WriteByte(line[i])
+					}
+					i++
+				}
+				i++
+				tokens = append(tokens, token{b.String(), true, n + 1})
+			default:
+				j := i
+				for j < len(line) && line[j] != ' ' && line[j] != '\t' && line[j] != '\r' && line[j] != '#' {
+					j++
+				}
+				tokens = append(tokens, token{line[i:j], false, n + 1})
+				i = j
+			}
+		}
+	}
+	return tokens
+}
+
+func (c *compiler) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("octo: line %d: %s", c.line, fmt.Sprintf(format, args...))
+}
+
+func (c *compiler) next() (token, error) {
+	if c.pos >= len(c.tokens) {
+		return token{}, c.errorf("unexpected end of file")
+	}
+	t := c.tokens[c.pos]
+	c.pos++
+	c.line = t.line
+	return t, nil
+}
+
+func (c *compiler) peek() string {
+	if c.pos >= len(c.tokens) {
+		return ""
+	}
+	return c.tokens[c.pos].text
+}
+
+func (c *compiler) expect(text string) error {
+	t, err := c.next()
+	if err != nil {
+		return err
+	}
+	if t.text != text || t.str {
+		return c.errorf("expected '%s', got '%s'", text, t.text)
+	}
+	return nil
+}
+
+func (c *compiler) emit(bytes ...byte) error {
+	for _, b := range bytes {
+		if c.here-0x200 < 0 || c.here-0x200 >= c.maxSize {
+			return c.errorf("program exceeds the maximum size of %d bytes", c.maxSize)
+		}
+		c.rom[c.here-0x200] = b
+		c.lines[c.here] = c.line
+		c.here++
+		if c.here-0x200 > c.written {
+			c.written = c.here - 0x200
+		}
+	}
+	return nil
+}
+
+func (c *compiler) inst(hi, lo int) error {
+	return c.emit(byte(hi), byte(lo))
+}
+
+func (c *compiler) patch(f fixup, value int) error {
+	i := f.addr - 0x200
+	switch f.kind {
+	case fixAddr12:
+		if value < 0 || value > 0xFFF {
+			return fmt.Errorf("octo: line %d: address 0x%X does not fit in 12 bits", f.line, value)
+		}
+		c.rom[i] = c.rom[i]&0xF0 | byte(value>>8)
+		c.rom[i+1] = byte(value)
+	case fixAddr16:
+		c.rom[i] = byte(value >> 8)
+		c.rom[i+1] = byte(value)
+	case fixUnpack:
+		c.rom[i+1] = byte(f.nib<<4 | value>>8&0xF)
+		c.rom[i+3] = byte(value)
+	case fixUnpackLong:
+		c.rom[i+1] = byte(value >> 8)
+		c.rom[i+3] = byte(value)
+	}
+	return nil
+}
+
+// reference resolves a name to an address, deferring labels not yet defined.
+func (c *compiler) reference(t token, kind fixupKind, addr, nib int) (int, error) {
+	if v, ok, err := c.constant(t); ok || err != nil {
+		return v, err
+	}
+	if !isName(t.text) {
+		return 0, c.errorf("expected a name or number, got '%s'", t.text)
+	}
+	c.protos[t.text] = append(c.protos[t.text], fixup{kind, addr, nib, t.line})
+	return 0, nil
+}
+
+// constant evaluates a number, constant, defined label or { calc } expression.
+func (c *compiler) constant(t token) (int, bool, error) {
+	if t.text == "{" && !t.str {
+		c.pos--
+		v, err := c.calc()
+		return int(v), true, err
+	}
+	if v, ok := parseNumber(t.text); ok {
+		return v, true, nil
+	}
+	if v, ok := c.consts[t.text]; ok {
+		return int(v), true, nil
+	}
+	if v, ok := c.labels[t.text]; ok {
+		return v, true, nil
+	}
+	return 0, false, nil
+}
+
+func (c *compiler) value() (int, error) {
+	t, err := c.next()
+	if err != nil {
+		return 0, err
+	}
+	v, ok, err := c.constant(t)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, c.errorf("undefined name '%s'", t.text)
+	}
+	return v, nil
+}
+
+func (c *compiler) byteValue() (int, error) {
+	v, err := c.value()
+	if err != nil {
+		return 0, err
+	}
+	if v < -128 || v > 255 {
+		return 0, c.errorf("value %d does not fit in a byte", v)
+	}
+	return v & 0xFF, nil
+}
+
+func (c *compiler) nibble() (int, error) {
+	v, err := c.value()
+	if err != nil {
+		return 0, err
+	}
+	if v < 0 || v > 15 {
+		return 0, c.errorf("value %d does not fit in a nibble", v)
+	}
+	return v, nil
+}
+
+func parseNumber(s string) (int, bool) {
+	neg := strings.HasPrefix(s, "-")
+	digits := strings.TrimPrefix(s, "-")
+	var v int64
+	var err error
+	switch {
+	case strings.HasPrefix(digits, "0x"), strings.HasPrefix(digits, "0X"):
+		v, err = strconv.ParseInt(digits[2:], 16, 64)
+	case strings.HasPrefix(digits, "0b"), strings.HasPrefix(digits, "0B"):
+		v, err = strconv.ParseInt(digits[2:], 2, 64)
+	default:
+		v, err = strconv.ParseInt(digits, 10, 64)
+	}
+	if err != nil {
+		return 0, false
+	}
+	if neg {
+		v = -v
+	}
+	return int(v), true
+}
+
+func isName(s string) bool {
+	if s == "" {
+		return false
+	}
+	if _, ok := parseNumber(s); ok {
+		return false
+	}
+	return !strings.ContainsAny(s, "{}:")
+}
+
+func (c *compiler) isRegister(s string) bool {
+	_, ok := c.registerIndex(s)
+	return ok
+}
+
+func (c *compiler) registerIndex(s string) (int, bool) {
+	if len(s) == 2 && (s[0] == 'v' || s[0] == 'V') {
+		if v, err := strconv.ParseUint(s[1:], 16, 8); err == nil {
+			return int(v), true
+		}
+	}
+	v, ok := c.aliases[s]
+	return v, ok
+}
+
+func (c *compiler) register() (int, error) {
+	t, err := c.next()
+	if err != nil {
+		return 0, err
+	}
+	r, ok := c.registerIndex(t.text)
+	if !ok {
+		return 0, c.errorf("expected a register, got '%s'", t.text)
+	}
+	return r, nil
+}
+
+func (c *compiler) define(name string, addr int) error {
+	if _, ok := c.labels[name]; ok {
+		return c.errorf("the name '%s' has already been defined", name)
+	}
+	if !isName(name) || c.isRegister(name) {
+		return c.errorf("invalid name '%s'", name)
+	}
+	c.labels[name] = addr
+	for _, f := range c.protos[name] {
+		if err := c.patch(f, addr); err != nil {
+			return err
+		}
+	}
+	delete(c.protos, name)
+	return nil
+}
+
+func (c *compiler) statement() error {
+	t, err := c.next()
+	if err != nil {
+		return err
+	}
+	if t.str {
+		return c.errorf("unexpected string \"%s\"", t.text)
+	}
+
+	switch t.text {
+	case ":":
+		name, err := c.next()
+		if err != nil {
+			return err
+		}
+		return c.define(name.text, c.here)
+	case ":next":
+		name, err := c.next()
+		if err != nil {
+			return err
+		}
+		return c.define(name.text, c.here+1)
+	case ":alias":
+		name, err := c.next()
+		if err != nil {
+			return err
+		}
+		if c.peek() == "{" {
+			v, err := c.value()
+			if err != nil {
+				return err
+			}
+			c.aliases[name.text] = v & 0xF
+			return nil
+		}
+		r, err := c.register()
+		if err != nil {
+			return err
+		}
+		c.aliases[name.text] = r
+		return nil
+	case ":const":
+		name, err := c.next()
+		if err != nil {
+			return err
+		}
+		v, err := c.value()
+		if err != nil {
+			return err
+		}
+		c.consts[name.text] = float64(v)
+		return nil
+	case ":calc":
+		name, err := c.next()
+		if err != nil {
+			return err
+		}
+		v, err := c.calc()
+		if err != nil {
+			return err
+		}
+		c.consts[name.text] = v
+		return nil
+	case ":org":
+		v, err := c.value()
+		if err != nil {
+			return err
+		}
+		c.here = v
+		return nil
+	case ":byte":
+		v, err := c.byteValue()
+		if err != nil {
+			return err
+		}
+		return c.emit(byte(v))
+	case ":pointer":
+		addr := c.here
+		if err := c.emit(0, 0); err != nil {
+			return err
+		}
+		name, err := c.next()
+		if err != nil {
+			return err
+		}
+		v, err := c.reference(name, fixAddr16, addr, 0)
+		if err != nil {
+			return err
+		}
+		return c.patch(fixup{fixAddr16, addr, 0, c.line}, v)
+	case ":call":
+		return c.call(0x20)
+	case ":unpack":
+		return c.unpack()
+	case ":breakpoint":
+		name, err := c.next()
+		if err != nil {
+			return err
+		}
+		c.breakpoints[c.here] = name.text
+		return nil
+	case ":monitor":
+		if _, err := c.next(); err != nil {
+			return err
+		}
+		_, err := c.next()
+		return err
+	case ":proto":
+		_, err := c.next()
+		return err
+	case ":assert":
+		msg := "assertion failed"
+		if c.pos < len(c.tokens) && c.tokens[c.pos].str {
+			msg = c.tokens[c.pos].text
+			c.pos++
+		}
+		v, err := c.calc()
+		if err != nil {
+			return err
+		}
+		if v == 0 {
+			return c.errorf("%s", msg)
+		}
+		return nil
+	case ":macro":
+		return c.defineMacro()
+	case ":stringmode":
+		return c.defineStringmode()
+	case ";", "return":
+		return c.inst(0x00, 0xEE)
+	case "clear":
+		return c.inst(0x00, 0xE0)
+	case "hires":
+		return c.inst(0x00, 0xFF)
+	case "lores":
+		return c.inst(0x00, 0xFE)
+	case "exit":
+		return c.inst(0x00, 0xFD)
+	case "scroll-left":
+		return c.inst(0x00, 0xFC)
+	case "scroll-right":
+		return c.inst(0x00, 0xFB)
+	case "scroll-down", "scroll-up":
+		n, err := c.nibble()
+		if err != nil {
+			return err
+		}
+		if t.text == "scroll-down" {
+			return c.inst(0x00, 0xC0|n)
+		}
+		return c.inst(0x00, 0xD0|n)
+	case "audio":
+		return c.inst(0xF0, 0x02)
+	case "plane":
+		n, err := c.nibble()
+		if err != nil {
+			return err
+		}
+		return c.inst(0xF0|n, 0x01)
+	case "bcd":
+		return c.fx(0x33)
+	case "saveflags":
+		return c.fx(0x75)
+	case "loadflags":
+		return c.fx(0x85)
+	case "save", "load":
+		x, err := c.register()
+		if err != nil {
+			return err
+		}
+		if c.peek() == "-" {
+			c.pos++
+			y, err := c.register()
+			if err != nil {
+				return err
+			}
+			if t.text == "save" {
+				return c.inst(0x50|x, y<<4|0x2)
+			}
+			return c.inst(0x50|x, y<<4|0x3)
+		}
+		if t.text == "save" {
+			return c.inst(0xF0|x, 0x55)
+		}
+		return c.inst(0xF0|x, 0x65)
+	case "sprite":
+		x, err := c.register()
+		if err != nil {
+			return err
+		}
+		y, err := c.register()
+		if err != nil {
+			return err
+		}
+		n, err := c.nibble()
+		if err != nil {
+			return err
+		}
+		return c.inst(0xD0|x, y<<4|n)
+	case "jump":
+		return c.call(0x10)
+	case "jump0":
+		return c.call(0xB0)
+	case "native":
+		return c.call(0x00)
+	case "delay", "buzzer", "pitch":
+		if err := c.expect(":="); err != nil {
+			return err
+		}
+		x, err := c.register()
+		if err != nil {
+			return err
+		}
+		switch t.text {
+		case "delay":
+			return c.inst(0xF0|x, 0x15)
+		case "buzzer":
+			return c.inst(0xF0|x, 0x18)
+		}
+		return c.inst(0xF0|x, 0x3A)
+	case "i":
+		return c.assignI()
+	case "loop":
+		c.loops = append(c.loops, c.here)
+		c.whiles = append(c.whiles, nil)
+		return nil
+	case "while":
+		if len(c.loops) == 0 {
+			return c.errorf("'while' outside of a loop")
+		}
+		if err := c.conditional(true); err != nil {
+			return err
+		}
+		n := len(c.whiles) - 1
+		c.whiles[n] = append(c.whiles[n], c.here)
+		return c.inst(0x10, 0x00)
+	case "again":
+		if len(c.loops) == 0 {
+			return c.errorf("'again' without matching 'loop'")
+		}
+		n := len(c.loops) - 1
+		start := c.loops[n]
+		if err := c.inst(0x10|start>>8, start); err != nil {
+			return err
+		}
+		for _, addr := range c.whiles[n] {
+			if err := c.patch(fixup{fixAddr12, addr, 0, c.line}, c.here); err != nil {
+				return err
+			}
+		}
+		c.loops = c.loops[:n]
+		c.whiles = c.whiles[:n]
+		return nil
+	case "if":
+		return c.ifStatement()
+	case "else":
+		if len(c.branches) == 0 {
+			return c.errorf("'else' without matching 'begin'")
+		}
+		n := len(c.branches) - 1
+		addr := c.here
+		if err := c.inst(0x10, 0x00); err != nil {
+			return err
+		}
+		if err := c.patch(fixup{fixAddr12, c.branches[n], 0, c.line}, c.here); err != nil {
+			return err
+		}
+		c.branches[n] = addr
+		return nil
+	case "end":
+		if len(c.branches) == 0 {
+			return c.errorf("'end' without matching 'begin'")
+		}
+		n := len(c.branches) - 1
+		if err := c.patch(fixup{fixAddr12, c.branches[n], 0, c.line}, c.here); err != nil {
+			return err
+		}
+		c.branches = c.branches[:n]
+		return nil
+	}
+
+	if c.isRegister(t.text) {
+		c.pos--
+		return c.assignRegister()
+	}
+	if m, ok := c.macros[t.text]; ok {
+		return c.expandMacro(m)
+	}
+	if s, ok := c.stringmodes[t.text]; ok {
+		return c.expandStringmode(s)
+	}
+	if v, ok, err := c.constant(t); ok || err != nil {
+		if err != nil {
+			return err
+		}
+		if v < -128 || v > 255 {
+			return c.errorf("value %d does not fit in a byte", v)
+		}
+		return c.emit(byte(v))
+	}
+	if isName(t.text) {
+		c.pos--
+		return c.call(0x20)
+	}
+	return c.errorf("unexpected '%s'", t.text)
+}
+
+func (c *compiler) fx(lo int) error {
+	x, err := c.register()
+	if err != nil {
+		return err
+	}
+	return c.inst(0xF0|x, lo)
+}
+
+// call emits an instruction with a 12 bit address operand.
+func (c *compiler) call(op int) error {
+	t, err := c.next()
+	if err != nil {
+		return err
+	}
+	addr := c.here
+	v, err := c.reference(t, fixAddr12, addr, 0)
+	if err != nil {
+		return err
+	}
+	if err := c.inst(op, 0); err != nil {
+		return err
+	}
+	return c.patch(fixup{fixAddr12, addr, 0, c.line}, v)
+}
+
+func (c *compiler) unpack() error {
+	kind, nib := fixUnpack, 0
+	if c.peek() == "long" {
+		c.pos++
+		kind = fixUnpackLong
+	} else {
+		v, err := c.nibble()
+		if err != nil {
+			return err
+		}
+		nib = v
+	}
+	t, err := c.next()
+	if err != nil {
+		return err
+	}
+	addr := c.here
+	v, err := c.reference(t, kind, addr, nib)
+	if err != nil {
+		return err
+	}
+	if err := c.inst(0x60|c.aliases["unpack-hi"], 0); err != nil {
+		return err
+	}
+	if err := c.inst(0x60|c.aliases["unpack-lo"], 0); err != nil {
+		return err
+	}
+	return c.patch(fixup{kind, addr, nib, c.line}, v)
+}
+
+func (c *compiler) assignI() error {
+	op, err := c.next()
+	if err != nil {
+		return err
+	}
+	switch op.text {
+	case "+=":
+		return c.fx(0x1E)
+	case ":=":
+	default:
+		return c.errorf("expected ':=' or '+=' after 'i', got '%s'", op.text)
+	}
+
+	switch c.peek() {
+	case "hex":
+		c.pos++
+		return c.fx(0x29)
+	case "bighex":
+		c.pos++
+		return c.fx(0x30)
+	case "long":
+		c.pos++
+		t, err := c.next()
+		if err != nil {
+			return err
+		}
+		if err := c.inst(0xF0, 0x00); err != nil {
+			return err
+		}
+		addr := c.here
+		v, err := c.reference(t, fixAddr16, addr, 0)
+		if err != nil {
+			return err
+		}
+		if err := c.inst(0, 0); err != nil {
+			return err
+		}
+		return c.patch(fixup{fixAddr16, addr, 0, c.line}, v)
+	}
+	return c.call(0xA0)
+}
+
+func (c *compiler) assignRegister() error {
+	x, err := c.register()
+	if err != nil {
+		return err
+	}
+	op, err := c.next()
+	if err != nil {
+		return err
+	}
+
+	if op.text == ":=" {
+		switch c.peek() {
+		case "key":
+			c.pos++
+			return c.inst(0xF0|x, 0x0A)
+		case "delay":
+			c.pos++
+			return c.inst(0xF0|x, 0x07)
+		case "random":
+			c.pos++
+			n, err := c.byteValue()
+			if err != nil {
+				return err
+			}
+			return c.inst(0xC0|x, n)
+		}
+	}
+
+	if c.isRegister(c.peek()) {
+		y, err := c.register()
+		if err != nil {
+			return err
+		}
+		ops := map[string]int{":=": 0x0, "|=": 0x1, "&=": 0x2, "^=": 0x3, "+=": 0x4, "-=": 0x5, ">>=": 0x6, "=-": 0x7, "<<=": 0xE}
+		n, ok := ops[op.text]
+		if !ok {
+			return c.errorf("unknown register operator '%s'", op.text)
+		}
+		return c.inst(0x80|x, y<<4|n)
+	}
+
+	n, err := c.byteValue()
+	if err != nil {
+		return err
+	}
+	switch op.text {
+	case ":=":
+		return c.inst(0x60|x, n)
+	case "+=":
+		return c.inst(0x70|x, n)
+	case "-=":
+		return c.inst(0x70|x, -n&0xFF)
+	}
+	return c.errorf("operator '%s' requires a register operand", op.text)
+}
+
+func (c *compiler) ifStatement() error {
+	// Peek past the condition to see whether this is a block.
+	start := c.pos
+	block := false
+	for i := start; i < len(c.tokens); i++ {
+		if c.tokens[i].text == "then" {
+			break
+		}
+		if c.tokens[i].text == "begin" {
+			block = true
+			break
+		}
+	}
+
+	if err := c.conditional(block); err != nil {
+		return err
+	}
+	t, err := c.next()
+	if err != nil {
+		return err
+	}
+	if block {
+		if t.text != "begin" {
+			return c.errorf("expected 'begin', got '%s'", t.text)
+		}
+		c.branches = append(c.branches, c.here)
+		return c.inst(0x10, 0x00)
+	}
+	if t.text != "then" {
+		return c.errorf("expected 'then', got '%s'", t.text)
+	}
+	return nil
+}
+
+// conditional emits code which skips the next instruction when the condition
+// does not hold, or when it does hold if negated.
+func (c *compiler) conditional(negated bool) error {
+	x, err := c.register()
+	if err != nil {
+		return err
+	}
+	op, err := c.next()
+	if err != nil {
+		return err
+	}
+	cmp := op.text
+	if negated {
+		inverse := map[string]string{"==": "!=", "!=": "==", "key": "-key", "-key": "key", "<": ">=", ">=": "<", ">": "<=", "<=": ">"}
+		inv, ok := inverse[cmp]
+		if !ok {
+			return c.errorf("unknown comparison '%s'", cmp)
+		}
+		cmp = inv
+	}
+
+	switch cmp {
+	case "key":
+		return c.inst(0xE0|x, 0xA1)
+	case "-key":
+		return c.inst(0xE0|x, 0x9E)
+	}
+
+	tmp := c.aliases["compare-temp"]
+	var y int
+	if c.isRegister(c.peek()) {
+		if y, err = c.register(); err != nil {
+			return err
+		}
+		switch cmp {
+		case "==":
+			return c.inst(0x90|x, y<<4)
+		case "!=":
+			return c.inst(0x50|x, y<<4)
+		}
+	} else {
+		n, err := c.byteValue()
+		if err != nil {
+			return err
+		}
+		switch cmp {
+		case "==":
+			return c.inst(0x40|x, n)
+		case "!=":
+			return c.inst(0x30|x, n)
+		}
+		if err := c.inst(0x60|tmp, n); err != nil {
+			return err
+		}
+		y = tmp
+	}
+
+	var sub, skip int
+	switch cmp {
+	case ">":
+		sub, skip = 0x5, 0x30
+	case "<":
+		sub, skip = 0x7, 0x30
+	case ">=":
+		sub, skip = 0x7, 0x40
+	case "<=":
+		sub, skip = 0x5, 0x40
+	default:
+		return c.errorf("unknown comparison '%s'", cmp)
+	}
+	if err := c.inst(0x80|tmp, y<<4); err != nil {
+		return err
+	}
+	if err := c.inst(0x80|tmp, x<<4|sub); err != nil {
+		return err
+	}
+	return c.inst(skip|tmp, 1)
+}
+
+// block reads the tokens between a pair of braces.
+func (c *compiler) block() ([]token, error) {
+	if err := c.expect("{"); err != nil {
+		return nil, err
+	}
+	body := []token{}
+	depth := 1
+	for {
+		t, err := c.next()
+		if err != nil {
+			return nil, err
+		}
+		if !t.str {
+			switch t.text {
+			case "{":
+				depth++
+			case "}":
+				depth--
+			}
+		}
+		if depth == 0 {
+			return body, nil
+		}
+		body = append(body, t)
+	}
+}
+
+func (c *compiler) defineMacro() error {
+	name, err := c.next()
+	if err != nil {
+		return err
+	}
+	m := &macro{}
+	for c.peek() != "{" {
+		arg, err := c.next()
+		if err != nil {
+			return err
+		}
+		m.args = append(m.args, arg.text)
+	}
+	if m.body, err = c.block(); err != nil {
+		return err
+	}
+	c.macros[name.text] = m
+	return nil
+}
+
+func (c *compiler) expandMacro(m *macro) error {
+	bindings := map[string]token{}
+	for _, arg := range m.args {
+		t, err := c.next()
+		if err != nil {
+			return err
+		}
+		bindings[arg] = t
+	}
+	bindings["CALLS"] = token{strconv.Itoa(c.calls), false, c.line}
+	c.calls++
+	c.splice(m.body, bindings)
+	return nil
+}
+
+func (c *compiler) defineStringmode() error {
+	name, err := c.next()
+	if err != nil {
+		return err
+	}
+	alphabet, err := c.next()
+	if err != nil {
+		return err
+	}
+	if !alphabet.str {
+		return c.errorf("expected a string, got '%s'", alphabet.text)
+	}
+	body, err := c.block()
+	if err != nil {
+		return err
+	}
+	s := c.stringmodes[name.text]
+	if s == nil {
+		s = &stringmode{map[byte][]token{}, map[byte]int{}}
+		c.stringmodes[name.text] = s
+	}
+	for i := 0; i < len(alphabet.text); i++ {
+		s.bodies[alphabet.text[i]] = body
+		s.values[alphabet.text[i]] = i
+	}
+	return nil
+}
+
+func (c *compiler) expandStringmode(s *stringmode) error {
+	t, err := c.next()
+	if err != nil {
+		return err
+	}
+	if !t.str {
+		return c.errorf("expected a string, got '%s'", t.text)
+	}
+	expanded := []token{}
+	for i := 0; i < len(t.text); i++ {
+		ch := t.text[i]
+		body, ok := s.bodies[ch]
+		if !ok {
+			return c.errorf("string mode does not accept the character '%c'", ch)
+		}
+		bindings := map[string]token{
+			"CHAR":  {strconv.Itoa(int(ch)), false, t.line},
+			"INDEX": {strconv.Itoa(i), false, t.line},
+			"VALUE": {strconv.Itoa(s.values[ch]), false, t.line},
+		}
+		for _, b := range body {
+			if v, ok := bindings[b.text]; ok && !b.str {
+				b = v
+			}
+			expanded = append(expanded, b)
+		}
+	}
+	c.splice(expanded, nil)
+	return nil
+}
+
+// splice inserts tokens at the current position, substituting bindings.
+func (c *compiler) splice(body []token, bindings map[string]token) {
+	expanded := make([]token, 0, len(body)+len(c.tokens)-c.pos)
+	for _, b := range body {
+		if v, ok := bindings[b.text]; ok && !b.str {
+			b = v
+		}
+		expanded = append(expanded, b)
+	}
+	c.tokens = append(c.tokens[:c.pos:c.pos], append(expanded, c.tokens[c.pos:]...)...)
+}
+
+// calc evaluates the { expression } at the current token.
+func (c *compiler) calc() (float64, error) {
+	body, err := c.block()
+	if err != nil {
+		return 0, err
+	}
+	e := &expression{c: c, tokens: body}
+	v, err := e.eval()
+	if err != nil {
+		return 0, err
+	}
+	if e.pos != len(e.tokens) {
+		return 0, c.errorf("unexpected '%s' in expression", e.tokens[e.pos].text)
+	}
+	return v, nil
+}
+
+// expression evaluates Octo calc expressions. Binary operators share one
+// precedence and associate to the right, as in Octo.
+type expression struct {
+	c      *compiler
+	tokens []token
+	pos    int
+}
+
+var unaryOps = map[string]func(float64) float64{
+	"-":     func(x float64) float64 { return -x },
+	"~":     func(x float64) float64 { return float64(^int(x)) },
+	"!":     func(x float64) float64 { return bool2float(x == 0) },
+	"sin":   math.Sin,
+	"cos":   math.Cos,
+	"tan":   math.Tan,
+	"exp":   math.Exp,
+	"log":   math.Log,
+	"abs":   math.Abs,
+	"sqrt":  math.Sqrt,
+	"ceil":  math.Ceil,
+	"floor": math.Floor,
+	"sign": func(x float64) float64 {
+		if x < 0 {
+			return -1
+		} else if x > 0 {
+			return 1
+		}
+		return 0
+	},
+}
+
+var binaryOps = map[string]func(float64, float64) float64{
+	"-":   func(x, y float64) float64 { return x - y },
+	"+":   func(x, y float64) float64 { return x + y },
+	"*":   func(x, y float64) float64 { return x * y },
+	"/":   func(x, y float64) float64 { return x / y },
+	"%":   func(x, y float64) float64 { return float64(int(x) % int(y)) },
+	"&":   func(x, y float64) float64 { return float64(int(x) & int(y)) },
+	"|":   func(x, y float64) float64 { return float64(int(x) | int(y)) },
+	"^":   func(x, y float64) float64 { return float64(int(x) ^ int(y)) },
+	"<<":  func(x, y float64) float64 { return float64(int(x) << uint(y)) },
+	">>":  func(x, y float64) float64 { return float64(int(x) >> uint(y)) },
+	"pow": math.Pow,
+	"min": math.Min,
+	"max": math.Max,
+	"<":   func(x, y float64) float64 { return bool2float(x < y) },
+	">":   func(x, y float64) float64 { return bool2float(x > y) },
+	"<=":  func(x, y float64) float64 { return bool2float(x <= y) },
+	">=":  func(x, y float64) float64 { return bool2float(x >= y) },
+	"==":  func(x, y float64) float64 { return bool2float(x == y) },
+	"!=":  func(x, y float64) float64 { return bool2float(x != y) },
+}
+
+func bool2float(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (e *expression) eval() (float64, error) {
+	x, err := e.term()
+	if err != nil {
+		return 0, err
+	}
+	if e.pos >= len(e.tokens) || e.tokens[e.pos].text == ")" {
+		return x, nil
+	}
+	name := e.tokens[e.pos].text
+	op, ok := binaryOps[name]
+	if !ok {
+		return 0, e.c.errorf("unknown operator '%s' in expression", name)
+	}
+	e.pos++
+	y, err := e.eval()
+	if err != nil {
+		return 0, err
+	}
+	if name == "%" && int(y) == 0 {
+		return 0, e.c.errorf("division by zero in expression")
+	}
+	return op(x, y), nil
+}
+
+func (e *expression) term() (float64, error) {
+	if e.pos >= len(e.tokens) {
+		return 0, e.c.errorf("missing operand in expression")
+	}
+	t := e.tokens[e.pos]
+	e.pos++
+
+	switch t.text {
+	case "(":
+		v, err := e.eval()
+		if err != nil {
+			return 0, err
+		}
+		if e.pos >= len(e.tokens) || e.tokens[e.pos].text != ")" {
+			return 0, e.c.errorf("missing ')' in expression")
+		}
+		e.pos++
+		return v, nil
+	case "@":
+		addr, err := e.term()
+		if err != nil {
+			return 0, err
+		}
+		i := int(addr) - 0x200
+		if i < 0 || i >= len(e.c.rom) {
+			return 0, e.c.errorf("address %d is outside of the program", int(addr))
+		}
+		return float64(e.c.rom[i]), nil
+	case "HERE":
+		return float64(e.c.here), nil
+	case "PI":
+		return math.Pi, nil
+	case "E":
+		return math.E, nil
+	}
+	if op, ok := unaryOps[t.text]; ok {
+		x, err := e.term()
+		if err != nil {
+			return 0, err
+		}
+		return op(x), nil
+	}
+	if v, err := strconv.ParseFloat(t.text, 64); err == nil && !strings.HasPrefix(t.text, "0x") {
+		return v, nil
+	}
+	if v, ok := parseNumber(t.text); ok {
+		return float64(v), nil
+	}
+	if v, ok := e.c.consts[t.text]; ok {
+		return v, nil
+	}
+	if v, ok := e.c.labels[t.text]; ok {
+		return float64(v), nil
+	}
+	if r, ok := e.c.registerIndex(t.text); ok {
+		return float64(r), nil
+	}
+	return 0, e.c.errorf("undefined name '%s' in expression", t.text)
+}
@@ -0,0 +1,189 @@
+package octo
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCompileInstructions(t *testing.T) {
+	for _, tc := range []struct {
+		source string
+		rom    []byte
+	}{
+		{"clear", []byte{0x00, 0xE0}},
+		{"return", []byte{0x00, 0xEE}},
+		{";", []byte{0x00, 0xEE}},
+		{"hires", []byte{0x00, 0xFF}},
+		{"lores", []byte{0x00, 0xFE}},
+		{"exit", []byte{0x00, 0xFD}},
+		{"scroll-down 4", []byte{0x00, 0xC4}},
+		{"scroll-up 2", []byte{0x00, 0xD2}},
+		{"scroll-left", []byte{0x00, 0xFC}},
+		{"scroll-right", []byte{0x00, 0xFB}},
+		{"jump 0x234", []byte{0x12, 0x34}},
+		{"jump0 0x234", []byte{0xB2, 0x34}},
+		{":call 0x345", []byte{0x23, 0x45}},
+		{"native 0x123", []byte{0x01, 0x23}},
+		{"v3 := 0x42", []byte{0x63, 0x42}},
+		{"v3 += 7", []byte{0x73, 0x07}},
+		{"v3 -= 1", []byte{0x73, 0xFF}},
+		{"v1 := v2", []byte{0x81, 0x20}},
+		{"v1 |= v2", []byte{0x81, 0x21}},
+		{"v1 &= v2", []byte{0x81, 0x22}},
+		{"v1 ^= v2", []byte{0x81, 0x23}},
+		{"v1 += v2", []byte{0x81, 0x24}},
+		{"v1 -= v2", []byte{0x81, 0x25}},
+		{"v1 >>= v2", []byte{0x81, 0x26}},
+		{"v1 =- v2", []byte{0x81, 0x27}},
+		{"v1 <<= v2", []byte{0x81, 0x2E}},
+		{"va := random 0x0F", []byte{0xCA, 0x0F}},
+		{"v5 := key", []byte{0xF5, 0x0A}},
+		{"v5 := delay", []byte{0xF5, 0x07}},
+		{"delay := v5", []byte{0xF5, 0x15}},
+		{"buzzer := v5", []byte{0xF5, 0x18}},
+		{"pitch := v5", []byte{0xF5, 0x3A}},
+		{"i := 0x300", []byte{0xA3, 0x00}},
+		{"i += v2", []byte{0xF2, 0x1E}},
+		{"i := hex v2", []byte{0xF2, 0x29}},
+		{"i := bighex v2", []byte{0xF2, 0x30}},
+		{"i := long 0x1234", []byte{0xF0, 0x00, 0x12, 0x34}},
+		{"bcd v4", []byte{0xF4, 0x33}},
+		{"save v4", []byte{0xF4, 0x55}},
+		{"load v4", []byte{0xF4, 0x65}},
+		{"save v2 - v5", []byte{0x52, 0x52}},
+		{"load v2 - v5", []byte{0x52, 0x53}},
+		{"saveflags v3", []byte{0xF3, 0x75}},
+		{"loadflags v3", []byte{0xF3, 0x85}},
+		{"sprite v1 v2 5", []byte{0xD1, 0x25}},
+		{"plane 3", []byte{0xF3, 0x01}},
+		{"audio", []byte{0xF0, 0x02}},
+		{"if v1 == 5 then", []byte{0x41, 0x05}},
+		{"if v1 != 5 then", []byte{0x31, 0x05}},
+		{"if v1 == v2 then", []byte{0x91, 0x20}},
+		{"if v1 != v2 then", []byte{0x51, 0x20}},
+		{"if v1 key then", []byte{0xE1, 0xA1}},
+		{"if v1 -key then", []byte{0xE1, 0x9E}},
+		{"if v1 > v2 then", []byte{0x8F, 0x20, 0x8F, 0x15, 0x3F, 0x01}},
+		{"if v1 < 3 then", []byte{0x6F, 0x03, 0x8F, 0xF0, 0x8F, 0x17, 0x3F, 0x01}},
+		{"1 2 0xFF -1", []byte{0x01, 0x02, 0xFF, 0xFF}},
+		{":byte 0x80", []byte{0x80}},
+	} {
+		t.Run(tc.source, func(t *testing.T) {
+			prog, err := Compile(tc.source, 0x1000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(prog.Rom, tc.rom) {
+				t.Errorf("rom = % X, want % X", prog.Rom, tc.rom)
+			}
+		})
+	}
+}
+
+func TestCompileLabels(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		source string
+		rom    []byte
+		labels map[string]int
+	}{
+		{"backward", ": main jump main", []byte{0x12, 0x00}, map[string]int{"main": 0x200}},
+		{"forward", "jump end clear : end", []byte{0x12, 0x04, 0x00, 0xE0}, map[string]int{"end": 0x204}},
+		{"call by name", ": main draw ; : draw clear ;", []byte{0x22, 0x04, 0x00, 0xEE, 0x00, 0xE0, 0x00, 0xEE}, map[string]int{"main": 0x200, "draw": 0x204}},
+		{"i to data", "i := data : data 0xAA", []byte{0xA2, 0x02, 0xAA}, map[string]int{"data": 0x202}},
+		{"next", ": main :next arg v0 := 7", []byte{0x60, 0x07}, map[string]int{"main": 0x200, "arg": 0x201}},
+		{"pointer", ":pointer data : data", []byte{0x02, 0x02}, map[string]int{"data": 0x202}},
+		{"org", ":org 0x210 : far clear", []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xE0}, map[string]int{"far": 0x210}},
+		{"loop", "loop v0 += 1 again", []byte{0x70, 0x01, 0x12, 0x00}, nil},
+		{"while", "loop while v0 != 3 v0 += 1 again", []byte{0x40, 0x03, 0x12, 0x08, 0x70, 0x01, 0x12, 0x00}, nil},
+		{"begin else end", "if v0 == 1 begin v1 := 1 else v1 := 2 end", []byte{0x30, 0x01, 0x12, 0x08, 0x61, 0x01, 0x12, 0x0A, 0x61, 0x02}, nil},
+		{"unpack", ":unpack 0xA data : data", []byte{0x60, 0xA2, 0x61, 0x04}, map[string]int{"data": 0x204}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			prog, err := Compile(tc.source, 0x1000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(prog.Rom, tc.rom) {
+				t.Errorf("rom = % X, want % X", prog.Rom, tc.rom)
+			}
+			for name, addr := range tc.labels {
+				if prog.Labels[name] != addr {
+					t.Errorf("label %s = %03X, want %03X", name, prog.Labels[name], addr)
+				}
+			}
+		})
+	}
+}
+
+func TestCompileMacros(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		source string
+		rom    []byte
+	}{
+		{"const", ":const SPEED 3 v0 := SPEED", []byte{0x60, 0x03}},
+		{"calc", ":calc HALF { 64 / 2 } v0 := HALF", []byte{0x60, 0x20}},
+		{"alias", ":alias x v4 x := 1", []byte{0x64, 0x01}},
+		{"macro", ":macro set r n { r := n } set v2 9 set v3 8", []byte{0x62, 0x09, 0x63, 0x08}},
+		{"nested macro", ":macro one r { r := 1 } :macro two a b { one a one b } two v1 v2", []byte{0x61, 0x01, 0x62, 0x01}},
+		{"stringmode", ":stringmode digits \"012\" { :byte { VALUE + 0x30 } } digits \"21\"", []byte{0x32, 0x31}},
+		{"breakpoint", "clear :breakpoint here clear", []byte{0x00, 0xE0, 0x00, 0xE0}},
+		{"comments", "# Clear the screen.\nclear # Twice.\nclear", []byte{0x00, 0xE0, 0x00, 0xE0}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			prog, err := Compile(tc.source, 0x1000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(prog.Rom, tc.rom) {
+				t.Errorf("rom = % X, want % X", prog.Rom, tc.rom)
+			}
+		})
+	}
+}
+
+func TestCompileLines(t *testing.T) {
+	prog, err := Compile("clear\n\n: main\n  v0 := 1 # One.\n", 0x1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]int{0x200: 1, 0x201: 1, 0x202: 4, 0x203: 4}
+	for addr, line := range want {
+		if prog.Lines[addr] != line {
+			t.Errorf("line of %03X = %d, want %d", addr, prog.Lines[addr], line)
+		}
+	}
+	if prog, _ := Compile("clear :breakpoint stop clear", 0x1000); prog.Breakpoints[0x202] != "stop" {
+		t.Errorf("breakpoints = %v, want stop at 202", prog.Breakpoints)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, tc := range []struct {
+		source string
+		err    string
+	}{
+		{"jump nowhere", "undefined name 'nowhere'"},
+		{"loop clear", "'loop' without matching 'again'"},
+		{"again", "'again' without matching 'loop'"},
+		{"if v0 == 1 begin clear", "'begin' without matching 'end'"},
+		{"end", "'end' without matching 'begin'"},
+		{": a : a", "'a' has already been defined"},
+		{"v0 := 256", "256"},
+		{"v0 *= v1", "'*='"},
+		{":assert \"too big\" { 1 > 2 }", "too big"},
+		{":org 0x1200 clear", "exceeds"},
+	} {
+		t.Run(tc.source, func(t *testing.T) {
+			_, err := Compile(tc.source, 0x1000)
+			if err == nil {
+				t.Fatalf("compiled without error, want %q", tc.err)
+			}
+			if !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("error = %q, want it to mention %q", err, tc.err)
+			}
+		})
+	}
+}
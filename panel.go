@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/yukinarit/ebiten8/chip8"
+)
+
+const (
+	PANEL_ROWS   = 12 // Lines of the hex dump in the memory panel.
+	CHAR_WIDTH   = 6  // Size of the characters of ebitenutil.DebugPrint.
+	CHAR_HEIGHT  = 16
+	SPRITE_SCALE = 4 // Size of the pixels of the selection drawn as a sprite.
+)
+
+// Background of the bytes which stand out in the memory panel.
+var HIGHLIGHT_COLORS = map[chip8.Highlight]color.Color{
+	chip8.HIGHLIGHT_RECENT:   color.RGBA{0x80, 0x20, 0x20, 0xFF},
+	chip8.HIGHLIGHT_I:        color.RGBA{0x90, 0x60, 0x00, 0xFF},
+	chip8.HIGHLIGHT_PC:       color.RGBA{0x20, 0x70, 0x20, 0xFF},
+	chip8.HIGHLIGHT_SELECTED: color.RGBA{0x20, 0x40, 0x90, 0xFF},
+	chip8.HIGHLIGHT_CURSOR:   color.RGBA{0x50, 0x80, 0xF0, 0xFF},
+}
+
+// Move around the memory panel with the arrows and page keys, change the
+// selection with [ and ], and switch to the registers with Tab. While paused
+// hex digits are typed into the byte or register.
+func updateMemoryPanel(v *chip8.MemoryView, paused bool) {
+	moves := map[ebiten.Key]int{
+		ebiten.KeyLeft:     -1,
+		ebiten.KeyRight:    1,
+		ebiten.KeyUp:       -chip8.MEMVIEW_COLUMNS,
+		ebiten.KeyDown:     chip8.MEMVIEW_COLUMNS,
+		ebiten.KeyPageUp:   -chip8.MEMVIEW_COLUMNS * PANEL_ROWS,
+		ebiten.KeyPageDown: chip8.MEMVIEW_COLUMNS * PANEL_ROWS,
+	}
+	for key, delta := range moves {
+		if inpututil.IsKeyJustPressed(key) {
+			if v.Registers && (key == ebiten.KeyUp || key == ebiten.KeyDown) {
+				delta /= 2 // Four registers to a line.
+			}
+			v.Move(delta)
+		}
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyBracketLeft) {
+		v.Select(-1)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyBracketRight) {
+		v.Select(1)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyTab) {
+		v.Registers = !v.Registers
+	}
+	if !paused {
+		return
+	}
+	for _, key := range inpututil.PressedKeys() {
+		if !inpututil.IsKeyJustPressed(key) {
+			continue
+		}
+		if digit, ok := chip8.HexDigit(key.String()); ok {
+			v.Type(digit)
+		}
+	}
+}
+
+// Draw the memory panel at the right of the screen: the hex dump with its
+// highlights, the selection as a sprite and the registers.
+func drawMemoryPanel(screen *ebiten.Image, v *chip8.MemoryView, paused bool) {
+	lines := v.Lines()
+	width := len(lines[0])*CHAR_WIDTH + 3*CHAR_WIDTH + 8*SPRITE_SCALE
+	sw, _ := screen.Size()
+	x0, y0 := sw-width, CHAR_HEIGHT
+
+	status := "F5 pause"
+	if paused {
+		status = "PAUSED  F5 run  F6 step  Tab registers"
+	}
+	lines = append(lines, "")
+	lines = append(lines, v.RegisterLines()...)
+	lines = append(lines, "", status)
+	ebitenutil.DrawRect(screen, float64(x0), float64(y0), float64(width), float64(len(lines)*CHAR_HEIGHT), color.RGBA{0, 0, 0, 0xC0})
+
+	for addr := v.Start; addr < v.Start+PANEL_ROWS*chip8.MEMVIEW_COLUMNS; addr++ {
+		clr, ok := HIGHLIGHT_COLORS[v.Highlight(addr)]
+		line, column, ascii, shown := v.Cell(addr)
+		if !ok || !shown {
+			continue
+		}
+		y := float64(y0 + line*CHAR_HEIGHT)
+		ebitenutil.DrawRect(screen, float64(x0+column*CHAR_WIDTH), y, 2*CHAR_WIDTH+1, CHAR_HEIGHT, clr)
+		ebitenutil.DrawRect(screen, float64(x0+ascii*CHAR_WIDTH), y, CHAR_WIDTH+1, CHAR_HEIGHT, clr)
+	}
+	ebitenutil.DebugPrintAt(screen, strings.Join(lines, "\n"), x0, y0)
+
+	// The selection as a sprite, a byte to a row.
+	sx := x0 + width - 8*SPRITE_SCALE
+	for row, b := range v.Selection() {
+		for bit := 0; bit < 8; bit++ {
+			clr := color.Color(color.RGBA{0x30, 0x30, 0x30, 0xFF})
+			if b&(0x80>>bit) != 0 {
+				clr = color.White
+			}
+			ebitenutil.DrawRect(screen, float64(sx+bit*SPRITE_SCALE), float64(y0+row*SPRITE_SCALE), SPRITE_SCALE, SPRITE_SCALE, clr)
+		}
+	}
+}
+
+// Size of a sprite of the gallery, under its address.
+const (
+	GALLERY_SCALE       = 3
+	GALLERY_CELL_WIDTH  = 8*GALLERY_SCALE + 2*CHAR_WIDTH
+	GALLERY_CELL_HEIGHT = CHAR_HEIGHT + 15*GALLERY_SCALE + 4
+)
+
+// Draw every sprite the program drew so far in a grid, each under its
+// address.
+func drawSpriteGallery(screen *ebiten.Image, sprites []chip8.Sprite, ram []byte) {
+	sw, sh := screen.Size()
+	columns := sw / GALLERY_CELL_WIDTH
+	if columns < 1 {
+		columns = 1
+	}
+	rows := (len(sprites) + columns - 1) / columns
+	y0 := CHAR_HEIGHT
+	ebitenutil.DrawRect(screen, 0, float64(y0), float64(sw), float64(rows*GALLERY_CELL_HEIGHT), color.RGBA{0, 0, 0, 0xC0})
+	for n, s := range sprites {
+		x, y := n%columns*GALLERY_CELL_WIDTH, y0+n/columns*GALLERY_CELL_HEIGHT
+		if y >= sh {
+			break
+		}
+		ebitenutil.DebugPrintAt(screen, fmt.Sprintf("%03X", s.Addr), x, y)
+		y += CHAR_HEIGHT
+		ebitenutil.DrawRect(screen, float64(x), float64(y), 8*GALLERY_SCALE, float64(s.Height*GALLERY_SCALE), color.RGBA{0x30, 0x30, 0x30, 0xFF})
+		for row, b := range s.Data(ram) {
+			for bit := 0; bit < 8; bit++ {
+				if b&(0x80>>bit) != 0 {
+					ebitenutil.DrawRect(screen, float64(x+bit*GALLERY_SCALE), float64(y+row*GALLERY_SCALE), GALLERY_SCALE, GALLERY_SCALE, color.White)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import "github.com/yukinarit/ebiten8/chip8"
+
+// Read the settings from the user config directory.
+func loadSettings() (chip8.Settings, error) {
+	path, err := chip8.SettingsPath()
+	if err != nil {
+		return chip8.Settings{}, err
+	}
+	return chip8.LoadSettings(path)
+}
+
+func saveSettings(s chip8.Settings) error {
+	path, err := chip8.SettingsPath()
+	if err != nil {
+		return err
+	}
+	return s.Save(path)
+}